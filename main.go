@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/playbody/train-ticket-service/proto"
@@ -8,6 +9,7 @@ import (
 	"google.golang.org/grpc"
 	"log"
 	"net"
+	"time"
 )
 
 var (
@@ -20,17 +22,37 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to init config: %v", err)
 	}
+	if err := server.SKeys.InitKeys(server.SConfig.Auth); err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
+	for _, kid := range server.SKeys.Generated() {
+		log.Printf("Generated missing signing key %s", kid)
+	}
+	for _, override := range server.PolicyOverrides() {
+		log.Printf("Policy overridden by config: %s", override)
+	}
 	flag.Parse()
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer(grpc.UnaryInterceptor(server.ParseJWTMiddleware))
 	var trainServer = &server.TrainServer{
 		Conf: &server.SConfig.Train,
 	}
+	if server.SConfig.Storage.Dir != "" {
+		store, err := server.OpenFileStore(&server.SConfig.Train, server.SConfig.Storage)
+		if err != nil {
+			log.Fatalf("Failed to open storage: %v", err)
+		}
+		defer store.Close()
+		trainServer.Store = store
+	}
 	trainServer.InitServer()
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(trainServer.ParseJWTMiddleware, trainServer.PolicyMiddleware))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trainServer.StartHoldReaper(ctx, 10*time.Second)
 	proto.RegisterTrainServiceServer(s, trainServer)
 	log.Printf("server listening at %v", listener.Addr())
 	if err := s.Serve(listener); err != nil {
@@ -22,8 +22,20 @@ const _ = grpc.SupportPackageIsVersion7
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type TrainServiceClient interface {
-	// Auth User
+	// Auth User, same as Login
 	AuthUser(ctx context.Context, in *AuthRequest, opts ...grpc.CallOption) (*AuthResponse, error)
+	// An API to create an account with a password
+	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error)
+	// An API that checks the password of an account and issues a token
+	Login(ctx context.Context, in *AuthRequest, opts ...grpc.CallOption) (*AuthResponse, error)
+	// An API that swaps a refresh token for a new pair of tokens
+	RefreshToken(ctx context.Context, in *RefreshTokenRequest, opts ...grpc.CallOption) (*AuthResponse, error)
+	// An API that revokes the caller's access token and its refresh token
+	Logout(ctx context.Context, in *LogoutRequest, opts ...grpc.CallOption) (*LogoutResponse, error)
+	// An API that revokes any token, for its owner or an admin
+	RevokeToken(ctx context.Context, in *RevokeTokenRequest, opts ...grpc.CallOption) (*RevokeTokenResponse, error)
+	// The JSON Web Key Set of the public keys tokens are signed with
+	GetJWKS(ctx context.Context, in *JWKSRequest, opts ...grpc.CallOption) (*JWKSResponse, error)
 	// Get All Routes
 	GetAllRoutes(ctx context.Context, in *RouteRequest, opts ...grpc.CallOption) (*RouteResponse, error)
 	// An API where we can submit a purchase for a ticket.
@@ -36,6 +48,34 @@ type TrainServiceClient interface {
 	RemoveUser(ctx context.Context, in *RemoveUserRequest, opts ...grpc.CallOption) (*RemoveUserResponse, error)
 	// An API to modify a user’s seat
 	ModifySeat(ctx context.Context, in *ModifySeatRequest, opts ...grpc.CallOption) (*ModifySeatResponse, error)
+	// An API that lists every booking of a user
+	ListBookings(ctx context.Context, in *ListBookingsRequest, opts ...grpc.CallOption) (*ListBookingsResponse, error)
+	// An API to purchase seats next to each other for several passengers
+	PurchaseGroup(ctx context.Context, in *PurchaseGroupRequest, opts ...grpc.CallOption) (*PurchaseGroupResponse, error)
+	// An API that shows the state of every seat of a section
+	GetSeatMap(ctx context.Context, in *SeatMapRequest, opts ...grpc.CallOption) (*SeatMapResponse, error)
+	// An API to hold a seat for a limited time while the payment is made
+	HoldSeat(ctx context.Context, in *PurchaseRequest, opts ...grpc.CallOption) (*HoldResponse, error)
+	// An API to turn a hold into a purchased ticket
+	ConfirmHold(ctx context.Context, in *ConfirmHoldRequest, opts ...grpc.CallOption) (*PurchaseResponse, error)
+	// An API to give up a hold before it expires
+	ReleaseHold(ctx context.Context, in *ReleaseHoldRequest, opts ...grpc.CallOption) (*ReleaseHoldResponse, error)
+	// An API to queue for a seat on a sold out train
+	JoinWaitlist(ctx context.Context, in *JoinWaitlistRequest, opts ...grpc.CallOption) (*JoinWaitlistResponse, error)
+	// An API that shows a refund issued when a user was removed
+	GetRefund(ctx context.Context, in *RefundRequest, opts ...grpc.CallOption) (*RefundResponse, error)
+	// An API that prices a trip and holds that price for a short while
+	QuoteFare(ctx context.Context, in *QuoteFareRequest, opts ...grpc.CallOption) (*QuoteFareResponse, error)
+	// An API to create a discount voucher (Admin API)
+	CreateVoucher(ctx context.Context, in *Voucher, opts ...grpc.CallOption) (*Voucher, error)
+	// An API that lists every voucher with its usage (Admin API)
+	ListVouchers(ctx context.Context, in *ListVouchersRequest, opts ...grpc.CallOption) (*ListVouchersResponse, error)
+	// An API to withdraw a voucher (Admin API)
+	DeleteVoucher(ctx context.Context, in *DeleteVoucherRequest, opts ...grpc.CallOption) (*Voucher, error)
+	// An API that finds direct and connecting journeys between two stations
+	SearchJourneys(ctx context.Context, in *SearchJourneysRequest, opts ...grpc.CallOption) (*SearchJourneysResponse, error)
+	// An API to purchase a seat on every leg of a journey at once
+	PurchaseJourney(ctx context.Context, in *PurchaseJourneyRequest, opts ...grpc.CallOption) (*PurchaseJourneyResponse, error)
 }
 
 type trainServiceClient struct {
@@ -55,6 +95,60 @@ func (c *trainServiceClient) AuthUser(ctx context.Context, in *AuthRequest, opts
 	return out, nil
 }
 
+func (c *trainServiceClient) Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error) {
+	out := new(RegisterResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/Register", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) Login(ctx context.Context, in *AuthRequest, opts ...grpc.CallOption) (*AuthResponse, error) {
+	out := new(AuthResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/Login", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) RefreshToken(ctx context.Context, in *RefreshTokenRequest, opts ...grpc.CallOption) (*AuthResponse, error) {
+	out := new(AuthResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/RefreshToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) Logout(ctx context.Context, in *LogoutRequest, opts ...grpc.CallOption) (*LogoutResponse, error) {
+	out := new(LogoutResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/Logout", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) RevokeToken(ctx context.Context, in *RevokeTokenRequest, opts ...grpc.CallOption) (*RevokeTokenResponse, error) {
+	out := new(RevokeTokenResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/RevokeToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) GetJWKS(ctx context.Context, in *JWKSRequest, opts ...grpc.CallOption) (*JWKSResponse, error) {
+	out := new(JWKSResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/GetJWKS", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *trainServiceClient) GetAllRoutes(ctx context.Context, in *RouteRequest, opts ...grpc.CallOption) (*RouteResponse, error) {
 	out := new(RouteResponse)
 	err := c.cc.Invoke(ctx, "/train.TrainService/GetAllRoutes", in, out, opts...)
@@ -109,12 +203,150 @@ func (c *trainServiceClient) ModifySeat(ctx context.Context, in *ModifySeatReque
 	return out, nil
 }
 
+func (c *trainServiceClient) ListBookings(ctx context.Context, in *ListBookingsRequest, opts ...grpc.CallOption) (*ListBookingsResponse, error) {
+	out := new(ListBookingsResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/ListBookings", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) PurchaseGroup(ctx context.Context, in *PurchaseGroupRequest, opts ...grpc.CallOption) (*PurchaseGroupResponse, error) {
+	out := new(PurchaseGroupResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/PurchaseGroup", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) GetSeatMap(ctx context.Context, in *SeatMapRequest, opts ...grpc.CallOption) (*SeatMapResponse, error) {
+	out := new(SeatMapResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/GetSeatMap", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) HoldSeat(ctx context.Context, in *PurchaseRequest, opts ...grpc.CallOption) (*HoldResponse, error) {
+	out := new(HoldResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/HoldSeat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) ConfirmHold(ctx context.Context, in *ConfirmHoldRequest, opts ...grpc.CallOption) (*PurchaseResponse, error) {
+	out := new(PurchaseResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/ConfirmHold", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) ReleaseHold(ctx context.Context, in *ReleaseHoldRequest, opts ...grpc.CallOption) (*ReleaseHoldResponse, error) {
+	out := new(ReleaseHoldResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/ReleaseHold", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) JoinWaitlist(ctx context.Context, in *JoinWaitlistRequest, opts ...grpc.CallOption) (*JoinWaitlistResponse, error) {
+	out := new(JoinWaitlistResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/JoinWaitlist", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) GetRefund(ctx context.Context, in *RefundRequest, opts ...grpc.CallOption) (*RefundResponse, error) {
+	out := new(RefundResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/GetRefund", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) QuoteFare(ctx context.Context, in *QuoteFareRequest, opts ...grpc.CallOption) (*QuoteFareResponse, error) {
+	out := new(QuoteFareResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/QuoteFare", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) CreateVoucher(ctx context.Context, in *Voucher, opts ...grpc.CallOption) (*Voucher, error) {
+	out := new(Voucher)
+	err := c.cc.Invoke(ctx, "/train.TrainService/CreateVoucher", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) ListVouchers(ctx context.Context, in *ListVouchersRequest, opts ...grpc.CallOption) (*ListVouchersResponse, error) {
+	out := new(ListVouchersResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/ListVouchers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) DeleteVoucher(ctx context.Context, in *DeleteVoucherRequest, opts ...grpc.CallOption) (*Voucher, error) {
+	out := new(Voucher)
+	err := c.cc.Invoke(ctx, "/train.TrainService/DeleteVoucher", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) SearchJourneys(ctx context.Context, in *SearchJourneysRequest, opts ...grpc.CallOption) (*SearchJourneysResponse, error) {
+	out := new(SearchJourneysResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/SearchJourneys", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trainServiceClient) PurchaseJourney(ctx context.Context, in *PurchaseJourneyRequest, opts ...grpc.CallOption) (*PurchaseJourneyResponse, error) {
+	out := new(PurchaseJourneyResponse)
+	err := c.cc.Invoke(ctx, "/train.TrainService/PurchaseJourney", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // TrainServiceServer is the server API for TrainService service.
 // All implementations must embed UnimplementedTrainServiceServer
 // for forward compatibility
 type TrainServiceServer interface {
-	// Auth User
+	// Auth User, same as Login
 	AuthUser(context.Context, *AuthRequest) (*AuthResponse, error)
+	// An API to create an account with a password
+	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
+	// An API that checks the password of an account and issues a token
+	Login(context.Context, *AuthRequest) (*AuthResponse, error)
+	// An API that swaps a refresh token for a new pair of tokens
+	RefreshToken(context.Context, *RefreshTokenRequest) (*AuthResponse, error)
+	// An API that revokes the caller's access token and its refresh token
+	Logout(context.Context, *LogoutRequest) (*LogoutResponse, error)
+	// An API that revokes any token, for its owner or an admin
+	RevokeToken(context.Context, *RevokeTokenRequest) (*RevokeTokenResponse, error)
+	// The JSON Web Key Set of the public keys tokens are signed with
+	GetJWKS(context.Context, *JWKSRequest) (*JWKSResponse, error)
 	// Get All Routes
 	GetAllRoutes(context.Context, *RouteRequest) (*RouteResponse, error)
 	// An API where we can submit a purchase for a ticket.
@@ -127,6 +359,34 @@ type TrainServiceServer interface {
 	RemoveUser(context.Context, *RemoveUserRequest) (*RemoveUserResponse, error)
 	// An API to modify a user’s seat
 	ModifySeat(context.Context, *ModifySeatRequest) (*ModifySeatResponse, error)
+	// An API that lists every booking of a user
+	ListBookings(context.Context, *ListBookingsRequest) (*ListBookingsResponse, error)
+	// An API to purchase seats next to each other for several passengers
+	PurchaseGroup(context.Context, *PurchaseGroupRequest) (*PurchaseGroupResponse, error)
+	// An API that shows the state of every seat of a section
+	GetSeatMap(context.Context, *SeatMapRequest) (*SeatMapResponse, error)
+	// An API to hold a seat for a limited time while the payment is made
+	HoldSeat(context.Context, *PurchaseRequest) (*HoldResponse, error)
+	// An API to turn a hold into a purchased ticket
+	ConfirmHold(context.Context, *ConfirmHoldRequest) (*PurchaseResponse, error)
+	// An API to give up a hold before it expires
+	ReleaseHold(context.Context, *ReleaseHoldRequest) (*ReleaseHoldResponse, error)
+	// An API to queue for a seat on a sold out train
+	JoinWaitlist(context.Context, *JoinWaitlistRequest) (*JoinWaitlistResponse, error)
+	// An API that shows a refund issued when a user was removed
+	GetRefund(context.Context, *RefundRequest) (*RefundResponse, error)
+	// An API that prices a trip and holds that price for a short while
+	QuoteFare(context.Context, *QuoteFareRequest) (*QuoteFareResponse, error)
+	// An API to create a discount voucher (Admin API)
+	CreateVoucher(context.Context, *Voucher) (*Voucher, error)
+	// An API that lists every voucher with its usage (Admin API)
+	ListVouchers(context.Context, *ListVouchersRequest) (*ListVouchersResponse, error)
+	// An API to withdraw a voucher (Admin API)
+	DeleteVoucher(context.Context, *DeleteVoucherRequest) (*Voucher, error)
+	// An API that finds direct and connecting journeys between two stations
+	SearchJourneys(context.Context, *SearchJourneysRequest) (*SearchJourneysResponse, error)
+	// An API to purchase a seat on every leg of a journey at once
+	PurchaseJourney(context.Context, *PurchaseJourneyRequest) (*PurchaseJourneyResponse, error)
 	mustEmbedUnimplementedTrainServiceServer()
 }
 
@@ -137,6 +397,24 @@ type UnimplementedTrainServiceServer struct {
 func (UnimplementedTrainServiceServer) AuthUser(context.Context, *AuthRequest) (*AuthResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method AuthUser not implemented")
 }
+func (UnimplementedTrainServiceServer) Register(context.Context, *RegisterRequest) (*RegisterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedTrainServiceServer) Login(context.Context, *AuthRequest) (*AuthResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Login not implemented")
+}
+func (UnimplementedTrainServiceServer) RefreshToken(context.Context, *RefreshTokenRequest) (*AuthResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RefreshToken not implemented")
+}
+func (UnimplementedTrainServiceServer) Logout(context.Context, *LogoutRequest) (*LogoutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Logout not implemented")
+}
+func (UnimplementedTrainServiceServer) RevokeToken(context.Context, *RevokeTokenRequest) (*RevokeTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeToken not implemented")
+}
+func (UnimplementedTrainServiceServer) GetJWKS(context.Context, *JWKSRequest) (*JWKSResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetJWKS not implemented")
+}
 func (UnimplementedTrainServiceServer) GetAllRoutes(context.Context, *RouteRequest) (*RouteResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetAllRoutes not implemented")
 }
@@ -155,6 +433,48 @@ func (UnimplementedTrainServiceServer) RemoveUser(context.Context, *RemoveUserRe
 func (UnimplementedTrainServiceServer) ModifySeat(context.Context, *ModifySeatRequest) (*ModifySeatResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ModifySeat not implemented")
 }
+func (UnimplementedTrainServiceServer) ListBookings(context.Context, *ListBookingsRequest) (*ListBookingsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBookings not implemented")
+}
+func (UnimplementedTrainServiceServer) PurchaseGroup(context.Context, *PurchaseGroupRequest) (*PurchaseGroupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PurchaseGroup not implemented")
+}
+func (UnimplementedTrainServiceServer) GetSeatMap(context.Context, *SeatMapRequest) (*SeatMapResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSeatMap not implemented")
+}
+func (UnimplementedTrainServiceServer) HoldSeat(context.Context, *PurchaseRequest) (*HoldResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HoldSeat not implemented")
+}
+func (UnimplementedTrainServiceServer) ConfirmHold(context.Context, *ConfirmHoldRequest) (*PurchaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConfirmHold not implemented")
+}
+func (UnimplementedTrainServiceServer) ReleaseHold(context.Context, *ReleaseHoldRequest) (*ReleaseHoldResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseHold not implemented")
+}
+func (UnimplementedTrainServiceServer) JoinWaitlist(context.Context, *JoinWaitlistRequest) (*JoinWaitlistResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method JoinWaitlist not implemented")
+}
+func (UnimplementedTrainServiceServer) GetRefund(context.Context, *RefundRequest) (*RefundResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRefund not implemented")
+}
+func (UnimplementedTrainServiceServer) QuoteFare(context.Context, *QuoteFareRequest) (*QuoteFareResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QuoteFare not implemented")
+}
+func (UnimplementedTrainServiceServer) CreateVoucher(context.Context, *Voucher) (*Voucher, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateVoucher not implemented")
+}
+func (UnimplementedTrainServiceServer) ListVouchers(context.Context, *ListVouchersRequest) (*ListVouchersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVouchers not implemented")
+}
+func (UnimplementedTrainServiceServer) DeleteVoucher(context.Context, *DeleteVoucherRequest) (*Voucher, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteVoucher not implemented")
+}
+func (UnimplementedTrainServiceServer) SearchJourneys(context.Context, *SearchJourneysRequest) (*SearchJourneysResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchJourneys not implemented")
+}
+func (UnimplementedTrainServiceServer) PurchaseJourney(context.Context, *PurchaseJourneyRequest) (*PurchaseJourneyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PurchaseJourney not implemented")
+}
 func (UnimplementedTrainServiceServer) mustEmbedUnimplementedTrainServiceServer() {}
 
 // UnsafeTrainServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -186,6 +506,114 @@ func _TrainService_AuthUser_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _TrainService_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/Register",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).Register(ctx, req.(*RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_Login_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).Login(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/Login",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).Login(ctx, req.(*AuthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_RefreshToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RefreshTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).RefreshToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/RefreshToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).RefreshToken(ctx, req.(*RefreshTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_Logout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogoutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).Logout(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/Logout",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).Logout(ctx, req.(*LogoutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_RevokeToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).RevokeToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/RevokeToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).RevokeToken(ctx, req.(*RevokeTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_GetJWKS_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(JWKSRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).GetJWKS(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/GetJWKS",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).GetJWKS(ctx, req.(*JWKSRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _TrainService_GetAllRoutes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(RouteRequest)
 	if err := dec(in); err != nil {
@@ -294,6 +722,258 @@ func _TrainService_ModifySeat_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _TrainService_ListBookings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBookingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).ListBookings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/ListBookings",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).ListBookings(ctx, req.(*ListBookingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_PurchaseGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurchaseGroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).PurchaseGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/PurchaseGroup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).PurchaseGroup(ctx, req.(*PurchaseGroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_GetSeatMap_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SeatMapRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).GetSeatMap(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/GetSeatMap",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).GetSeatMap(ctx, req.(*SeatMapRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_HoldSeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurchaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).HoldSeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/HoldSeat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).HoldSeat(ctx, req.(*PurchaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_ConfirmHold_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConfirmHoldRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).ConfirmHold(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/ConfirmHold",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).ConfirmHold(ctx, req.(*ConfirmHoldRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_ReleaseHold_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseHoldRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).ReleaseHold(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/ReleaseHold",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).ReleaseHold(ctx, req.(*ReleaseHoldRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_JoinWaitlist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(JoinWaitlistRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).JoinWaitlist(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/JoinWaitlist",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).JoinWaitlist(ctx, req.(*JoinWaitlistRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_GetRefund_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RefundRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).GetRefund(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/GetRefund",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).GetRefund(ctx, req.(*RefundRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_QuoteFare_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QuoteFareRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).QuoteFare(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/QuoteFare",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).QuoteFare(ctx, req.(*QuoteFareRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_CreateVoucher_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Voucher)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).CreateVoucher(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/CreateVoucher",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).CreateVoucher(ctx, req.(*Voucher))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_ListVouchers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVouchersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).ListVouchers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/ListVouchers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).ListVouchers(ctx, req.(*ListVouchersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_DeleteVoucher_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteVoucherRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).DeleteVoucher(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/DeleteVoucher",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).DeleteVoucher(ctx, req.(*DeleteVoucherRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_SearchJourneys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchJourneysRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).SearchJourneys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/SearchJourneys",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).SearchJourneys(ctx, req.(*SearchJourneysRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TrainService_PurchaseJourney_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurchaseJourneyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrainServiceServer).PurchaseJourney(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/train.TrainService/PurchaseJourney",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrainServiceServer).PurchaseJourney(ctx, req.(*PurchaseJourneyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // TrainService_ServiceDesc is the grpc.ServiceDesc for TrainService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -305,6 +985,30 @@ var TrainService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "AuthUser",
 			Handler:    _TrainService_AuthUser_Handler,
 		},
+		{
+			MethodName: "Register",
+			Handler:    _TrainService_Register_Handler,
+		},
+		{
+			MethodName: "Login",
+			Handler:    _TrainService_Login_Handler,
+		},
+		{
+			MethodName: "RefreshToken",
+			Handler:    _TrainService_RefreshToken_Handler,
+		},
+		{
+			MethodName: "Logout",
+			Handler:    _TrainService_Logout_Handler,
+		},
+		{
+			MethodName: "RevokeToken",
+			Handler:    _TrainService_RevokeToken_Handler,
+		},
+		{
+			MethodName: "GetJWKS",
+			Handler:    _TrainService_GetJWKS_Handler,
+		},
 		{
 			MethodName: "GetAllRoutes",
 			Handler:    _TrainService_GetAllRoutes_Handler,
@@ -329,6 +1033,62 @@ var TrainService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ModifySeat",
 			Handler:    _TrainService_ModifySeat_Handler,
 		},
+		{
+			MethodName: "ListBookings",
+			Handler:    _TrainService_ListBookings_Handler,
+		},
+		{
+			MethodName: "PurchaseGroup",
+			Handler:    _TrainService_PurchaseGroup_Handler,
+		},
+		{
+			MethodName: "GetSeatMap",
+			Handler:    _TrainService_GetSeatMap_Handler,
+		},
+		{
+			MethodName: "HoldSeat",
+			Handler:    _TrainService_HoldSeat_Handler,
+		},
+		{
+			MethodName: "ConfirmHold",
+			Handler:    _TrainService_ConfirmHold_Handler,
+		},
+		{
+			MethodName: "ReleaseHold",
+			Handler:    _TrainService_ReleaseHold_Handler,
+		},
+		{
+			MethodName: "JoinWaitlist",
+			Handler:    _TrainService_JoinWaitlist_Handler,
+		},
+		{
+			MethodName: "GetRefund",
+			Handler:    _TrainService_GetRefund_Handler,
+		},
+		{
+			MethodName: "QuoteFare",
+			Handler:    _TrainService_QuoteFare_Handler,
+		},
+		{
+			MethodName: "CreateVoucher",
+			Handler:    _TrainService_CreateVoucher_Handler,
+		},
+		{
+			MethodName: "ListVouchers",
+			Handler:    _TrainService_ListVouchers_Handler,
+		},
+		{
+			MethodName: "DeleteVoucher",
+			Handler:    _TrainService_DeleteVoucher_Handler,
+		},
+		{
+			MethodName: "SearchJourneys",
+			Handler:    _TrainService_SearchJourneys_Handler,
+		},
+		{
+			MethodName: "PurchaseJourney",
+			Handler:    _TrainService_PurchaseJourney_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/train.proto",
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	minPasswordLength = 8
+	// maxPasswordLength is the most bcrypt hashes, longer passwords would be
+	// silently truncated.
+	maxPasswordLength  = 72
+	defaultMaxAttempts = 5
+	defaultLockout     = 15 * 60 // seconds
+)
+
+// dummyHash is compared against when logging in with an unknown email, so
+// it does not answer faster than a wrong password would.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// loginFailures counts the failed logins of an email in a row.
+type loginFailures struct {
+	count       int
+	lockedUntil int64
+}
+
+// loginGuard locks an email out after too many failed logins. Failures only
+// matter for a short while, so they are not persisted.
+type loginGuard struct {
+	mu       sync.Mutex
+	failures map[string]loginFailures
+}
+
+// lockedOut reports whether email may not log in at now.
+func (g *loginGuard) lockedOut(email string, now int64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.failures[email].lockedUntil > now
+}
+
+// fail records a failed login of email, locking it out for lockout seconds
+// once maxAttempts have failed in a row.
+func (g *loginGuard) fail(email string, now int64, maxAttempts, lockout int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.failures == nil {
+		g.failures = map[string]loginFailures{}
+	}
+	f := g.failures[email]
+	if f.lockedUntil != 0 && f.lockedUntil <= now {
+		f = loginFailures{}
+	}
+	f.count++
+	if f.count >= maxAttempts {
+		f.lockedUntil = now + int64(lockout)
+	}
+	g.failures[email] = f
+}
+
+// reset forgets the failed logins of email.
+func (g *loginGuard) reset(email string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.failures, email)
+}
+
+// roleUser returns the configured role user of email.
+func roleUser(email string) (RoleUser, bool) {
+	for _, user := range SConfig.RoleUsers {
+		if user.Email == email {
+			return user, true
+		}
+	}
+	return RoleUser{}, false
+}
+
+func maxAttempts() int {
+	if SConfig.Auth.MaxAttempts > 0 {
+		return SConfig.Auth.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+func lockoutSeconds() int {
+	if SConfig.Auth.Lockout > 0 {
+		return SConfig.Auth.Lockout
+	}
+	return defaultLockout
+}
+
+func bcryptCost() int {
+	if SConfig.Auth.BcryptCost > 0 {
+		return SConfig.Auth.BcryptCost
+	}
+	return bcrypt.DefaultCost
+}
+
+// Register creates an account which can then log in with its password.
+func (s *TrainServer) Register(_ context.Context, req *proto.RegisterRequest) (*proto.RegisterResponse, error) {
+	if req.User == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "user must not be empty")
+	}
+	if _, err := isValidUser(req.User); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid user: %v", err)
+	}
+	if len(req.Password) < minPasswordLength || len(req.Password) > maxPasswordLength {
+		return nil, status.Errorf(codes.InvalidArgument, "password must be %d to %d bytes long", minPasswordLength, maxPasswordLength)
+	}
+	email := req.User.Email
+	if _, ok := roleUser(email); ok {
+		return nil, status.Errorf(codes.AlreadyExists, "%v", errAccountExists)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcryptCost())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot hash password: %v", err)
+	}
+	err = s.Store.AddAccount(Account{
+		Email:        email,
+		FirstName:    req.User.FirstName,
+		LastName:     req.User.LastName,
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now().Unix(),
+	})
+	if errors.Is(err, errAccountExists) {
+		return nil, status.Errorf(codes.AlreadyExists, "%v", err)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot register: %v", err)
+	}
+	return &proto.RegisterResponse{Email: email, Message: "Registered successfully"}, nil
+}
+
+// Login checks the password of a registered account or role user and issues
+// an access token carrying the capabilities configured for the email, and a
+// refresh token.
+func (s *TrainServer) Login(_ context.Context, req *proto.AuthRequest) (*proto.AuthResponse, error) {
+	email := req.Email
+	now := time.Now().Unix()
+	if s.logins.lockedOut(email, now) {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many failed logins, try again later")
+	}
+	role, isRole := roleUser(email)
+	hash := role.PasswordHash
+	if !isRole {
+		if account, ok := s.Store.LookupAccount(email); ok {
+			hash = account.PasswordHash
+		}
+	}
+	if hash == "" {
+		// Compare anyway so unknown emails take as long as wrong passwords.
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(req.Password))
+		s.logins.fail(email, now, maxAttempts(), lockoutSeconds())
+		return nil, status.Errorf(codes.Unauthenticated, "invalid email or password")
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)); err != nil {
+		s.logins.fail(email, now, maxAttempts(), lockoutSeconds())
+		return nil, status.Errorf(codes.Unauthenticated, "invalid email or password")
+	}
+	s.logins.reset(email)
+	return issueTokens(email, role.Capabilities)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTrainServer_Register(t *testing.T) {
+	s := newTestServer(t, nil)
+	ctx := context.Background()
+	user := &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2}
+
+	resp, err := s.Register(ctx, &proto.RegisterRequest{User: user, Password: "s3cret-pass"})
+	assert.NoError(t, err)
+	assert.Equal(t, email2, resp.Email)
+	account, ok := s.Store.LookupAccount(email2)
+	assert.True(t, ok)
+	assert.NotEqual(t, "s3cret-pass", account.PasswordHash)
+
+	_, err = s.Register(ctx, &proto.RegisterRequest{User: user, Password: "another-pass"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	// Role users log in with the password set in the config.
+	admin := &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1}
+	_, err = s.Register(ctx, &proto.RegisterRequest{User: admin, Password: "takeover!"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	other := &proto.User{FirstName: firstName3, LastName: lastName3, Email: email3}
+	_, err = s.Register(ctx, &proto.RegisterRequest{User: other, Password: "short"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	for _, user := range []*proto.User{
+		{FirstName: firstName3, LastName: lastName3, Email: "not an email"},
+		{Email: email3},
+		{FirstName: firstName3, Email: email3},
+		{FirstName: firstName3, LastName: lastName3, Email: email3, PassengerType: 99},
+		nil,
+	} {
+		_, err = s.Register(ctx, &proto.RegisterRequest{User: user, Password: "long enough"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "%v", user)
+	}
+	_, ok = s.Store.LookupAccount(email3)
+	assert.False(t, ok)
+}
+
+func TestTrainServer_Login(t *testing.T) {
+	ctx := context.Background()
+	newServer := func() *TrainServer {
+		s := newTestServer(t, nil)
+		user := &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2}
+		_, err := s.Register(ctx, &proto.RegisterRequest{User: user, Password: "s3cret-pass"})
+		assert.NoError(t, err)
+		return s
+	}
+	claims := func(token string) JwtClaims {
+		c, ok := tokenContext(t, server, token).Value("jwt").(JwtClaims)
+		assert.True(t, ok)
+		return c
+	}
+
+	t.Run("registered user", func(t *testing.T) {
+		s := newServer()
+		resp, err := s.Login(ctx, &proto.AuthRequest{Email: email2, Password: "s3cret-pass"})
+		assert.NoError(t, err)
+		c := claims(resp.Token)
+		assert.Equal(t, email2, c.UserID)
+		assert.Empty(t, c.Capabilities)
+	})
+
+	t.Run("role user keeps capabilities", func(t *testing.T) {
+		s := newServer()
+		resp, err := s.AuthUser(ctx, &proto.AuthRequest{Email: email1, Password: password1})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{CapAdmin, CapRead, CapWrite}, claims(resp.Token).Capabilities)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		s := newServer()
+		for _, req := range []*proto.AuthRequest{
+			{Email: email2, Password: "wrong-pass"},
+			{Email: email1},
+			{Email: email4, Password: "s3cret-pass"},
+		} {
+			_, err := s.Login(ctx, req)
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+			assert.EqualError(t, err, "rpc error: code = Unauthenticated desc = invalid email or password")
+		}
+	})
+
+	t.Run("lockout", func(t *testing.T) {
+		s := newServer()
+		SConfig.Auth.MaxAttempts = 2
+		defer func() { SConfig.Auth.MaxAttempts = 0 }()
+		_, err := s.Login(ctx, &proto.AuthRequest{Email: email2, Password: "wrong-pass"})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		// A success in between starts the count again.
+		_, err = s.Login(ctx, &proto.AuthRequest{Email: email2, Password: "s3cret-pass"})
+		assert.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			_, err = s.Login(ctx, &proto.AuthRequest{Email: email2, Password: "wrong-pass"})
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		}
+		_, err = s.Login(ctx, &proto.AuthRequest{Email: email2, Password: "s3cret-pass"})
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		// Other emails are not affected.
+		_, err = s.Login(ctx, &proto.AuthRequest{Email: email1, Password: password1})
+		assert.NoError(t, err)
+	})
+}
+
+func TestLoginGuard(t *testing.T) {
+	var g loginGuard
+	g.fail(email1, 100, 2, 60)
+	assert.False(t, g.lockedOut(email1, 100))
+	g.fail(email1, 101, 2, 60)
+	assert.True(t, g.lockedOut(email1, 160))
+	assert.False(t, g.lockedOut(email1, 161))
+	// Once the lockout is over a single failure does not lock again.
+	g.fail(email1, 200, 2, 60)
+	assert.False(t, g.lockedOut(email1, 200))
+	g.reset(email1)
+	assert.False(t, g.lockedOut(email1, 0))
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	jwt "github.com/dgrijalva/jwt-go"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -17,9 +18,20 @@ const (
 	CapWrite = "write"
 )
 
+const (
+	tokenAccess  = "access"
+	tokenRefresh = "refresh"
+	// defaultRefreshExpire is how long refresh tokens last when
+	// auth.refresh_expire is not set, in seconds.
+	defaultRefreshExpire = 30 * 24 * 3600
+)
+
 type JwtClaims struct {
 	UserID       string   `json:"user_id,omitempty"`
 	Capabilities []string `json:"capabilities,omitempty"`
+	// TokenType tells access tokens, accepted by the APIs, from refresh
+	// tokens, only accepted by RefreshToken.
+	TokenType string `json:"token_type,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -31,16 +43,14 @@ func AuthCheck(ctx context.Context, userId string, capabilities ...string) error
 		if p.ExpiresAt < time.Now().Unix() {
 			return status.Errorf(codes.Unauthenticated, "Token expired.")
 		}
-		if p.Capabilities != nil {
-			for pc := range p.Capabilities {
-				for c := range capabilities {
-					if pc == c {
-						return nil
-					}
+		for _, pc := range p.Capabilities {
+			for _, c := range capabilities {
+				if pc == c {
+					return nil
 				}
 			}
 		}
-		if p.UserID == userId || userId == "*" {
+		if (userId != "" && p.UserID == userId) || userId == "*" {
 			return nil
 		}
 		return status.Errorf(codes.PermissionDenied, "Cannot access this api because of token do not have permission.")
@@ -49,22 +59,61 @@ func AuthCheck(ctx context.Context, userId string, capabilities ...string) error
 	}
 }
 
+// HasCapability reports whether the request carries a valid token granting
+// capability. It never fails: anonymous callers simply have no capability.
+func HasCapability(ctx context.Context, capability string) bool {
+	p, ok := ctx.Value("jwt").(JwtClaims)
+	if !ok || p.ExpiresAt < time.Now().Unix() {
+		return false
+	}
+	for _, c := range p.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
 func GenerateJWT(userId string, capabilities []string) (string, error) {
 	// Create custom claims
 	claims := JwtClaims{
 		UserID:       userId,
 		Capabilities: capabilities,
+		TokenType:    tokenAccess,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Second * time.Duration(SConfig.Auth.Expire)).Unix(), // Token will expire in 1 hour
+			Id:        newID("TK-"),
+			ExpiresAt: time.Now().Add(time.Second * time.Duration(SConfig.Auth.Expire)).Unix(),
 			IssuedAt:  time.Now().Unix(),
 		},
 	}
+	return signJWT(claims)
+}
 
-	// Create the token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+// generateRefreshJWT returns a refresh token of userId. It carries no
+// capabilities: those are looked up again on every refresh.
+func generateRefreshJWT(userId string) (string, error) {
+	claims := JwtClaims{
+		UserID:    userId,
+		TokenType: tokenRefresh,
+		StandardClaims: jwt.StandardClaims{
+			Id:        newID("TK-"),
+			ExpiresAt: time.Now().Add(time.Second * time.Duration(refreshExpire())).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+	return signJWT(claims)
+}
 
-	// Sign the token with the secret key
-	tokenString, err := token.SignedString([]byte(SConfig.Auth.SecretKey))
+func refreshExpire() int64 {
+	if SConfig.Auth.RefreshExpire > 0 {
+		return SConfig.Auth.RefreshExpire
+	}
+	return defaultRefreshExpire
+}
+
+func signJWT(claims JwtClaims) (string, error) {
+	// Sign the token with the current key
+	tokenString, err := SKeys.sign(claims, time.Now().Unix())
 	if err != nil {
 		return "", err
 	}
@@ -72,7 +121,34 @@ func GenerateJWT(userId string, capabilities []string) (string, error) {
 	return "Bearer " + tokenString, nil
 }
 
-func ParseJWTMiddleware(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+// parseJWT checks the signature and expiry of token, with or without its
+// "Bearer " prefix, and returns its claims. Tokens of trusted issuers are
+// checked by their issuer.
+func parseJWT(token string) (JwtClaims, error) {
+	token = strings.TrimPrefix(token, "Bearer ")
+	if issuer := SKeys.issuer(token); issuer != nil {
+		return issuer.parse(token)
+	}
+	claims := JwtClaims{}
+	parsedToken, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		return SKeys.verificationKey(token, time.Now().Unix())
+	})
+	if err != nil {
+		return claims, err
+	}
+	if !parsedToken.Valid {
+		return claims, errors.New("invalid token")
+	}
+	if claims.Id == "" {
+		return claims, errors.New("token has no id")
+	}
+	return claims, nil
+}
+
+// ParseJWTMiddleware stores the claims of the access token of a request, or
+// why it was refused, in the context for AuthCheck. Revoked tokens are
+// refused.
+func (s *TrainServer) ParseJWTMiddleware(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
 		ctx = context.WithValue(ctx, "jwt", status.Errorf(codes.Unauthenticated, "Metadata is not provided"))
@@ -85,28 +161,17 @@ func ParseJWTMiddleware(ctx context.Context, req interface{}, info *grpc.UnarySe
 		return handler(ctx, req)
 	}
 
-	// Remove "Bearer " prefix if present
-	if strings.HasPrefix(token[0], "Bearer ") {
-		token[0] = strings.TrimPrefix(token[0], "Bearer ")
-	}
-
-	claims := JwtClaims{}
-
 	// Parse JWT
-	parsedToken, err := jwt.ParseWithClaims(token[0], &claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(SConfig.Auth.SecretKey), nil
-	})
-
-	if err != nil {
+	claims, err := parseJWT(token[0])
+	switch {
+	case err != nil:
 		ctx = context.WithValue(ctx, "jwt", status.Errorf(codes.Unauthenticated, "JWT parsing error: %v", err))
-		return handler(ctx, req)
-	}
-
-	// Type-assert to *jwt.Token
-	if parsedToken.Valid {
+	case claims.TokenType != tokenAccess:
+		ctx = context.WithValue(ctx, "jwt", status.Errorf(codes.Unauthenticated, "Not an access token."))
+	case s.Store.IsRevoked(claims.Id):
+		ctx = context.WithValue(ctx, "jwt", status.Errorf(codes.Unauthenticated, "Token revoked."))
+	default:
 		ctx = context.WithValue(ctx, "jwt", claims)
-	} else {
-		ctx = context.WithValue(ctx, "jwt", status.Errorf(codes.Unauthenticated, "Failed to assert token claims"))
 	}
 
 	return handler(ctx, req)
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Run with `go test -race` to also catch unsynchronised map and seat access.
+func TestTrainServer_ConcurrentPurchase(t *testing.T) {
+	const (
+		seats   = 500
+		buyers  = 3000
+		seating = 2 * seats
+	)
+	s := newTestServer(t, func(conf *TrainConfig) {
+		conf.SeatCount = seats
+	})
+
+	var (
+		wg        sync.WaitGroup
+		purchased atomic.Int32
+		soldOut   atomic.Int32
+		aborted   atomic.Int32
+	)
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := s.PurchaseTicket(context.Background(), &proto.PurchaseRequest{
+				User: &proto.User{
+					FirstName: firstName1,
+					LastName:  lastName1,
+					Email:     fmt.Sprintf("buyer%d@example.com", i),
+				},
+				From:  from1,
+				To:    to1,
+				Price: price1,
+			})
+			if err == nil {
+				purchased.Add(1)
+			} else if err.Error() == "cannot find empty seat" {
+				soldOut.Add(1)
+			} else if status.Code(err) == codes.Aborted {
+				// Lost the race for a seat too often.
+				aborted.Add(1)
+			} else {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(seating), purchased.Load())
+	assert.Equal(t, int32(buyers-seating), soldOut.Load()+aborted.Load())
+
+	owners := map[string]string{}
+	for _, sec := range s.Conf.SectionNames() {
+		taken, err := s.Store.ListSection(Trip{Route: 0}, sec, Segment{})
+		assert.NoError(t, err)
+		for i, booking := range taken {
+			if !assert.NotNil(t, booking, "seat %s%d left empty", sec, i) {
+				continue
+			}
+			user := booking.User
+			seat := fmt.Sprintf("%s%d", sec, i)
+			if prev, ok := owners[user.Email]; ok {
+				t.Errorf("%s holds both %s and %s", user.Email, prev, seat)
+			}
+			owners[user.Email] = seat
+
+			bookings := s.Store.ListByEmail(user.Email)
+			assert.Len(t, bookings, 1)
+			b := bookings[0]
+			assert.Equal(t, sec, b.Section)
+			assert.Equal(t, int32(i), b.Seat)
+		}
+	}
+	assert.Len(t, owners, seating)
+}
+
+func TestTrainServer_ConcurrentSameUser(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	var (
+		wg        sync.WaitGroup
+		purchased atomic.Int32
+	)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.PurchaseTicket(context.Background(), &proto.PurchaseRequest{
+				User:  &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1},
+				From:  from1,
+				To:    to1,
+				Price: price1,
+			})
+			if err == nil {
+				purchased.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), purchased.Load())
+}
+
+// slowAccounts widens the window between the checks of a refresh token and
+// its revocation.
+type slowAccounts struct {
+	BookingStore
+}
+
+func (s slowAccounts) LookupAccount(email string) (Account, bool) {
+	time.Sleep(time.Millisecond)
+	return s.BookingStore.LookupAccount(email)
+}
+
+func TestTrainServer_ConcurrentRefresh(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.Store = slowAccounts{s.Store}
+	login, err := s.Login(context.Background(), &proto.AuthRequest{Email: email1, Password: password1})
+	assert.NoError(t, err)
+
+	// Replays of a stolen refresh token race the legitimate refresh.
+	var (
+		wg        sync.WaitGroup
+		refreshed atomic.Int32
+	)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.RefreshToken(context.Background(), &proto.RefreshTokenRequest{RefreshToken: login.RefreshToken})
+			if err == nil {
+				refreshed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), refreshed.Load())
+}
+
+func TestMemoryStore_ConcurrentMoveAndRelease(t *testing.T) {
+	conf := newTestStoreConfig()
+	conf.SeatCount = 200
+	store := NewMemoryStore(conf)
+	const users = 100
+	for i := 0; i < users; i++ {
+		err := store.Reserve(Booking{
+			ID:      fmt.Sprintf("BK-%d", i),
+			Trip:    Trip{Route: 0},
+			Section: section1,
+			Seat:    int32(i),
+			User:    &proto.User{FirstName: firstName1, LastName: lastName1, Email: fmt.Sprintf("u%d@example.com", i)},
+		})
+		assert.NoError(t, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < users; i++ {
+		wg.Add(2)
+		id := fmt.Sprintf("BK-%d", i)
+		go func(i int) {
+			defer wg.Done()
+			for seat := int32(0); seat < int32(conf.SeatCount); seat += 7 {
+				_, _ = store.Move(id, (seat+int32(i))%int32(conf.SeatCount))
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				_, _ = store.Release(id)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	taken := 0
+	seats, err := store.ListSection(Trip{Route: 0}, section1, Segment{})
+	assert.NoError(t, err)
+	for i, b := range seats {
+		if b == nil {
+			continue
+		}
+		taken++
+		bookings := store.ListByEmail(b.User.Email)
+		assert.Len(t, bookings, 1)
+		assert.Equal(t, int32(i), bookings[0].Seat)
+	}
+	assert.Equal(t, users/2, taken)
+}
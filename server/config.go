@@ -2,38 +2,329 @@ package server
 
 import (
 	"fmt"
+	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/yaml.v3"
 	"os"
+	"slices"
+	"strings"
+	"time"
 )
 
 var (
 	SConfig = Config{}
 )
 
+const defaultFareClass = "standard"
+
 type Config struct {
-	Train     TrainConfig `yaml:"train"`
-	Auth      AuthConfig  `yaml:"auth"`
-	RoleUsers []RoleUser  `yaml:"roles"`
+	Train     TrainConfig   `yaml:"train"`
+	Auth      AuthConfig    `yaml:"auth"`
+	RoleUsers []RoleUser    `yaml:"roles"`
+	Storage   StorageConfig `yaml:"storage"`
+	// Policy overrides the default policy of TrainService methods, by method
+	// name. Each entry replaces the whole default of its method.
+	Policy map[string]MethodPolicy `yaml:"policy,omitempty"`
+}
+
+// MethodPolicy is who may call a method: anyone when Public, otherwise a
+// caller holding one of Capabilities or, with an Owner rule, the user the
+// request belongs to. Any authenticated caller may when neither is set.
+type MethodPolicy struct {
+	Public       bool     `yaml:"public,omitempty"`
+	Capabilities []string `yaml:"caps,omitempty"`
+	Owner        string   `yaml:"owner,omitempty"`
 }
 
 type AuthConfig struct {
 	SecretKey string `yaml:"secret_key"`
-	Expire    int64  `yaml:"expire"`
+	// Expire is the lifetime of access tokens and RefreshExpire the one of
+	// refresh tokens, in seconds.
+	Expire        int64 `yaml:"expire"`
+	RefreshExpire int64 `yaml:"refresh_expire,omitempty"`
+	// MaxAttempts failed logins in a row lock an email out for Lockout
+	// seconds.
+	MaxAttempts int `yaml:"max_attempts,omitempty"`
+	Lockout     int `yaml:"lockout,omitempty"`
+	// BcryptCost is the cost passwords are hashed with, bcrypt's default
+	// when 0.
+	BcryptCost int           `yaml:"bcrypt_cost,omitempty"`
+	Signing    SigningConfig `yaml:"signing,omitempty"`
+	// Issuers are the external OAuth2/OIDC servers whose tokens are
+	// accepted too.
+	Issuers []IssuerConfig `yaml:"issuers,omitempty"`
+}
+
+// IssuerConfig trusts the tokens an OAuth2/OIDC server issued for Audience,
+// verified with the keys of the JWKS document in JWKSFile. The user is the
+// verified email of the token, or its subject when UserClaim is sub, and its
+// capabilities are those its scopes and roles map to.
+type IssuerConfig struct {
+	Issuer    string              `yaml:"issuer"`
+	Audience  string              `yaml:"audience"`
+	JWKSFile  string              `yaml:"jwks_file"`
+	UserClaim string              `yaml:"user_claim,omitempty"`
+	Scopes    map[string][]string `yaml:"scopes,omitempty"`
+	Roles     map[string][]string `yaml:"roles,omitempty"`
+}
+
+// SigningConfig lists the keys tokens are signed with. Tokens are signed with
+// SecretKey (HS256) when there are none.
+type SigningConfig struct {
+	Keys []SigningKeyConfig `yaml:"keys,omitempty"`
+	// Overlap is how long a key keeps verifying tokens after the next key
+	// started signing, in seconds. It defaults to the refresh token lifetime.
+	Overlap int64 `yaml:"overlap,omitempty"`
+}
+
+// SigningKeyConfig is a private key in a PEM file, which signs tokens from
+// NotBefore (RFC 3339) on until the next key takes over.
+type SigningKeyConfig struct {
+	Kid        string `yaml:"kid"`
+	Algorithm  string `yaml:"algorithm"` // RS256 or ES256
+	PrivateKey string `yaml:"private_key"`
+	NotBefore  string `yaml:"not_before,omitempty"`
+}
+
+// StorageConfig enables the file backed booking store when Dir is set.
+type StorageConfig struct {
+	Dir           string `yaml:"dir,omitempty"`
+	SnapshotEvery int    `yaml:"snapshot_every,omitempty"`
 }
 
+// RoleUser grants capabilities to an email. Role users cannot register: they
+// log in with the bcrypt PasswordHash set here.
 type RoleUser struct {
 	Email        string   `yaml:"email"`
+	PasswordHash string   `yaml:"password_hash,omitempty"`
 	Capabilities []string `yaml:"caps"`
 }
 
 type TrainConfig struct {
-	Sections  []string `yaml:"sections,omitempty"`
+	Sections []SectionConfig `yaml:"sections,omitempty"`
+	// SeatCount is the number of seats of a section which sets none itself.
+	SeatCount int `yaml:"seat_count,omitempty"`
+	// SeatsPerRow describes the seat layout: seats are numbered row by row
+	// from the front of a section, with the aisle in the middle of each row.
+	SeatsPerRow   int      `yaml:"seats_per_row,omitempty"`
+	QuietSections []string `yaml:"quiet_sections,omitempty"`
+	// ScheduleDays is how many days ahead departures can be booked.
+	ScheduleDays int `yaml:"schedule_days,omitempty"`
+	// HoldTTL is how many seconds a held seat stays reserved before it is
+	// released again.
+	HoldTTL      int                `yaml:"hold_ttl,omitempty"`
+	Journeys     JourneyConfig      `yaml:"journeys,omitempty"`
+	Cancellation CancellationPolicy `yaml:"cancellation,omitempty"`
+	Pricing      PricingConfig      `yaml:"pricing,omitempty"`
+	// Currency is the ISO 4217 currency of routes which set none, USD by
+	// default. Prices are in its minor units, e.g. 2000 for 20.00.
+	Currency string `yaml:"currency,omitempty"`
+	// FX are the exchange rates customers paying in another currency are
+	// charged at.
+	FX    FXConfig  `yaml:"fx,omitempty"`
+	Taxes TaxConfig `yaml:"taxes,omitempty"`
+	// Concessions maps a passenger type (child, senior, student) to the
+	// percent of the fare it pays, e.g. 50 for half price. Types not listed
+	// pay the full fare.
+	Concessions map[string]int `yaml:"concessions,omitempty"`
+	Routes      []RouteConfig  `yaml:"routes,omitempty"`
+}
+
+// SectionConfig describes one section of the train. A section given as a
+// plain name is a standard class section with the default seat count.
+type SectionConfig struct {
+	Name string `yaml:"name"`
+	// Class is the fare class sold in the section, "standard" when empty.
+	Class string `yaml:"class,omitempty"`
+	// Percent is applied to the fare of the section, e.g. 150 for first
+	// class. Zero keeps the fare.
+	Percent   int      `yaml:"percent,omitempty"`
 	SeatCount int      `yaml:"seat_count,omitempty"`
-	Routes    []struct {
-		From  string `yaml:"from,omitempty"`
-		To    string `yaml:"to,omitempty"`
-		Price int32  `yaml:"price,omitempty"`
-	} `yaml:"routes,omitempty"`
+	Amenities []string `yaml:"amenities,omitempty"`
+}
+
+func (c *SectionConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*c = SectionConfig{Name: node.Value}
+		return nil
+	}
+	type plain SectionConfig
+	return node.Decode((*plain)(c))
+}
+
+// FareClass returns the fare class of the section.
+func (c SectionConfig) FareClass() string {
+	if c.Class == "" {
+		return defaultFareClass
+	}
+	return c.Class
+}
+
+// SectionNames returns the names of the sections in configuration order.
+func (c *TrainConfig) SectionNames() []string {
+	names := make([]string, len(c.Sections))
+	for i, sec := range c.Sections {
+		names[i] = sec.Name
+	}
+	return names
+}
+
+// Section returns the configuration of the named section.
+func (c *TrainConfig) Section(name string) (SectionConfig, bool) {
+	for _, sec := range c.Sections {
+		if sec.Name == name {
+			return sec, true
+		}
+	}
+	return SectionConfig{}, false
+}
+
+// SectionSeats returns the number of seats of the named section.
+func (c *TrainConfig) SectionSeats(name string) int {
+	if sec, ok := c.Section(name); ok && sec.SeatCount > 0 {
+		return sec.SeatCount
+	}
+	return c.SeatCount
+}
+
+// TotalSeats returns the number of seats over all sections.
+func (c *TrainConfig) TotalSeats() int {
+	total := 0
+	for _, sec := range c.Sections {
+		total += c.SectionSeats(sec.Name)
+	}
+	return total
+}
+
+// PricingConfig holds the rules which turn the route price into the fare of a
+// trip. Every rule that applies multiplies the fare by its percent, and a rule
+// with a zero percent is disabled.
+type PricingConfig struct {
+	// QuoteTTL is how many seconds a price returned by QuoteFare is honoured.
+	QuoteTTL   int            `yaml:"quote_ttl,omitempty"`
+	Surge      []SurgeRule    `yaml:"surge,omitempty"`
+	EarlyBird  EarlyBirdRule  `yaml:"early_bird,omitempty"`
+	LastMinute LastMinuteRule `yaml:"last_minute,omitempty"`
+}
+
+// SurgeRule applies once Occupancy percent of the seats of a trip are taken.
+// Only the highest tier reached counts.
+type SurgeRule struct {
+	Occupancy int `yaml:"occupancy"`
+	Percent   int `yaml:"percent"`
+}
+
+// EarlyBirdRule applies when the train leaves at least Days days later.
+type EarlyBirdRule struct {
+	Days    int `yaml:"days,omitempty"`
+	Percent int `yaml:"percent,omitempty"`
+}
+
+// LastMinuteRule applies when the train leaves within Hours hours.
+type LastMinuteRule struct {
+	Hours   int `yaml:"hours,omitempty"`
+	Percent int `yaml:"percent,omitempty"`
+}
+
+// CancellationPolicy decides how much of the price is refunded when a booking
+// is removed: everything up to FullRefundHours before departure, then
+// PartialRefundPercent until the train leaves, and nothing afterwards.
+type CancellationPolicy struct {
+	FullRefundHours      int `yaml:"full_refund_hours,omitempty"`
+	PartialRefundPercent int `yaml:"partial_refund_percent,omitempty"`
+}
+
+// JourneyConfig tunes the journey planner. A connection needs MinConnection
+// minutes between the arrival of one leg and the departure of the next.
+type JourneyConfig struct {
+	MaxLegs       int `yaml:"max_legs,omitempty"`
+	MinConnection int `yaml:"min_connection,omitempty"`
+}
+
+// TaxConfig sets the fees and taxes added to the fare at purchase. Fees are
+// added first, then VAT is charged on the fare and fees together.
+type TaxConfig struct {
+	// VAT maps the ISO 3166 country a route departs from to its VAT percent.
+	VAT  map[string]int `yaml:"vat,omitempty"`
+	Fees []FeeRule      `yaml:"fees,omitempty"`
+}
+
+// FeeRule adds a fixed Amount, in minor units of the route currency, and
+// Percent of the fare to every booking.
+type FeeRule struct {
+	Name    string `yaml:"name"`
+	Amount  int32  `yaml:"amount,omitempty"`
+	Percent int    `yaml:"percent,omitempty"`
+}
+
+// FXConfig holds a static exchange rate table. Rates maps a pair such as
+// "GBP/EUR" to the decimal amount of the second currency one unit of the
+// first buys; the inverse pair is derived.
+type FXConfig struct {
+	Rates map[string]string `yaml:"rates,omitempty"`
+}
+
+type RouteConfig struct {
+	From string `yaml:"from,omitempty"`
+	To   string `yaml:"to,omitempty"`
+	// Stops are the stations the train calls at between From and To, in
+	// order. Seats can be sold for any part of the route.
+	Stops []string `yaml:"stops,omitempty"`
+	// Price is in minor units of Currency, the train currency when empty.
+	Price    int32  `yaml:"price,omitempty"`
+	Currency string `yaml:"currency,omitempty"`
+	// Country is the ISO 3166 code of the origin, which decides the VAT.
+	Country string `yaml:"country,omitempty"`
+	// Departures are the daily departure times as "15:04" in UTC. A route
+	// without departures runs a single undated train.
+	Departures []string `yaml:"departures,omitempty"`
+	// Duration is how many minutes the trip takes, used to plan connections.
+	Duration int `yaml:"duration,omitempty"`
+}
+
+// Stations lists every station the route calls at, from From to To.
+func (r RouteConfig) Stations() []string {
+	return append(append([]string{r.From}, r.Stops...), r.To)
+}
+
+// Segment returns the part of the route from one station to another, and
+// false unless the train calls at from before to.
+func (r RouteConfig) Segment(from, to string) (Segment, bool) {
+	stations := r.Stations()
+	board := slices.Index(stations, from)
+	if board < 0 {
+		return Segment{}, false
+	}
+	alight := slices.Index(stations[board+1:], to)
+	if alight < 0 {
+		return Segment{}, false
+	}
+	alight += board + 1
+	if alight == len(stations)-1 {
+		alight = 0
+	}
+	return Segment{Board: int32(board), Alight: int32(alight)}, true
+}
+
+// Legs returns how many stops seg spans, and how many the whole route has.
+func (r RouteConfig) Legs(seg Segment) (int, int) {
+	total := len(r.Stops) + 1
+	alight := int(seg.Alight)
+	if alight == 0 {
+		alight = total
+	}
+	return alight - int(seg.Board), total
+}
+
+// Boarding and Alighting name the stations seg starts and ends at.
+func (r RouteConfig) Boarding(seg Segment) string {
+	return r.Stations()[seg.Board]
+}
+
+func (r RouteConfig) Alighting(seg Segment) string {
+	if seg.Alight == 0 {
+		return r.To
+	}
+	return r.Stations()[seg.Alight]
 }
 
 func (s *Config) InitConfig(path string) error {
@@ -46,5 +337,128 @@ func (s *Config) InitConfig(path string) error {
 	if err != nil {
 		return fmt.Errorf("Error unmarshalling YAML content: %v\n", err)
 	}
+	names := make(map[string]bool, len(s.Train.Sections))
+	for _, sec := range s.Train.Sections {
+		if sec.Name == "" || names[sec.Name] {
+			return fmt.Errorf("Invalid section name: %q\n", sec.Name)
+		}
+		names[sec.Name] = true
+		if sec.Percent < 0 || sec.SeatCount < 0 {
+			return fmt.Errorf("Invalid section %s: percent and seat count must not be negative\n", sec.Name)
+		}
+	}
+	if err := validateConcessions(s.Train.Concessions); err != nil {
+		return fmt.Errorf("Invalid concessions: %v\n", err)
+	}
+	if p := s.Train.Cancellation.PartialRefundPercent; p < 0 || p > 100 {
+		return fmt.Errorf("Invalid partial refund percent: %d\n", p)
+	}
+	if j := s.Train.Journeys; j.MaxLegs < 0 || j.MinConnection < 0 {
+		return fmt.Errorf("Invalid journeys config: %+v\n", j)
+	}
+	for country, p := range s.Train.Taxes.VAT {
+		if p < 0 || p > 100 {
+			return fmt.Errorf("Invalid VAT percent for %s: %d\n", country, p)
+		}
+	}
+	for _, fee := range s.Train.Taxes.Fees {
+		if fee.Name == "" || fee.Amount < 0 || fee.Percent < 0 {
+			return fmt.Errorf("Invalid fee: %+v\n", fee)
+		}
+	}
+	if c := s.Train.Currency; c != "" && !isValidCurrency(c) {
+		return fmt.Errorf("Invalid currency: %q\n", c)
+	}
+	for pair, rate := range s.Train.FX.Rates {
+		from, to, ok := strings.Cut(pair, "/")
+		if !ok || !isValidCurrency(from) || !isValidCurrency(to) {
+			return fmt.Errorf("Invalid currency pair: %q\n", pair)
+		}
+		if _, err := parseRate(rate); err != nil {
+			return fmt.Errorf("Invalid rate for %s: %v\n", pair, err)
+		}
+	}
+	for _, route := range s.Train.Routes {
+		stations := map[string]bool{}
+		for _, station := range route.Stations() {
+			if station == "" || stations[station] {
+				return fmt.Errorf("Invalid stop %q for route %s - %s\n", station, route.From, route.To)
+			}
+			stations[station] = true
+		}
+		if route.Duration < 0 {
+			return fmt.Errorf("Invalid duration for route %s - %s: %d\n", route.From, route.To, route.Duration)
+		}
+		if route.Currency != "" && !isValidCurrency(route.Currency) {
+			return fmt.Errorf("Invalid currency %q for route %s - %s\n", route.Currency, route.From, route.To)
+		}
+		for _, departure := range route.Departures {
+			if _, err := time.Parse(departureLayout, departure); err != nil {
+				return fmt.Errorf("Invalid departure %q for route %s - %s: %v\n", departure, route.From, route.To, err)
+			}
+		}
+	}
+	if a := s.Auth; a.RefreshExpire < 0 || a.MaxAttempts < 0 || a.Lockout < 0 || a.BcryptCost < 0 || a.BcryptCost > bcrypt.MaxCost {
+		return fmt.Errorf("Invalid auth config: %+v\n", a)
+	}
+	if s.Auth.Signing.Overlap < 0 {
+		return fmt.Errorf("Invalid signing key overlap: %d\n", s.Auth.Signing.Overlap)
+	}
+	kids := make(map[string]bool, len(s.Auth.Signing.Keys))
+	for _, key := range s.Auth.Signing.Keys {
+		if key.Kid == "" || kids[key.Kid] {
+			return fmt.Errorf("Invalid signing key id: %q\n", key.Kid)
+		}
+		kids[key.Kid] = true
+		if key.Algorithm != "RS256" && key.Algorithm != "ES256" {
+			return fmt.Errorf("Invalid algorithm %q for signing key %s\n", key.Algorithm, key.Kid)
+		}
+		if key.PrivateKey == "" {
+			return fmt.Errorf("Invalid private key for signing key %s\n", key.Kid)
+		}
+		if key.NotBefore != "" {
+			if _, err := time.Parse(time.RFC3339, key.NotBefore); err != nil {
+				return fmt.Errorf("Invalid not_before %q for signing key %s: %v\n", key.NotBefore, key.Kid, err)
+			}
+		}
+	}
+	for _, issuer := range s.Auth.Issuers {
+		if issuer.Issuer == "" || issuer.Audience == "" || issuer.JWKSFile == "" {
+			return fmt.Errorf("Invalid issuer %q: issuer, audience and jwks_file are required\n", issuer.Issuer)
+		}
+		if issuer.UserClaim != "" && issuer.UserClaim != userClaimEmail && issuer.UserClaim != userClaimSub {
+			return fmt.Errorf("Invalid user claim %q for issuer %s\n", issuer.UserClaim, issuer.Issuer)
+		}
+		for _, mapping := range []map[string][]string{issuer.Scopes, issuer.Roles} {
+			for name, capabilities := range mapping {
+				for _, c := range capabilities {
+					if c != CapAdmin && c != CapRead && c != CapWrite {
+						return fmt.Errorf("Invalid capability %q for %s of issuer %s\n", c, name, issuer.Issuer)
+					}
+				}
+			}
+		}
+	}
+	for method, policy := range s.Policy {
+		if _, ok := defaultPolicy[method]; !ok {
+			return fmt.Errorf("Invalid policy: unknown method %q\n", method)
+		}
+		if policy.Owner != "" && !slices.Contains(ownerRules, policy.Owner) {
+			return fmt.Errorf("Invalid owner %q in policy of %s\n", policy.Owner, method)
+		}
+		for _, c := range policy.Capabilities {
+			if c != CapAdmin && c != CapRead && c != CapWrite {
+				return fmt.Errorf("Invalid capability %q in policy of %s\n", c, method)
+			}
+		}
+	}
+	for _, user := range s.RoleUsers {
+		if user.PasswordHash == "" {
+			continue
+		}
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			return fmt.Errorf("Invalid password hash for role user %s: %v\n", user.Email, err)
+		}
+	}
 	return nil
 }
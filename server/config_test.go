@@ -2,6 +2,7 @@ package server
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -21,20 +22,72 @@ func TestServerConfig_initConfig(t *testing.T) {
 train:
   sections:
     - A
-    - B
+    - name: B
+      class: first
+      percent: 130
+      seat_count: 3
+      amenities:
+        - wifi
+        - power
   seat_count: 5
+  schedule_days: 3
+  hold_ttl: 120
+  cancellation:
+    full_refund_hours: 48
+    partial_refund_percent: 25
+  pricing:
+    quote_ttl: 30
+    surge:
+      - occupancy: 80
+        percent: 150
+    early_bird:
+      days: 14
+      percent: 85
+    last_minute:
+      hours: 3
+      percent: 120
+  concessions:
+    child: 50
+    senior: 70
+  currency: GBP
+  fx:
+    rates:
+      GBP/EUR: 1.17
+  taxes:
+    vat:
+      GB: 20
+    fees:
+      - name: booking
+        amount: 150
   routes:
     - from: London
       to: Paris
       price: 20
+      country: GB
     - from: Osaka
       to: London
       price: 200
+      currency: JPY
+      departures:
+        - "08:15"
+        - "20:00"
 auth:
   secret_key: abc
   expire: 3600
+  refresh_expire: 86400
+  max_attempts: 3
+  lockout: 600
+  bcrypt_cost: 11
+  signing:
+    overlap: 3600
+    keys:
+      - kid: 2026-10
+        algorithm: ES256
+        private_key: keys/2026-10.pem
+        not_before: "2026-10-01T00:00:00Z"
 roles:
   - email: a@a.com
+    password_hash: "$2a$10$GFdY8bKlZPIEgAX4/n/sRe6gR7o2RgxaxN9quZJswR7I6PXkjcPcO"
     caps:
       - admin
       - read
@@ -45,6 +98,9 @@ roles:
   - email: c@c.com
     caps:
       - write
+storage:
+  dir: /tmp/train
+  snapshot_every: 10
 `
 	err = os.WriteFile(dumpfile.Name(), []byte(yamlContent), 0644)
 	assert.NoError(t, err)
@@ -55,8 +111,13 @@ roles:
 
 	// Assert that initialization was successful
 	assert.NoError(t, err)
-	assert.Equal(t, []string{"A", "B"}, serverConfig.Train.Sections)
+	assert.Equal(t, []SectionConfig{
+		{Name: "A"},
+		{Name: "B", Class: "first", Percent: 130, SeatCount: 3, Amenities: []string{"wifi", "power"}},
+	}, serverConfig.Train.Sections)
 	assert.Equal(t, 5, serverConfig.Train.SeatCount)
+	assert.Equal(t, 3, serverConfig.Train.SectionSeats("B"))
+	assert.Equal(t, 8, serverConfig.Train.TotalSeats())
 	assert.Len(t, serverConfig.Train.Routes, 2)
 
 	route1 := serverConfig.Train.Routes[0]
@@ -68,12 +129,37 @@ roles:
 	assert.Equal(t, "Osaka", route2.From)
 	assert.Equal(t, "London", route2.To)
 	assert.Equal(t, int32(200), route2.Price)
+	assert.Equal(t, []string{"08:15", "20:00"}, route2.Departures)
+	assert.Equal(t, 3, serverConfig.Train.ScheduleDays)
+	assert.Equal(t, 120, serverConfig.Train.HoldTTL)
+	assert.Equal(t, CancellationPolicy{FullRefundHours: 48, PartialRefundPercent: 25}, serverConfig.Train.Cancellation)
+	assert.Equal(t, PricingConfig{
+		QuoteTTL:   30,
+		Surge:      []SurgeRule{{Occupancy: 80, Percent: 150}},
+		EarlyBird:  EarlyBirdRule{Days: 14, Percent: 85},
+		LastMinute: LastMinuteRule{Hours: 3, Percent: 120},
+	}, serverConfig.Train.Pricing)
+	assert.Equal(t, map[string]int{"child": 50, "senior": 70}, serverConfig.Train.Concessions)
+	assert.Equal(t, "GBP", serverConfig.Train.Currency)
+	assert.Equal(t, map[string]string{"GBP/EUR": "1.17"}, serverConfig.Train.FX.Rates)
+	assert.Equal(t, "JPY", route2.Currency)
+	assert.Equal(t, "GB", route1.Country)
+	assert.Equal(t, TaxConfig{VAT: map[string]int{"GB": 20}, Fees: []FeeRule{{Name: "booking", Amount: 150}}}, serverConfig.Train.Taxes)
 
 	assert.Equal(t, "abc", serverConfig.Auth.SecretKey)
 	assert.Equal(t, int64(3600), serverConfig.Auth.Expire)
+	assert.Equal(t, int64(86400), serverConfig.Auth.RefreshExpire)
+	assert.Equal(t, 3, serverConfig.Auth.MaxAttempts)
+	assert.Equal(t, 600, serverConfig.Auth.Lockout)
+	assert.Equal(t, 11, serverConfig.Auth.BcryptCost)
+	assert.Equal(t, SigningConfig{
+		Keys:    []SigningKeyConfig{{Kid: "2026-10", Algorithm: "ES256", PrivateKey: "keys/2026-10.pem", NotBefore: "2026-10-01T00:00:00Z"}},
+		Overlap: 3600,
+	}, serverConfig.Auth.Signing)
 
 	admin := serverConfig.RoleUsers[0]
 	assert.Equal(t, "a@a.com", admin.Email)
+	assert.Equal(t, "$2a$10$GFdY8bKlZPIEgAX4/n/sRe6gR7o2RgxaxN9quZJswR7I6PXkjcPcO", admin.PasswordHash)
 	assert.Equal(t, []string{"admin", "read", "write"}, admin.Capabilities)
 
 	read := serverConfig.RoleUsers[1]
@@ -83,4 +169,180 @@ roles:
 	write := serverConfig.RoleUsers[2]
 	assert.Equal(t, "c@c.com", write.Email)
 	assert.Equal(t, []string{"write"}, write.Capabilities)
+
+	assert.Equal(t, "/tmp/train", serverConfig.Storage.Dir)
+	assert.Equal(t, 10, serverConfig.Storage.SnapshotEvery)
+}
+
+func TestServerConfig_invalidDeparture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yamlContent := `
+train:
+  routes:
+    - from: London
+      to: Paris
+      departures:
+        - "25:00"
+`
+	assert.NoError(t, os.WriteFile(path, []byte(yamlContent), 0644))
+	err := (&Config{}).InitConfig(path)
+	assert.ErrorContains(t, err, `Invalid departure "25:00" for route London - Paris`)
+}
+
+func TestServerConfig_invalidConcession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yamlContent := `
+train:
+  concessions:
+    infant: 0
+`
+	assert.NoError(t, os.WriteFile(path, []byte(yamlContent), 0644))
+	err := (&Config{}).InitConfig(path)
+	assert.ErrorContains(t, err, "unknown passenger type: infant")
+}
+
+func TestServerConfig_invalidCurrency(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yamlContent := `
+train:
+  fx:
+    rates:
+      GBP-EUR: 1.17
+`
+	assert.NoError(t, os.WriteFile(path, []byte(yamlContent), 0644))
+	err := (&Config{}).InitConfig(path)
+	assert.ErrorContains(t, err, `Invalid currency pair: "GBP-EUR"`)
+}
+
+func TestServerConfig_invalidStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yamlContent := `
+train:
+  routes:
+    - from: London
+      to: Paris
+      stops:
+        - Lille
+        - London
+`
+	assert.NoError(t, os.WriteFile(path, []byte(yamlContent), 0644))
+	err := (&Config{}).InitConfig(path)
+	assert.ErrorContains(t, err, `Invalid stop "London" for route London - Paris`)
+}
+
+func TestRouteConfig_Segment(t *testing.T) {
+	route := RouteConfig{From: "London", To: "Paris", Stops: []string{"Ashford", "Lille"}}
+	seg, ok := route.Segment("Ashford", "Lille")
+	assert.True(t, ok)
+	assert.Equal(t, Segment{Board: 1, Alight: 2}, seg)
+	seg, ok = route.Segment("Lille", "Paris")
+	assert.True(t, ok)
+	assert.Equal(t, Segment{Board: 2}, seg)
+	assert.Equal(t, "Paris", route.Alighting(seg))
+	legs, total := route.Legs(seg)
+	assert.Equal(t, 1, legs)
+	assert.Equal(t, 3, total)
+	_, ok = route.Segment("Lille", "Ashford")
+	assert.False(t, ok)
+	_, ok = route.Segment("Paris", "London")
+	assert.False(t, ok)
+}
+
+func TestServerConfig_invalidPasswordHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yamlContent := `
+roles:
+  - email: a@a.com
+    password_hash: secret
+`
+	assert.NoError(t, os.WriteFile(path, []byte(yamlContent), 0644))
+	err := (&Config{}).InitConfig(path)
+	assert.ErrorContains(t, err, "Invalid password hash for role user a@a.com")
+}
+
+func TestServerConfig_invalidSigningKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yamlContent := `
+auth:
+  signing:
+    keys:
+      - kid: 2026-10
+        algorithm: HS256
+        private_key: keys/2026-10.pem
+`
+	assert.NoError(t, os.WriteFile(path, []byte(yamlContent), 0644))
+	err := (&Config{}).InitConfig(path)
+	assert.ErrorContains(t, err, `Invalid algorithm "HS256" for signing key 2026-10`)
+}
+
+func TestServerConfig_issuers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yamlContent := `
+auth:
+  issuers:
+    - issuer: https://login.example.com/
+      audience: trains
+      jwks_file: keys/login.example.com.json
+      user_claim: sub
+      scopes:
+        tickets.read:
+          - read
+      roles:
+        staff:
+          - admin
+`
+	assert.NoError(t, os.WriteFile(path, []byte(yamlContent), 0644))
+	conf := &Config{}
+	assert.NoError(t, conf.InitConfig(path))
+	assert.Equal(t, []IssuerConfig{{
+		Issuer:    "https://login.example.com/",
+		Audience:  "trains",
+		JWKSFile:  "keys/login.example.com.json",
+		UserClaim: "sub",
+		Scopes:    map[string][]string{"tickets.read": {"read"}},
+		Roles:     map[string][]string{"staff": {"admin"}},
+	}}, conf.Auth.Issuers)
+
+	yamlContent = `
+auth:
+  issuers:
+    - issuer: https://login.example.com/
+      audience: trains
+      jwks_file: keys/login.example.com.json
+      roles:
+        staff:
+          - root
+`
+	assert.NoError(t, os.WriteFile(path, []byte(yamlContent), 0644))
+	err := (&Config{}).InitConfig(path)
+	assert.ErrorContains(t, err, `Invalid capability "root" for staff of issuer https://login.example.com/`)
+}
+
+func TestServerConfig_policy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yamlContent := `
+policy:
+  GetAllRoutes:
+    public: true
+  GetReceipt:
+    owner: booking
+    caps:
+      - admin
+`
+	assert.NoError(t, os.WriteFile(path, []byte(yamlContent), 0644))
+	conf := &Config{}
+	assert.NoError(t, conf.InitConfig(path))
+	assert.Equal(t, map[string]MethodPolicy{
+		"GetAllRoutes": {Public: true},
+		"GetReceipt":   {Owner: "booking", Capabilities: []string{"admin"}},
+	}, conf.Policy)
+
+	for yamlContent, msg := range map[string]string{
+		"policy:\n  GetTicket:\n    public: true\n":         `Invalid policy: unknown method "GetTicket"`,
+		"policy:\n  GetReceipt:\n    owner: seat\n":         `Invalid owner "seat" in policy of GetReceipt`,
+		"policy:\n  GetReceipt:\n    caps:\n      - root\n": `Invalid capability "root" in policy of GetReceipt`,
+	} {
+		assert.NoError(t, os.WriteFile(path, []byte(yamlContent), 0644))
+		assert.ErrorContains(t, (&Config{}).InitConfig(path), msg)
+	}
 }
@@ -0,0 +1,678 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	walFileName      = "bookings.wal"
+	snapshotFileName = "bookings.snapshot"
+
+	defaultSnapshotEvery = 100
+
+	opReserve    = "reserve"
+	opReserveAll = "reserve_all"
+	opRelease    = "release"
+	opReleaseAll = "release_all"
+	opMove       = "move"
+	opConfirm    = "confirm"
+	opUnconfirm  = "unconfirm"
+	opEnqueue    = "enqueue"
+	opDequeue    = "dequeue"
+	opCancel     = "cancel"
+	opAddVoucher = "add_voucher"
+	opDelVoucher = "delete_voucher"
+	opAddAccount = "add_account"
+	opRevoke     = "revoke"
+)
+
+// walRecord is one line of the write-ahead log.
+type walRecord struct {
+	Seq      uint64         `json:"seq"`
+	Op       string         `json:"op"`
+	Booking  *Booking       `json:"booking,omitempty"`
+	Bookings []Booking      `json:"bookings,omitempty"`
+	ID       string         `json:"id,omitempty"`
+	IDs      []string       `json:"ids,omitempty"`
+	Seat     int32          `json:"seat,omitempty"`
+	Until    int64          `json:"until,omitempty"`
+	Entry    *WaitlistEntry `json:"entry,omitempty"`
+	Refund   *Refund        `json:"refund,omitempty"`
+	Voucher  *Voucher       `json:"voucher,omitempty"`
+	Account  *Account       `json:"account,omitempty"`
+	Revoked  *Revocation    `json:"revoked,omitempty"`
+}
+
+// snapshot is the full state of the store up to and including record Seq.
+type snapshot struct {
+	Seq      uint64          `json:"seq"`
+	Bookings []Booking       `json:"bookings"`
+	Waitlist []WaitlistEntry `json:"waitlist,omitempty"`
+	Refunds  []Refund        `json:"refunds,omitempty"`
+	Vouchers []Voucher       `json:"vouchers,omitempty"`
+	Accounts []Account       `json:"accounts,omitempty"`
+	Revoked  []Revocation    `json:"revoked,omitempty"`
+}
+
+// FileStore is a BookingStore which keeps an in-memory copy of the bookings and
+// makes every change durable in an append-only log inside Dir. The log is
+// compacted into a snapshot every SnapshotEvery records and both are replayed
+// on startup. Changes and their records are serialised so the log order always
+// matches the order in which changes were applied, but writers wait for the
+// sync outside of that lock: writers arriving during a sync share the next one
+// (group commit), so trains do not queue behind each other's fsync. Reads go
+// straight to the in-memory copy, which may show a change shortly before its
+// writer returns.
+type FileStore struct {
+	mu            sync.Mutex
+	mem           *MemoryStore
+	dir           string
+	wal           *os.File
+	walSize       int64
+	seq           uint64
+	snapshotEvery int
+	sinceSnapshot int
+
+	// written is the last record handed to the log, synced the last one
+	// known to be on disk. failed is set for good once a sync fails.
+	written atomic.Uint64
+	syncMu  sync.Mutex
+	synced  uint64
+	failed  atomic.Value
+}
+
+func OpenFileStore(conf *TrainConfig, storage StorageConfig) (*FileStore, error) {
+	if err := os.MkdirAll(storage.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cannot create storage dir: %v", err)
+	}
+	f := &FileStore{
+		mem:           NewMemoryStore(conf),
+		dir:           storage.Dir,
+		snapshotEvery: storage.SnapshotEvery,
+	}
+	if f.snapshotEvery <= 0 {
+		f.snapshotEvery = defaultSnapshotEvery
+	}
+	if err := f.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := f.replay(); err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(filepath.Join(f.dir, walFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open wal: %v", err)
+	}
+	info, err := wal.Stat()
+	if err != nil {
+		wal.Close()
+		return nil, fmt.Errorf("cannot open wal: %v", err)
+	}
+	f.wal, f.walSize = wal, info.Size()
+	f.written.Store(f.seq)
+	f.synced = f.seq
+	return f, nil
+}
+
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.syncMu.Lock()
+	defer f.syncMu.Unlock()
+	return f.wal.Close()
+}
+
+// write applies change, which logs its record with append, under the write
+// lock and returns once that record is synced.
+func (f *FileStore) write(change func() error) error {
+	f.mu.Lock()
+	before := f.seq
+	err := change()
+	seq := f.seq
+	f.mu.Unlock()
+	if err != nil || seq == before {
+		return err
+	}
+	return f.sync(seq)
+}
+
+// sync waits until record seq is on disk, syncing the log unless a sync which
+// started after it was written already did. As the log cannot tell which
+// records a failed sync lost, the store refuses any further write then; the
+// changes are already applied in memory and a restart replays what survived.
+func (f *FileStore) sync(seq uint64) error {
+	f.syncMu.Lock()
+	defer f.syncMu.Unlock()
+	if f.synced >= seq {
+		return nil
+	}
+	if err := f.syncErr(); err != nil {
+		return err
+	}
+	written := f.written.Load()
+	if err := f.wal.Sync(); err != nil {
+		err = fmt.Errorf("cannot sync wal: %v", err)
+		f.failed.Store(err)
+		return err
+	}
+	f.synced = written
+	return nil
+}
+
+func (f *FileStore) syncErr() error {
+	err, _ := f.failed.Load().(error)
+	return err
+}
+
+func (f *FileStore) Reserve(b Booking) error {
+	return f.write(func() error {
+		if err := f.mem.Reserve(b); err != nil {
+			return err
+		}
+		if err := f.append(walRecord{Op: opReserve, Booking: &b}); err != nil {
+			_, _ = f.mem.Release(b.ID)
+			return err
+		}
+		return nil
+	})
+}
+
+// ReserveAll logs the whole batch as a single record, so a crash never
+// restores only part of it.
+func (f *FileStore) ReserveAll(bookings []Booking) error {
+	return f.write(func() error {
+		if err := f.mem.ReserveAll(bookings); err != nil {
+			return err
+		}
+		if err := f.append(walRecord{Op: opReserveAll, Bookings: bookings}); err != nil {
+			for _, b := range bookings {
+				_, _ = f.mem.Release(b.ID)
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (f *FileStore) Release(id string) (b Booking, err error) {
+	err = f.write(func() error {
+		if b, err = f.mem.Release(id); err != nil {
+			return err
+		}
+		if err := f.append(walRecord{Op: opRelease, ID: id}); err != nil {
+			_ = f.mem.Reserve(b)
+			b = Booking{}
+			return err
+		}
+		return nil
+	})
+	return b, err
+}
+
+func (f *FileStore) Cancel(id string, refund Refund) (b Booking, err error) {
+	err = f.write(func() error {
+		if b, err = f.mem.Cancel(id, refund); err != nil {
+			return err
+		}
+		if err := f.append(walRecord{Op: opCancel, ID: id, Refund: &refund}); err != nil {
+			f.mem.dropRefund(refund.ID)
+			_ = f.mem.reinstate(b)
+			b = Booking{}
+			return err
+		}
+		return nil
+	})
+	return b, err
+}
+
+func (f *FileStore) Move(id string, seat int32) (b Booking, err error) {
+	err = f.write(func() error {
+		old, _ := f.mem.Lookup(id)
+		if b, err = f.mem.Move(id, seat); err != nil {
+			return err
+		}
+		if err := f.append(walRecord{Op: opMove, ID: id, Seat: seat}); err != nil {
+			_, _ = f.mem.Move(id, old.Seat)
+			b = Booking{}
+			return err
+		}
+		return nil
+	})
+	return b, err
+}
+
+func (f *FileStore) Confirm(id string, now int64) (b Booking, err error) {
+	err = f.write(func() error {
+		old, _ := f.mem.Lookup(id)
+		if b, err = f.mem.Confirm(id, now); err != nil {
+			return err
+		}
+		if err := f.append(walRecord{Op: opConfirm, ID: id}); err != nil {
+			f.mem.hold(id, old.HeldUntil)
+			b = Booking{}
+			return err
+		}
+		return nil
+	})
+	return b, err
+}
+
+func (f *FileStore) Unconfirm(id string, heldUntil int64) (b Booking, err error) {
+	err = f.write(func() error {
+		if b, err = f.mem.Unconfirm(id, heldUntil); err != nil {
+			return err
+		}
+		if err := f.append(walRecord{Op: opUnconfirm, ID: id, Until: heldUntil}); err != nil {
+			f.mem.hold(id, 0)
+			b = Booking{}
+			return err
+		}
+		return nil
+	})
+	return b, err
+}
+
+func (f *FileStore) ReleaseHold(id string) (b Booking, err error) {
+	err = f.write(func() error {
+		if b, err = f.mem.ReleaseHold(id); err != nil {
+			return err
+		}
+		if err := f.append(walRecord{Op: opRelease, ID: id}); err != nil {
+			_ = f.mem.Reserve(b)
+			b = Booking{}
+			return err
+		}
+		return nil
+	})
+	return b, err
+}
+
+func (f *FileStore) ReleaseExpired(now int64) (released []Booking, err error) {
+	err = f.write(func() error {
+		released, err = f.mem.ReleaseExpired(now)
+		if err != nil || len(released) == 0 {
+			return err
+		}
+		ids := make([]string, len(released))
+		for i, b := range released {
+			ids[i] = b.ID
+		}
+		if err := f.append(walRecord{Op: opReleaseAll, IDs: ids}); err != nil {
+			_ = f.mem.ReserveAll(released)
+			released = nil
+			return err
+		}
+		return nil
+	})
+	return released, err
+}
+
+func (f *FileStore) Enqueue(w WaitlistEntry) (pos int, err error) {
+	err = f.write(func() error {
+		if pos, err = f.mem.Enqueue(w); err != nil {
+			return err
+		}
+		if err := f.append(walRecord{Op: opEnqueue, Entry: &w}); err != nil {
+			_, _ = f.mem.Dequeue(w.ID)
+			pos = 0
+			return err
+		}
+		return nil
+	})
+	return pos, err
+}
+
+func (f *FileStore) Dequeue(id string) (w WaitlistEntry, err error) {
+	err = f.write(func() error {
+		_, pos, _ := f.mem.LookupWaitlist(id)
+		if w, err = f.mem.Dequeue(id); err != nil {
+			return err
+		}
+		if err := f.append(walRecord{Op: opDequeue, ID: id}); err != nil {
+			f.mem.requeue(w, pos)
+			w = WaitlistEntry{}
+			return err
+		}
+		return nil
+	})
+	return w, err
+}
+
+func (f *FileStore) Waitlist(trip Trip) []WaitlistEntry {
+	return f.mem.Waitlist(trip)
+}
+
+func (f *FileStore) LookupWaitlist(id string) (WaitlistEntry, int, bool) {
+	return f.mem.LookupWaitlist(id)
+}
+
+func (f *FileStore) LookupRefund(id string) (Refund, bool) {
+	return f.mem.LookupRefund(id)
+}
+
+func (f *FileStore) AddVoucher(v Voucher) error {
+	return f.write(func() error {
+		if err := f.mem.AddVoucher(v); err != nil {
+			return err
+		}
+		if err := f.append(walRecord{Op: opAddVoucher, Voucher: &v}); err != nil {
+			_, _ = f.mem.DeleteVoucher(v.Code)
+			return err
+		}
+		return nil
+	})
+}
+
+func (f *FileStore) DeleteVoucher(code string) (v Voucher, err error) {
+	err = f.write(func() error {
+		if v, err = f.mem.DeleteVoucher(code); err != nil {
+			return err
+		}
+		if err := f.append(walRecord{Op: opDelVoucher, ID: code}); err != nil {
+			_ = f.mem.AddVoucher(v)
+			v = Voucher{}
+			return err
+		}
+		return nil
+	})
+	return v, err
+}
+
+func (f *FileStore) LookupVoucher(code string) (Voucher, bool) {
+	return f.mem.LookupVoucher(code)
+}
+
+func (f *FileStore) ListVouchers() []Voucher {
+	return f.mem.ListVouchers()
+}
+
+func (f *FileStore) AddAccount(a Account) error {
+	return f.write(func() error {
+		if err := f.mem.AddAccount(a); err != nil {
+			return err
+		}
+		if err := f.append(walRecord{Op: opAddAccount, Account: &a}); err != nil {
+			f.mem.dropAccount(a.Email)
+			return err
+		}
+		return nil
+	})
+}
+
+func (f *FileStore) LookupAccount(email string) (Account, bool) {
+	return f.mem.LookupAccount(email)
+}
+
+func (f *FileStore) Revoke(r Revocation) (revoked bool, err error) {
+	err = f.write(func() error {
+		if revoked, err = f.mem.Revoke(r); err != nil || revoked {
+			return err
+		}
+		if err := f.append(walRecord{Op: opRevoke, Revoked: &r}); err != nil {
+			f.mem.dropRevocation(r.ID)
+			return err
+		}
+		return nil
+	})
+	return revoked, err
+}
+
+func (f *FileStore) IsRevoked(id string) bool {
+	return f.mem.IsRevoked(id)
+}
+
+func (f *FileStore) Lookup(id string) (Booking, bool) {
+	return f.mem.Lookup(id)
+}
+
+func (f *FileStore) ListByEmail(email string) []Booking {
+	return f.mem.ListByEmail(email)
+}
+
+func (f *FileStore) ListSection(trip Trip, section string, seg Segment) ([]*Booking, error) {
+	return f.mem.ListSection(trip, section, seg)
+}
+
+func (f *FileStore) ListPassengers(trip Trip, section string) ([]Booking, error) {
+	return f.mem.ListPassengers(trip, section)
+}
+
+// append writes rec to the log, for write to sync. Once written the change is
+// committed: a failed snapshot is only logged and tried again on the next
+// write, as the log still holds every record.
+func (f *FileStore) append(rec walRecord) error {
+	if err := f.syncErr(); err != nil {
+		return err
+	}
+	rec.Seq = f.seq + 1
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := f.wal.Write(line); err != nil {
+		// Cut off what was written, so the next record does not follow a
+		// partial one.
+		_ = f.wal.Truncate(f.walSize)
+		return fmt.Errorf("cannot write wal: %v", err)
+	}
+	f.walSize += int64(len(line))
+	f.seq = rec.Seq
+	f.written.Store(rec.Seq)
+	f.sinceSnapshot++
+	if f.sinceSnapshot >= f.snapshotEvery {
+		if err := f.snapshot(); err != nil {
+			log.Printf("Failed to snapshot bookings, will retry: %v", err)
+		}
+	}
+	return nil
+}
+
+// snapshot dumps the current state and truncates the log. The snapshot is
+// written to a temporary file first so a crash never leaves a partial one.
+func (f *FileStore) snapshot() error {
+	data, err := json.Marshal(snapshot{Seq: f.seq, Bookings: f.mem.all(), Waitlist: f.mem.allWaiting(), Refunds: f.mem.allRefunds(), Vouchers: f.mem.ListVouchers(), Accounts: f.mem.allAccounts(), Revoked: f.mem.allRevocations()})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(f.dir, snapshotFileName)
+	tmp, err := os.CreateTemp(f.dir, snapshotFileName+".*")
+	if err != nil {
+		return fmt.Errorf("cannot create snapshot: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write snapshot: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot sync snapshot: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("cannot rename snapshot: %v", err)
+	}
+	// Records up to f.seq are now covered by the snapshot and skipped on
+	// replay, so a crash before the truncate below is harmless.
+	if err := f.wal.Truncate(0); err != nil {
+		return fmt.Errorf("cannot truncate wal: %v", err)
+	}
+	f.walSize = 0
+	f.sinceSnapshot = 0
+	return nil
+}
+
+func (f *FileStore) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(f.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read snapshot: %v", err)
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("cannot parse snapshot: %v", err)
+	}
+	for _, b := range snap.Bookings {
+		if err := f.mem.Reserve(b); err != nil {
+			return fmt.Errorf("cannot restore booking %v: %v", b.ID, err)
+		}
+	}
+	for _, w := range snap.Waitlist {
+		if _, err := f.mem.Enqueue(w); err != nil {
+			return fmt.Errorf("cannot restore waitlist entry %v: %v", w.ID, err)
+		}
+	}
+	for _, r := range snap.Refunds {
+		f.mem.refunds[r.ID] = r
+	}
+	// Redemptions of cancelled bookings still count, so uses are restored as
+	// they were rather than recounted from the bookings.
+	for _, v := range snap.Vouchers {
+		if err := f.mem.AddVoucher(v); err != nil {
+			return fmt.Errorf("cannot restore voucher %v: %v", v.Code, err)
+		}
+		if v.Uses > 0 {
+			f.mem.uses[v.Code] = v.Uses
+		}
+	}
+	for _, a := range snap.Accounts {
+		if err := f.mem.AddAccount(a); err != nil {
+			return fmt.Errorf("cannot restore account %v: %v", a.Email, err)
+		}
+	}
+	for _, r := range snap.Revoked {
+		if _, err := f.mem.Revoke(r); err != nil {
+			return fmt.Errorf("cannot restore revocation %v: %v", r.ID, err)
+		}
+	}
+	f.seq = snap.Seq
+	return nil
+}
+
+// replay applies every log record newer than the snapshot. A torn record at
+// the end of the log, a last line without its newline after a crash in the
+// middle of a write, is cut off. Any other record which cannot be read means
+// the log is corrupt and fails the replay rather than losing what follows.
+func (f *FileStore) replay() error {
+	path := filepath.Join(f.dir, walFileName)
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot open wal: %v", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				return file.Truncate(offset)
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read wal: %v", err)
+		}
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("corrupt wal record at offset %d: %v", offset, err)
+		}
+		offset += int64(len(line))
+		if rec.Seq <= f.seq {
+			continue
+		}
+		if err := f.apply(rec); err != nil {
+			return fmt.Errorf("cannot replay wal record %d: %v", rec.Seq, err)
+		}
+		f.seq = rec.Seq
+		f.sinceSnapshot++
+	}
+	return nil
+}
+
+func (f *FileStore) apply(rec walRecord) error {
+	switch rec.Op {
+	case opReserve:
+		if rec.Booking == nil {
+			return fmt.Errorf("missing booking")
+		}
+		return f.mem.Reserve(*rec.Booking)
+	case opReserveAll:
+		return f.mem.ReserveAll(rec.Bookings)
+	case opRelease:
+		_, err := f.mem.Release(rec.ID)
+		return err
+	case opReleaseAll:
+		for _, id := range rec.IDs {
+			if _, err := f.mem.Release(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	case opMove:
+		_, err := f.mem.Move(rec.ID, rec.Seat)
+		return err
+	case opConfirm:
+		// The hold was valid when it was logged, whatever the time now.
+		_, err := f.mem.Confirm(rec.ID, 0)
+		return err
+	case opUnconfirm:
+		_, err := f.mem.Unconfirm(rec.ID, rec.Until)
+		return err
+	case opEnqueue:
+		if rec.Entry == nil {
+			return fmt.Errorf("missing waitlist entry")
+		}
+		_, err := f.mem.Enqueue(*rec.Entry)
+		return err
+	case opCancel:
+		if rec.Refund == nil {
+			return fmt.Errorf("missing refund")
+		}
+		_, err := f.mem.Cancel(rec.ID, *rec.Refund)
+		return err
+	case opDequeue:
+		_, err := f.mem.Dequeue(rec.ID)
+		return err
+	case opAddVoucher:
+		if rec.Voucher == nil {
+			return fmt.Errorf("missing voucher")
+		}
+		return f.mem.AddVoucher(*rec.Voucher)
+	case opDelVoucher:
+		_, err := f.mem.DeleteVoucher(rec.ID)
+		return err
+	case opAddAccount:
+		if rec.Account == nil {
+			return fmt.Errorf("missing account")
+		}
+		return f.mem.AddAccount(*rec.Account)
+	case opRevoke:
+		if rec.Revoked == nil {
+			return fmt.Errorf("missing revocation")
+		}
+		_, err := f.mem.Revoke(*rec.Revoked)
+		return err
+	default:
+		return fmt.Errorf("unknown op %q", rec.Op)
+	}
+}
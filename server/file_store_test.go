@@ -0,0 +1,364 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStore_Recovery(t *testing.T) {
+	conf := newTestStoreConfig()
+	storage := StorageConfig{Dir: t.TempDir(), SnapshotEvery: 3}
+	user1 := &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1}
+	user2 := &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2}
+	user3 := &proto.User{FirstName: firstName3, LastName: lastName3, Email: email3}
+
+	store, err := OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID1, Trip: Trip{Route: 0}, Section: section1, Seat: 0, User: user1}))
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID2, Trip: Trip{Route: 0}, Section: section1, Seat: 1, User: user2}))
+	// A rejected change is not logged.
+	_, err = store.Move(bookingID1, 1)
+	assert.Error(t, err)
+	// The third record triggers a snapshot, the following ones stay in the log.
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID3, Trip: Trip{Route: 0}, Section: section2, Seat: 0, User: user3}))
+	_, err = store.Release(bookingID2)
+	assert.NoError(t, err)
+	_, err = store.Move(bookingID1, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+
+	_, err = os.Stat(filepath.Join(storage.Dir, snapshotFileName))
+	assert.NoError(t, err)
+
+	store, err = OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	b, ok := store.Lookup(bookingID1)
+	assert.True(t, ok)
+	assert.Equal(t, section1, b.Section)
+	assert.Equal(t, int32(1), b.Seat)
+	assert.Equal(t, firstName1, b.User.FirstName)
+
+	_, ok = store.Lookup(bookingID2)
+	assert.False(t, ok)
+
+	b, ok = store.Lookup(bookingID3)
+	assert.True(t, ok)
+	assert.Equal(t, section2, b.Section)
+}
+
+func TestFileStore_TornWrite(t *testing.T) {
+	conf := newTestStoreConfig()
+	storage := StorageConfig{Dir: t.TempDir()}
+	user1 := &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1}
+
+	store, err := OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID1, Trip: Trip{Route: 0}, Section: section1, Seat: 0, User: user1}))
+	assert.NoError(t, store.Close())
+
+	// Simulate a crash in the middle of appending the next record.
+	wal, err := os.OpenFile(filepath.Join(storage.Dir, walFileName), os.O_WRONLY|os.O_APPEND, 0)
+	assert.NoError(t, err)
+	_, err = wal.WriteString(`{"seq":2,"op":"rel`)
+	assert.NoError(t, err)
+	assert.NoError(t, wal.Close())
+
+	store, err = OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	_, ok := store.Lookup(bookingID1)
+	assert.True(t, ok)
+	_, err = store.Release(bookingID1)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+
+	store, err = OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	_, ok = store.Lookup(bookingID1)
+	assert.False(t, ok)
+	assert.NoError(t, store.Close())
+
+	// A complete record which cannot be read is not a torn write: the records
+	// after it must not be dropped.
+	wal, err = os.OpenFile(filepath.Join(storage.Dir, walFileName), os.O_WRONLY|os.O_APPEND, 0)
+	assert.NoError(t, err)
+	_, err = wal.WriteString("{\"seq\":3,\"op\":\n{\"seq\":4,\"op\":\"release\",\"id\":\"BK-1\"}\n")
+	assert.NoError(t, err)
+	assert.NoError(t, wal.Close())
+	_, err = OpenFileStore(conf, storage)
+	assert.ErrorContains(t, err, "corrupt wal record")
+	data, err := os.ReadFile(filepath.Join(storage.Dir, walFileName))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"seq":4`)
+}
+
+func TestFileStore_SnapshotFails(t *testing.T) {
+	conf := newTestStoreConfig()
+	storage := StorageConfig{Dir: t.TempDir(), SnapshotEvery: 1}
+	user1 := &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1}
+	user2 := &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2}
+
+	store, err := OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	// A directory in the way makes renaming the snapshot fail, but the logged
+	// change stands.
+	blocked := filepath.Join(storage.Dir, snapshotFileName)
+	assert.NoError(t, os.Mkdir(blocked, 0o755))
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID1, Trip: Trip{Route: 0}, Section: section1, Seat: 0, User: user1}))
+	_, ok := store.Lookup(bookingID1)
+	assert.True(t, ok)
+	assert.NoError(t, store.Close())
+
+	assert.NoError(t, os.Remove(blocked))
+	store, err = OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	_, ok = store.Lookup(bookingID1)
+	assert.True(t, ok)
+	// The snapshot is taken on the next write.
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID2, Trip: Trip{Route: 0}, Section: section1, Seat: 1, User: user2}))
+	assert.NoError(t, store.Close())
+	_, err = os.Stat(blocked)
+	assert.NoError(t, err)
+
+	store, err = OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	defer store.Close()
+	assert.Len(t, store.ListByEmail(email1), 1)
+	assert.Len(t, store.ListByEmail(email2), 1)
+}
+
+// Run with `go test -race` to also catch unsynchronised access around the
+// shared syncs.
+func TestFileStore_ConcurrentWrites(t *testing.T) {
+	conf := newTestStoreConfig()
+	storage := StorageConfig{Dir: t.TempDir(), SnapshotEvery: 50}
+	store, err := OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+
+	const writers = 200
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := store.Reserve(Booking{
+				ID:      fmt.Sprintf("BK-%d", i),
+				Trip:    Trip{Route: 0, DepartsAt: int64(i)},
+				Section: section1,
+				User:    &proto.User{FirstName: firstName1, LastName: lastName1, Email: fmt.Sprintf("u%d@example.com", i)},
+			})
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	assert.NoError(t, store.Close())
+
+	store, err = OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	defer store.Close()
+	for i := 0; i < writers; i++ {
+		_, ok := store.Lookup(fmt.Sprintf("BK-%d", i))
+		assert.True(t, ok)
+	}
+}
+
+func TestFileStore_ReserveAll(t *testing.T) {
+	conf := newTestStoreConfig()
+	storage := StorageConfig{Dir: t.TempDir()}
+	user1 := &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1}
+	user2 := &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2}
+
+	store, err := OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	assert.NoError(t, store.ReserveAll([]Booking{
+		{ID: bookingID1, Trip: Trip{Route: 0}, Section: section1, Seat: 0, User: user1},
+		{ID: bookingID2, Trip: Trip{Route: 0}, Section: section1, Seat: 1, User: user2},
+	}))
+	assert.NoError(t, store.Close())
+
+	store, err = OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	defer store.Close()
+	bookings, err := store.ListSection(Trip{Route: 0}, section1, Segment{})
+	assert.NoError(t, err)
+	assert.Equal(t, email1, bookings[0].User.Email)
+	assert.Equal(t, email2, bookings[1].User.Email)
+}
+
+func TestFileStore_Holds(t *testing.T) {
+	conf := newTestStoreConfig()
+	storage := StorageConfig{Dir: t.TempDir()}
+	user1 := &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1}
+	user2 := &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2}
+	user3 := &proto.User{FirstName: firstName3, LastName: lastName3, Email: email3}
+
+	store, err := OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID1, Trip: Trip{Route: 0}, Section: section1, Seat: 0, User: user1, HeldUntil: 100}))
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID2, Trip: Trip{Route: 0}, Section: section1, Seat: 1, User: user2, HeldUntil: 100}))
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID3, Trip: Trip{Route: 0}, Section: section2, Seat: 0, User: user3, HeldUntil: 300}))
+	_, err = store.Confirm(bookingID1, 50)
+	assert.NoError(t, err)
+	released, err := store.ReleaseExpired(200)
+	assert.NoError(t, err)
+	assert.Len(t, released, 1)
+	_, err = store.Confirm(bookingID3, 50)
+	assert.NoError(t, err)
+	_, err = store.Unconfirm(bookingID3, 300)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+
+	store, err = OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	defer store.Close()
+	b, ok := store.Lookup(bookingID1)
+	assert.True(t, ok)
+	assert.Zero(t, b.HeldUntil)
+	_, ok = store.Lookup(bookingID2)
+	assert.False(t, ok)
+	b, ok = store.Lookup(bookingID3)
+	assert.True(t, ok)
+	assert.Equal(t, int64(300), b.HeldUntil)
+}
+
+func TestFileStore_Waitlist(t *testing.T) {
+	conf := newTestStoreConfig()
+	storage := StorageConfig{Dir: t.TempDir(), SnapshotEvery: 3}
+	user1 := &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1}
+	user2 := &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2}
+	user3 := &proto.User{FirstName: firstName3, LastName: lastName3, Email: email3}
+	trip := Trip{Route: 0}
+
+	store, err := OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	for _, w := range []WaitlistEntry{
+		{ID: bookingID1, Trip: trip, User: user1},
+		{ID: bookingID2, Trip: trip, User: user2},
+		{ID: bookingID3, Trip: trip, User: user3},
+	} {
+		_, err := store.Enqueue(w)
+		assert.NoError(t, err)
+	}
+	_, err = store.Dequeue(bookingID1)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+
+	// The queue went into the snapshot and the dequeue into the log.
+	store, err = OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	defer store.Close()
+	queue := store.Waitlist(trip)
+	assert.Len(t, queue, 2)
+	assert.Equal(t, bookingID2, queue[0].ID)
+	assert.Equal(t, bookingID3, queue[1].ID)
+}
+
+func TestFileStore_Cancel(t *testing.T) {
+	conf := newTestStoreConfig()
+	storage := StorageConfig{Dir: t.TempDir()}
+	user1 := &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1}
+
+	store, err := OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID1, Trip: Trip{Route: 0}, Section: section1, Seat: 0, User: user1, Price: 30}))
+	_, err = store.Cancel(bookingID1, Refund{ID: "RF-1", BookingID: bookingID1, User: user1, Price: 30, Amount: 15})
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+
+	store, err = OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	defer store.Close()
+	_, ok := store.Lookup(bookingID1)
+	assert.False(t, ok)
+	r, ok := store.LookupRefund("RF-1")
+	assert.True(t, ok)
+	assert.Equal(t, bookingID1, r.BookingID)
+	assert.Equal(t, int32(15), r.Amount)
+}
+
+func TestFileStore_Vouchers(t *testing.T) {
+	conf := newTestStoreConfig()
+	// Snapshot every second record, so uses are restored from a snapshot
+	// and from the log.
+	storage := StorageConfig{Dir: t.TempDir(), SnapshotEvery: 2}
+	user1 := &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1}
+	user2 := &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2}
+
+	store, err := OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	assert.NoError(t, store.AddVoucher(Voucher{Code: "SPRING", Percent: 10, MaxUses: 2}))
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID1, Trip: Trip{Route: 0}, Section: section1, Seat: 0, User: user1, Voucher: "SPRING", Discount: 2}))
+	assert.NoError(t, store.AddVoucher(Voucher{Code: "GONE", Amount: 5}))
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID2, Trip: Trip{Route: 0}, Section: section1, Seat: 1, User: user2, Voucher: "SPRING", Discount: 2}))
+	// The cancelled booking stays redeemed.
+	_, err = store.Cancel(bookingID1, Refund{ID: "RF-1", BookingID: bookingID1, User: user1, Price: 18, Amount: 18})
+	assert.NoError(t, err)
+	_, err = store.DeleteVoucher("GONE")
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+
+	store, err = OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	defer store.Close()
+	v, ok := store.LookupVoucher("SPRING")
+	assert.True(t, ok)
+	assert.Equal(t, 2, v.Uses)
+	_, ok = store.LookupVoucher("GONE")
+	assert.False(t, ok)
+	err = store.Reserve(Booking{ID: bookingID3, Trip: Trip{Route: 0}, Section: section2, Seat: 0, User: &proto.User{Email: email3}, Voucher: "SPRING"})
+	assert.ErrorIs(t, err, errVoucherUsedUp)
+}
+
+func TestFileStore_Accounts(t *testing.T) {
+	conf := newTestStoreConfig()
+	storage := StorageConfig{Dir: t.TempDir(), SnapshotEvery: 2}
+
+	store, err := OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	for _, email := range []string{email1, email2, email3} {
+		assert.NoError(t, store.AddAccount(Account{Email: email, PasswordHash: "hash-" + email}))
+	}
+	assert.ErrorIs(t, store.AddAccount(Account{Email: email1}), errAccountExists)
+	assert.NoError(t, store.Close())
+
+	store, err = OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	defer store.Close()
+	for _, email := range []string{email1, email2, email3} {
+		a, ok := store.LookupAccount(email)
+		assert.True(t, ok)
+		assert.Equal(t, "hash-"+email, a.PasswordHash)
+	}
+	_, ok := store.LookupAccount(email4)
+	assert.False(t, ok)
+}
+
+func TestFileStore_Revocations(t *testing.T) {
+	conf := newTestStoreConfig()
+	storage := StorageConfig{Dir: t.TempDir(), SnapshotEvery: 2}
+	expires := time.Now().Add(time.Hour).Unix()
+
+	store, err := OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	for _, id := range []string{"TK-1", "TK-2", "TK-3"} {
+		_, err := store.Revoke(Revocation{ID: id, ExpiresAt: expires})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, store.Close())
+
+	store, err = OpenFileStore(conf, storage)
+	assert.NoError(t, err)
+	defer store.Close()
+	for _, id := range []string{"TK-1", "TK-2", "TK-3"} {
+		assert.True(t, store.IsRevoked(id))
+	}
+	assert.False(t, store.IsRevoked("TK-4"))
+}
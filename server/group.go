@@ -0,0 +1,240 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+)
+
+type seatRef struct {
+	section string
+	seat    int32
+}
+
+// PurchaseGroup books one seat per passenger on the same trip. Seats are
+// allocated next to each other whenever possible, and either every passenger
+// gets a seat or nobody does. The group pays with a single payment, and any
+// change is recorded on the booking of the first passenger.
+func (s *TrainServer) PurchaseGroup(ctx context.Context, req *proto.PurchaseGroupRequest) (*proto.PurchaseGroupResponse, error) {
+	if len(req.Users) == 0 {
+		return nil, fmt.Errorf("group must have at least one passenger")
+	}
+	emails := make(map[string]bool, len(req.Users))
+	for _, user := range req.Users {
+		if _, err := isValidUser(user); err != nil {
+			return nil, err
+		}
+		if emails[user.Email] {
+			return nil, fmt.Errorf("duplicate passenger: %v", user.Email)
+		}
+		emails[user.Email] = true
+	}
+	if err := checkGroupPassengers(req.Users); err != nil {
+		return nil, err
+	}
+	index, seg, err := s.getRouteIndex(req.From, req.To, req.DepartsAt)
+	if err != nil {
+		return nil, err
+	}
+	trip := Trip{Route: int32(index), DepartsAt: req.DepartsAt}
+	fares := s.fares(trip, seg, time.Now())
+	// The price covers the whole group, so it must pay for every passenger.
+	var least int32
+	for _, user := range req.Users {
+		totals, _ := s.addCharges(trip.Route, s.concessionFares(fares, user.PassengerType))
+		least += cheapest(totals)
+	}
+	if least > req.Price {
+		return nil, errNotEnoughMoney
+	}
+	for _, user := range req.Users {
+		if s.isAlreadyPurchased(user.Email, trip) {
+			return nil, fmt.Errorf("already purchased: %v", user.Email)
+		}
+	}
+	bookings := make([]Booking, len(req.Users))
+	for i, user := range req.Users {
+		bookings[i] = s.newBooking(newBookingID(), trip, user)
+		bookings[i].Segment = seg
+	}
+	total, err := s.purchaseGroupSeats(ctx, bookings, fares, req.Price)
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.PurchaseGroupResponse{
+		Tickets:       make([]*proto.PurchaseResponse, 0, len(bookings)),
+		AmountCharged: total,
+		Change:        bookings[0].Change,
+		Currency:      s.routeCurrency(trip.Route),
+		Message:       "Group purchased successfully",
+	}
+	for _, booking := range bookings {
+		resp.Tickets = append(resp.Tickets, s.purchaseResponse(booking))
+	}
+	s.logger.Info("PurchaseGroup", resp)
+	return resp, nil
+}
+
+// purchaseGroupSeats books seats for all bookings of one trip at once, filling
+// in their seats and fares, and charges the total with a single payment. As in
+// purchase, the payment is authorized before the seats are taken and we look
+// again whenever another request took one of the chosen seats first, up to
+// maxSeatAttempts times. Any change is recorded on the first booking.
+func (s *TrainServer) purchaseGroupSeats(ctx context.Context, bookings []Booking, fares map[string]int32, paid int32) (int32, error) {
+	sections := s.groupSections(bookings, fares, paid)
+	if len(sections) == 0 {
+		return 0, errNotEnoughMoney
+	}
+	for attempt := 1; attempt <= maxSeatAttempts; attempt++ {
+		seats, err := s.findGroupSeats(bookings[0].Trip, bookings[0].Segment, sections, len(bookings))
+		if err != nil {
+			return 0, err
+		}
+		var total int32
+		for i := range bookings {
+			bookings[i].Section, bookings[i].Seat = seats[i].section, seats[i].seat
+			charges := s.charges(bookings[i].Route, s.concession(fares[seats[i].section], bookings[i].User.PassengerType))
+			bookings[i].Price, bookings[i].Charges = charges.Total, &charges
+			total += bookings[i].Price
+		}
+		if total > paid {
+			return 0, errNotEnoughMoney
+		}
+		bookings[0].Change = paid - total
+		err = s.reserveAll(ctx, fmt.Sprintf("%s/%d", bookings[0].ID, attempt), bookings, total, s.routeCurrency(bookings[0].Route))
+		if errors.Is(err, errSeatOccupied) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return total, nil
+	}
+	return 0, errSeatContention
+}
+
+// reserveAll charges amount for bookings with a single payment and books all
+// of them at once. It fails with errSeatOccupied, having voided the payment,
+// if another request took one of the seats first.
+func (s *TrainServer) reserveAll(ctx context.Context, key string, bookings []Booking, amount int32, currency string) error {
+	payment, err := s.Payments.Authorize(ctx, PaymentRequest{
+		IdempotencyKey: key,
+		Email:          bookings[0].User.Email,
+		Amount:         amount,
+		Currency:       currency,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPaymentFailed, err)
+	}
+	for i := range bookings {
+		bookings[i].PaymentID = payment.ID
+	}
+	if err := s.Store.ReserveAll(bookings); err != nil {
+		s.voidPayment(payment.ID)
+		return err
+	}
+	if _, err := s.Payments.Capture(ctx, payment.ID); err != nil {
+		for _, b := range bookings {
+			_, _ = s.Store.Release(b.ID)
+		}
+		s.voidPayment(payment.ID)
+		return fmt.Errorf("%w: %v", errPaymentFailed, err)
+	}
+	return nil
+}
+
+// groupSections returns the sections where the whole group of bookings can
+// sit for paid, cheapest first.
+func (s *TrainServer) groupSections(bookings []Booking, fares map[string]int32, paid int32) []string {
+	costs := make(map[string]int32, len(fares))
+	var sections []string
+	for _, sec := range s.Conf.SectionNames() {
+		for _, b := range bookings {
+			costs[sec] += s.charges(b.Route, s.concession(fares[sec], b.User.PassengerType)).Total
+		}
+		if costs[sec] <= paid {
+			sections = append(sections, sec)
+		}
+	}
+	slices.SortStableFunc(sections, func(a, b string) int {
+		return cmp.Compare(costs[a], costs[b])
+	})
+	return sections
+}
+
+// findGroupSeats picks n seats of a trip among names which are free over seg.
+// It prefers a contiguous block in one section, then the tightest block in one
+// section, and finally spreads the group over as few sections as possible.
+// Between equally tight blocks the earlier section in names wins.
+func (s *TrainServer) findGroupSeats(trip Trip, seg Segment, names []string, n int) ([]seatRef, error) {
+	free := make(map[string][]int32, len(names))
+	total := 0
+	for _, sec := range names {
+		taken, err := s.Store.ListSection(trip, sec, seg)
+		if err != nil {
+			return nil, err
+		}
+		for i, b := range taken {
+			if b == nil {
+				free[sec] = append(free[sec], int32(i))
+			}
+		}
+		total += len(free[sec])
+	}
+	if total < n {
+		return nil, fmt.Errorf("cannot find %d empty seats", n)
+	}
+
+	// A contiguous block has the smallest possible span, so the tightest
+	// window over all sections covers both of the first two cases.
+	bestSection, bestStart, bestSpan := "", -1, int32(math.MaxInt32)
+	for _, sec := range names {
+		if start, span := tightestWindow(free[sec], n); start >= 0 && span < bestSpan {
+			bestSection, bestStart, bestSpan = sec, start, span
+		}
+	}
+	if bestSection != "" {
+		return seatRefs(bestSection, free[bestSection][bestStart:bestStart+n]), nil
+	}
+
+	sections := slices.Clone(names)
+	slices.SortStableFunc(sections, func(a, b string) int {
+		return cmp.Compare(len(free[b]), len(free[a]))
+	})
+	seats := make([]seatRef, 0, n)
+	for _, sec := range sections {
+		take := min(n-len(seats), len(free[sec]))
+		if take == 0 {
+			break
+		}
+		start, _ := tightestWindow(free[sec], take)
+		seats = append(seats, seatRefs(sec, free[sec][start:start+take])...)
+	}
+	return seats, nil
+}
+
+// tightestWindow returns the start of the n consecutive entries of the sorted
+// seats with the smallest distance between first and last, or -1.
+func tightestWindow(seats []int32, n int) (int, int32) {
+	start, span := -1, int32(math.MaxInt32)
+	for i := 0; i+n <= len(seats); i++ {
+		if d := seats[i+n-1] - seats[i]; d < span {
+			start, span = i, d
+		}
+	}
+	return start, span
+}
+
+func seatRefs(section string, seats []int32) []seatRef {
+	refs := make([]seatRef, len(seats))
+	for i, seat := range seats {
+		refs[i] = seatRef{section: section, seat: seat}
+	}
+	return refs
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func groupUsers(n int) []*proto.User {
+	users := make([]*proto.User, n)
+	for i := range users {
+		users[i] = &proto.User{FirstName: firstName4, LastName: lastName4, Email: fmt.Sprintf("member%d@example.com", i)}
+	}
+	return users
+}
+
+func groupConfig(conf *TrainConfig) {
+	conf.SeatCount = 6
+}
+
+// takeSeats books the listed seats of the first route for other passengers.
+func takeSeats(t *testing.T, s *TrainServer, taken map[string][]int32) {
+	for sec, seats := range taken {
+		for _, seat := range seats {
+			err := s.Store.Reserve(Booking{
+				ID:      newBookingID(),
+				Trip:    Trip{Route: 0},
+				Section: sec,
+				Seat:    seat,
+				User:    &proto.User{FirstName: firstName1, LastName: lastName1, Email: fmt.Sprintf("%s%d@example.com", sec, seat)},
+			})
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func groupSeats(resp *proto.PurchaseGroupResponse) []string {
+	seats := make([]string, len(resp.Tickets))
+	for i, ticket := range resp.Tickets {
+		seats[i] = fmt.Sprintf("%s/%d", ticket.Section, ticket.Seat)
+	}
+	return seats
+}
+
+func TestTrainServer_PurchaseGroup(t *testing.T) {
+	t.Run("contiguous seats", func(t *testing.T) {
+		s := newTestServer(t, groupConfig)
+		takeSeats(t, s, map[string][]int32{section1: {1, 4}, section2: {0}})
+		resp, err := s.PurchaseGroup(context.Background(), &proto.PurchaseGroupRequest{
+			Users: groupUsers(3), From: from1, To: to1, Price: 3 * price1,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Section2/1", "Section2/2", "Section2/3"}, groupSeats(resp))
+		for i, ticket := range resp.Tickets {
+			b, ok := s.Store.Lookup(ticket.BookingId)
+			assert.True(t, ok)
+			assert.Equal(t, fmt.Sprintf("member%d@example.com", i), b.User.Email)
+		}
+	})
+
+	t.Run("nearest grouping", func(t *testing.T) {
+		s := newTestServer(t, groupConfig)
+		takeSeats(t, s, map[string][]int32{section1: {1, 3}, section2: {1, 2, 4}})
+		resp, err := s.PurchaseGroup(context.Background(), &proto.PurchaseGroupRequest{
+			Users: groupUsers(3), From: from1, To: to1, Price: 3 * price1,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Section1/2", "Section1/4", "Section1/5"}, groupSeats(resp))
+	})
+
+	t.Run("split over sections", func(t *testing.T) {
+		s := newTestServer(t, groupConfig)
+		takeSeats(t, s, map[string][]int32{section1: {0, 1, 2, 3}, section2: {0, 2, 4}})
+		resp, err := s.PurchaseGroup(context.Background(), &proto.PurchaseGroupRequest{
+			Users: groupUsers(4), From: from1, To: to1, Price: 4 * price1,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Section2/1", "Section2/3", "Section2/5", "Section1/4"}, groupSeats(resp))
+	})
+
+	t.Run("not enough seats", func(t *testing.T) {
+		s := newTestServer(t, groupConfig)
+		takeSeats(t, s, map[string][]int32{section1: {0, 1, 2, 3, 4}, section2: {0, 1, 2, 3, 4}})
+		_, err := s.PurchaseGroup(context.Background(), &proto.PurchaseGroupRequest{
+			Users: groupUsers(3), From: from1, To: to1, Price: 3 * price1,
+		})
+		assert.EqualError(t, err, "cannot find 3 empty seats")
+	})
+
+	t.Run("skips sections the group cannot afford", func(t *testing.T) {
+		s := newTestServer(t, func(conf *TrainConfig) {
+			groupConfig(conf)
+			conf.Sections[1].Percent = 150
+		})
+		takeSeats(t, s, map[string][]int32{section1: {1, 3, 5}})
+		resp, err := s.PurchaseGroup(context.Background(), &proto.PurchaseGroupRequest{
+			Users: groupUsers(2), From: from1, To: to1, Price: 2 * price1,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Section1/0", "Section1/2"}, groupSeats(resp))
+		assert.Equal(t, 2*price1, resp.AmountCharged)
+	})
+
+	t.Run("price covers whole group", func(t *testing.T) {
+		s := newTestServer(t, groupConfig)
+		_, err := s.PurchaseGroup(context.Background(), &proto.PurchaseGroupRequest{
+			Users: groupUsers(3), From: from1, To: to1, Price: 3*price1 - 1,
+		})
+		assert.EqualError(t, err, "you must pay more money")
+	})
+
+	t.Run("all or nothing", func(t *testing.T) {
+		s := newTestServer(t, groupConfig)
+		users := groupUsers(3)
+		seat := int32(0)
+		_, err := s.PurchaseTicket(context.Background(), &proto.PurchaseRequest{
+			User: users[2], From: from1, To: to1, Price: price1, Section: section1, Seat: &seat,
+		})
+		assert.NoError(t, err)
+		_, err = s.PurchaseGroup(context.Background(), &proto.PurchaseGroupRequest{
+			Users: users, From: from1, To: to1, Price: 3 * price1,
+		})
+		assert.EqualError(t, err, "already purchased: member2@example.com")
+		assert.Empty(t, s.Store.ListByEmail(users[0].Email))
+
+		// The store itself rejects the whole batch as well.
+		err = s.Store.ReserveAll([]Booking{
+			{ID: newBookingID(), Trip: Trip{Route: 0}, Section: section2, Seat: 0, User: users[0]},
+			{ID: newBookingID(), Trip: Trip{Route: 0}, Section: section2, Seat: 1, User: users[2]},
+		})
+		assert.ErrorIs(t, err, errAlreadyPurchased)
+		assert.Empty(t, s.Store.ListByEmail(users[0].Email))
+	})
+
+	t.Run("duplicate passenger", func(t *testing.T) {
+		s := newTestServer(t, groupConfig)
+		users := groupUsers(2)
+		users[1].Email = users[0].Email
+		_, err := s.PurchaseGroup(context.Background(), &proto.PurchaseGroupRequest{
+			Users: users, From: from1, To: to1, Price: 2 * price1,
+		})
+		assert.EqualError(t, err, "duplicate passenger: member0@example.com")
+	})
+}
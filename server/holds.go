@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultHoldTTL = 300
+
+// HoldSeat reserves a seat like PurchaseTicket, but only until the hold
+// expires. The hold id is needed to confirm or release it.
+func (s *TrainServer) HoldSeat(ctx context.Context, req *proto.PurchaseRequest) (*proto.HoldResponse, error) {
+	trip, seats, err := s.checkPurchase(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(s.holdTTL()).Unix()
+	booking := s.newBooking(newBookingID(), trip, req.User)
+	booking.HeldUntil = expiresAt
+	booking, err = s.purchase(ctx, booking.ID, booking, seats)
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.HoldResponse{
+		HoldId:           booking.ID,
+		Section:          booking.Section,
+		Seat:             booking.Seat,
+		Route:            booking.Route,
+		DepartsAt:        booking.DepartsAt,
+		ExpiresAt:        booking.HeldUntil,
+		AmountAuthorized: booking.Price,
+		Change:           booking.Change,
+		Currency:         s.routeCurrency(booking.Route),
+		Paid:             s.paidMoney(booking),
+		Message:          "Seat held successfully",
+	}
+	s.logger.Info("HoldSeat", resp)
+	return resp, nil
+}
+
+// ConfirmHold turns the hold into a purchased ticket, keeping its booking id,
+// and charges the payment authorized by HoldSeat. The hold is confirmed first,
+// so the reaper cannot release the seat while the payment is captured; if the
+// capture fails it is put back on hold.
+func (s *TrainServer) ConfirmHold(ctx context.Context, req *proto.ConfirmHoldRequest) (*proto.PurchaseResponse, error) {
+	if err := s.checkHold(req.HoldId); err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	hold, _ := s.Store.Lookup(req.HoldId)
+	booking, err := s.Store.Confirm(req.HoldId, now)
+	if errors.Is(err, errHoldExpired) {
+		// The reaper may not have run yet, so free the seat right away.
+		s.releaseExpired(now)
+		return nil, status.Errorf(codes.FailedPrecondition, "%v: %v", err, req.HoldId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if booking.PaymentID != "" {
+		if _, err := s.Payments.Capture(ctx, booking.PaymentID); err != nil {
+			if _, uerr := s.Store.Unconfirm(booking.ID, hold.HeldUntil); uerr != nil {
+				// Without its hold the seat must not stay booked unpaid.
+				s.logger.Error(uerr, "Unconfirm", "hold", booking.ID)
+				_, _ = s.Store.Release(booking.ID)
+				s.voidPayment(booking.PaymentID)
+			}
+			return nil, fmt.Errorf("%w: %v", errPaymentFailed, err)
+		}
+	}
+	resp := s.purchaseResponse(booking)
+	s.logger.Info("ConfirmHold", resp)
+	return resp, nil
+}
+
+// ReleaseHold gives a held seat back before the hold expires.
+func (s *TrainServer) ReleaseHold(_ context.Context, req *proto.ReleaseHoldRequest) (*proto.ReleaseHoldResponse, error) {
+	if err := s.checkHold(req.HoldId); err != nil {
+		return nil, err
+	}
+	hold, err := s.Store.ReleaseHold(req.HoldId)
+	if err != nil {
+		return nil, err
+	}
+	s.voidPayment(hold.PaymentID)
+	s.promoteWaitlist(hold.Trip)
+	resp := &proto.ReleaseHoldResponse{
+		Message: "Hold released successfully",
+	}
+	s.logger.Info("ReleaseHold", resp)
+	return resp, nil
+}
+
+// StartHoldReaper releases expired holds every interval until ctx is done.
+func (s *TrainServer) StartHoldReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.releaseExpired(now.Unix())
+			}
+		}
+	}()
+}
+
+// releaseExpired frees the holds which lapsed at or before now and hands the
+// seats to the waitlist.
+func (s *TrainServer) releaseExpired(now int64) {
+	released, err := s.Store.ReleaseExpired(now)
+	if err != nil {
+		s.logger.Error(err, "ReleaseExpired")
+		return
+	}
+	for _, b := range released {
+		s.voidPayment(b.PaymentID)
+		s.promoteWaitlist(b.Trip)
+	}
+	if len(released) > 0 {
+		s.logger.Info("ReleaseExpired", "released", len(released))
+	}
+}
+
+// checkHold maps an unknown or already confirmed hold id to a gRPC status.
+func (s *TrainServer) checkHold(holdID string) error {
+	b, ok := s.Store.Lookup(holdID)
+	if !ok {
+		return status.Errorf(codes.NotFound, "no hold found: %v", holdID)
+	}
+	if b.HeldUntil == 0 {
+		return status.Errorf(codes.FailedPrecondition, "%v: %v", errNotHeld, holdID)
+	}
+	return nil
+}
+
+func (s *TrainServer) holdTTL() time.Duration {
+	if s.Conf.HoldTTL <= 0 {
+		return defaultHoldTTL * time.Second
+	}
+	return time.Duration(s.Conf.HoldTTL) * time.Second
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func holdConfig(conf *TrainConfig) {
+	conf.HoldTTL = 60
+}
+
+func holdRequest(email string) *proto.PurchaseRequest {
+	return &proto.PurchaseRequest{
+		User:  &proto.User{FirstName: firstName1, LastName: lastName1, Email: email},
+		From:  from1,
+		To:    to1,
+		Price: price1,
+	}
+}
+
+func TestTrainServer_HoldSeat(t *testing.T) {
+	s := newTestServer(t, holdConfig)
+	ctx := context.Background()
+
+	t.Run("hold and confirm", func(t *testing.T) {
+		before := time.Now().Unix()
+		hold, err := s.HoldSeat(ctx, holdRequest(email1))
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, hold.ExpiresAt, before+60)
+
+		b, ok := s.Store.Lookup(hold.HoldId)
+		assert.True(t, ok)
+		assert.Equal(t, hold.ExpiresAt, b.HeldUntil)
+		_, err = s.PurchaseTicket(ctx, holdRequest(email1))
+		assert.ErrorIs(t, err, errAlreadyPurchased)
+
+		ticket, err := s.ConfirmHold(ctx, &proto.ConfirmHoldRequest{HoldId: hold.HoldId})
+		assert.NoError(t, err)
+		assert.Equal(t, hold.HoldId, ticket.BookingId)
+		assert.Equal(t, hold.Section, ticket.Section)
+		assert.Equal(t, hold.Seat, ticket.Seat)
+		b, _ = s.Store.Lookup(hold.HoldId)
+		assert.Zero(t, b.HeldUntil)
+
+		_, err = s.ConfirmHold(ctx, &proto.ConfirmHoldRequest{HoldId: hold.HoldId})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		_, err = s.ReleaseHold(ctx, &proto.ReleaseHoldRequest{HoldId: hold.HoldId})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	t.Run("release", func(t *testing.T) {
+		hold, err := s.HoldSeat(ctx, holdRequest(email2))
+		assert.NoError(t, err)
+		_, err = s.ReleaseHold(ctx, &proto.ReleaseHoldRequest{HoldId: hold.HoldId})
+		assert.NoError(t, err)
+		_, ok := s.Store.Lookup(hold.HoldId)
+		assert.False(t, ok)
+		_, err = s.ConfirmHold(ctx, &proto.ConfirmHoldRequest{HoldId: hold.HoldId})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		hold, err := s.HoldSeat(ctx, holdRequest(email3))
+		assert.NoError(t, err)
+		s.Store.(*MemoryStore).hold(hold.HoldId, time.Now().Unix()-1)
+
+		_, err = s.ConfirmHold(ctx, &proto.ConfirmHoldRequest{HoldId: hold.HoldId})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		_, ok := s.Store.Lookup(hold.HoldId)
+		assert.False(t, ok)
+	})
+}
+
+func TestTrainServer_HoldReaper(t *testing.T) {
+	s := newTestServer(t, holdConfig)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hold, err := s.HoldSeat(ctx, holdRequest(email1))
+	assert.NoError(t, err)
+	kept, err := s.HoldSeat(ctx, holdRequest(email2))
+	assert.NoError(t, err)
+	s.Store.(*MemoryStore).hold(hold.HoldId, time.Now().Unix()-1)
+
+	s.StartHoldReaper(ctx, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		_, ok := s.Store.Lookup(hold.HoldId)
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	_, ok := s.Store.Lookup(kept.HoldId)
+	assert.True(t, ok)
+	assert.Equal(t, int32(seatCount*2-1), s.availableSeats(Trip{Route: 0}, Segment{}))
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	proto "github.com/playbody/train-ticket-service/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	userClaimEmail = "email"
+	userClaimSub   = "sub"
+)
+
+// issuerKey is a public key published by a trusted issuer.
+type issuerKey struct {
+	kid    string
+	method jwt.SigningMethod
+	public interface{}
+}
+
+// trustedIssuer accepts the tokens of an external OAuth2/OIDC server for
+// its audience, and maps their claims onto capabilities.
+type trustedIssuer struct {
+	conf IssuerConfig
+	keys []issuerKey
+}
+
+// loadIssuer reads the JWKS file of conf.
+func loadIssuer(conf IssuerConfig) (*trustedIssuer, error) {
+	data, err := os.ReadFile(conf.JWKSFile)
+	if err != nil {
+		return nil, err
+	}
+	var jwks proto.JWKSResponse
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %v", err)
+	}
+	return newIssuer(conf, jwks.Keys)
+}
+
+// newIssuer trusts the tokens of conf signed with one of jwks.
+func newIssuer(conf IssuerConfig, jwks []*proto.JWK) (*trustedIssuer, error) {
+	issuer := &trustedIssuer{conf: conf}
+	for _, jwk := range jwks {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(jwk)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %v", jwk.Kid, err)
+		}
+		issuer.keys = append(issuer.keys, key)
+	}
+	if len(issuer.keys) == 0 {
+		return nil, errors.New("no signing key")
+	}
+	return issuer, nil
+}
+
+// parseJWK returns the RS256 or ES256 public key of jwk.
+func parseJWK(jwk *proto.JWK) (issuerKey, error) {
+	key := issuerKey{kid: jwk.Kid}
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBase64URL(jwk.N)
+		if err != nil {
+			return key, err
+		}
+		e, err := decodeBase64URL(jwk.E)
+		if err != nil {
+			return key, err
+		}
+		if n.BitLen() < minRSABits || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return key, errors.New("weak RSA key")
+		}
+		key.method, key.public = jwt.SigningMethodRS256, &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		if jwk.Crv != elliptic.P256().Params().Name {
+			return key, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeBase64URL(jwk.X)
+		if err != nil {
+			return key, err
+		}
+		y, err := decodeBase64URL(jwk.Y)
+		if err != nil {
+			return key, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return key, errors.New("point is not on the curve")
+		}
+		key.method, key.public = jwt.SigningMethodES256, &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	default:
+		return key, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+	if jwk.Alg != "" && jwk.Alg != key.method.Alg() {
+		return key, fmt.Errorf("unsupported algorithm %q", jwk.Alg)
+	}
+	return key, nil
+}
+
+func decodeBase64URL(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// verificationKey returns the key of the issuer token is signed with. A
+// token without kid is only accepted when the issuer has a single key.
+func (i *trustedIssuer) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range i.keys {
+		if key.kid != kid && (kid != "" || len(i.keys) > 1) {
+			continue
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return key.public, nil
+	}
+	return nil, errUnknownKey
+}
+
+// parse verifies token and maps its claims onto ours.
+func (i *trustedIssuer) parse(token string) (JwtClaims, error) {
+	claims := jwt.MapClaims{}
+	parsedToken, err := jwt.ParseWithClaims(token, claims, i.verificationKey)
+	if err != nil {
+		return JwtClaims{}, err
+	}
+	if !parsedToken.Valid {
+		return JwtClaims{}, errors.New("invalid token")
+	}
+	if iss, _ := claims["iss"].(string); iss != i.conf.Issuer {
+		return JwtClaims{}, errors.New("unexpected issuer")
+	}
+	if !slices.Contains(stringsClaim(claims["aud"]), i.conf.Audience) {
+		return JwtClaims{}, errors.New("unexpected audience")
+	}
+	userID, err := i.user(claims)
+	if err != nil {
+		return JwtClaims{}, err
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return JwtClaims{}, errors.New("token has no expiry")
+	}
+	// Like ours, tokens need an id so Logout and RevokeToken can revoke them.
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return JwtClaims{}, errors.New("token has no id")
+	}
+	result := JwtClaims{UserID: userID, Capabilities: i.capabilities(claims), TokenType: tokenAccess}
+	result.Id = jti
+	result.Issuer = i.conf.Issuer
+	result.ExpiresAt = int64(exp)
+	return result, nil
+}
+
+// user returns the user the token was issued to, by default its email, which
+// must not be unverified.
+func (i *trustedIssuer) user(claims jwt.MapClaims) (string, error) {
+	if i.conf.UserClaim == userClaimSub {
+		sub, _ := claims["sub"].(string)
+		if sub == "" {
+			return "", errors.New("token has no subject")
+		}
+		return sub, nil
+	}
+	email, _ := claims["email"].(string)
+	if verified, ok := claims["email_verified"].(bool); email == "" || ok && !verified {
+		return "", errors.New("token has no verified email")
+	}
+	return email, nil
+}
+
+// capabilities maps the scopes and roles of claims onto capabilities.
+func (i *trustedIssuer) capabilities(claims jwt.MapClaims) []string {
+	var capabilities []string
+	add := func(mapping map[string][]string, names []string) {
+		for _, name := range names {
+			for _, c := range mapping[name] {
+				if !slices.Contains(capabilities, c) {
+					capabilities = append(capabilities, c)
+				}
+			}
+		}
+	}
+	add(i.conf.Scopes, strings.Fields(strings.Join(stringsClaim(claims["scope"]), " ")))
+	add(i.conf.Scopes, stringsClaim(claims["scp"]))
+	add(i.conf.Roles, stringsClaim(claims["roles"]))
+	return capabilities
+}
+
+// stringsClaim returns a claim which is either a string or a list of them.
+func stringsClaim(claim interface{}) []string {
+	switch c := claim.(type) {
+	case string:
+		return []string{c}
+	case []interface{}:
+		values := make([]string, 0, len(c))
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// issuer returns the trusted issuer token claims to come from, if any. The
+// claim is only trusted once the issuer verified the token.
+func (k *KeyRing) issuer(token string) *trustedIssuer {
+	if len(k.issuers) == 0 {
+		return nil
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		return nil
+	}
+	iss, _ := claims["iss"].(string)
+	for _, issuer := range k.issuers {
+		if issuer.conf.Issuer == iss {
+			return issuer
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const testIssuer = "https://login.example.com/"
+
+// stubIssuer is an OIDC server whose JWKS is written to a file.
+type stubIssuer struct {
+	key  *ecdsa.PrivateKey
+	jwks string
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	ring := KeyRing{keys: []signingKey{{kid: "idp-1", method: jwt.SigningMethodES256, private: key, public: &key.PublicKey}}}
+	data, err := protojson.Marshal(&proto.JWKSResponse{Keys: ring.jwks(time.Now().Unix())})
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+	return &stubIssuer{key: key, jwks: path}
+}
+
+// token returns a token of the stub with claims, valid for an hour and with a
+// new id by default.
+func (s *stubIssuer) token(t *testing.T, claims jwt.MapClaims) string {
+	full := jwt.MapClaims{"iss": testIssuer, "aud": "trains", "exp": time.Now().Add(time.Hour).Unix(), "jti": newID("idp-")}
+	for name, value := range claims {
+		if value == nil {
+			delete(full, name)
+		} else {
+			full[name] = value
+		}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, full)
+	token.Header["kid"] = "idp-1"
+	signed, err := token.SignedString(s.key)
+	assert.NoError(t, err)
+	return "Bearer " + signed
+}
+
+func TestTrainServer_externalIssuer(t *testing.T) {
+	stub := newStubIssuer(t)
+	conf := IssuerConfig{
+		Issuer:   testIssuer,
+		Audience: "trains",
+		JWKSFile: stub.jwks,
+		Scopes:   map[string][]string{"tickets.read": {CapRead}},
+		Roles:    map[string][]string{"staff": {CapAdmin, CapRead, CapWrite}},
+	}
+	assert.NoError(t, SKeys.InitKeys(AuthConfig{Issuers: []IssuerConfig{conf}}))
+	defer func() { SKeys = KeyRing{} }()
+	s := newTestServer(t, nil)
+	claims := func(token string) (JwtClaims, error) {
+		ctx := tokenContext(t, s, token)
+		if err := AuthCheck(ctx, "*"); err != nil {
+			return JwtClaims{}, err
+		}
+		return ctx.Value("jwt").(JwtClaims), nil
+	}
+
+	t.Run("maps standard claims", func(t *testing.T) {
+		c, err := claims(stub.token(t, jwt.MapClaims{
+			"sub":   "1234",
+			"email": email2,
+			"scope": "openid tickets.read",
+			"roles": []string{"staff", "unknown"},
+			"jti":   "idp-token-1",
+		}))
+		assert.NoError(t, err)
+		assert.Equal(t, email2, c.UserID)
+		assert.Equal(t, []string{CapRead, CapAdmin, CapWrite}, c.Capabilities)
+		assert.Equal(t, testIssuer, c.Issuer)
+
+		c, err = claims(stub.token(t, jwt.MapClaims{"email": email3, "aud": []string{"other", "trains"}, "scp": []string{"tickets.read"}}))
+		assert.NoError(t, err)
+		assert.Equal(t, email3, c.UserID)
+		assert.Equal(t, []string{CapRead}, c.Capabilities)
+	})
+
+	t.Run("rejects invalid tokens", func(t *testing.T) {
+		for name, token := range map[string]string{
+			"audience":      stub.token(t, jwt.MapClaims{"email": email2, "aud": "other"}),
+			"expired":       stub.token(t, jwt.MapClaims{"email": email2, "exp": time.Now().Add(-time.Minute).Unix()}),
+			"no expiry":     stub.token(t, jwt.MapClaims{"email": email2, "exp": nil}),
+			"no email":      stub.token(t, jwt.MapClaims{"sub": "1234"}),
+			"unverified":    stub.token(t, jwt.MapClaims{"email": email2, "email_verified": false}),
+			"other issuer":  newStubIssuer(t).token(t, jwt.MapClaims{"email": email2}),
+			"untrusted iss": stub.token(t, jwt.MapClaims{"email": email2, "iss": "https://evil.example.com/"}),
+			"not yet valid": stub.token(t, jwt.MapClaims{"email": email2, "nbf": time.Now().Add(time.Hour).Unix()}),
+			"no issuer":     stub.token(t, jwt.MapClaims{"email": email2, "iss": nil}),
+			"no id":         stub.token(t, jwt.MapClaims{"email": email2, "jti": nil}),
+		} {
+			_, err := claims(token)
+			assert.Equal(t, codes.Unauthenticated, status.Code(err), name)
+		}
+	})
+
+	t.Run("subject as user", func(t *testing.T) {
+		sub := conf
+		sub.UserClaim = userClaimSub
+		issuer, err := loadIssuer(sub)
+		assert.NoError(t, err)
+		trusted := SKeys.issuers
+		SKeys.issuers = []*trustedIssuer{issuer}
+		defer func() { SKeys.issuers = trusted }()
+		c, err := claims(stub.token(t, jwt.MapClaims{"sub": "1234"}))
+		assert.NoError(t, err)
+		assert.Equal(t, "1234", c.UserID)
+		assert.Empty(t, c.Capabilities)
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		token := stub.token(t, jwt.MapClaims{"email": email2, "jti": "idp-token-2"})
+		_, err := s.Logout(tokenContext(t, s, token), &proto.LogoutRequest{})
+		assert.NoError(t, err)
+		_, err = claims(token)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+}
+
+func TestParseJWK(t *testing.T) {
+	stub := newStubIssuer(t)
+	data, err := os.ReadFile(stub.jwks)
+	assert.NoError(t, err)
+	var jwks proto.JWKSResponse
+	assert.NoError(t, protojson.Unmarshal(data, &jwks))
+	key, err := parseJWK(jwks.Keys[0])
+	assert.NoError(t, err)
+	assert.True(t, stub.key.PublicKey.Equal(key.public))
+
+	jwk := jwks.Keys[0]
+	_, err = parseJWK(&proto.JWK{Kty: jwk.Kty, Crv: jwk.Crv, X: jwk.X, Y: jwk.X})
+	assert.EqualError(t, err, "point is not on the curve")
+	_, err = parseJWK(&proto.JWK{Kty: "oct", Kid: "secret"})
+	assert.EqualError(t, err, `unsupported key type "oct"`)
+	_, err = newIssuer(IssuerConfig{}, nil)
+	assert.EqualError(t, err, "no signing key")
+}
@@ -0,0 +1,404 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultMaxLegs       = 3
+	defaultMinConnection = 15
+	maxJourneys          = 20
+)
+
+// leg is the part of one train a journey travels on. Undated trains have
+// neither a boarding nor an arrival time.
+type leg struct {
+	trip      Trip
+	segment   Segment
+	boardsAt  int64
+	arrivesAt int64
+	available int32
+}
+
+// SearchJourneys treats the routes as a graph of stations and lists the ways
+// to travel from one station to another, directly or by changing trains.
+// Timetabled connections leave at least the minimum connection time after the
+// previous train arrives. Every train is priced at its cheapest fare.
+func (s *TrainServer) SearchJourneys(ctx context.Context, req *proto.SearchJourneysRequest) (*proto.SearchJourneysResponse, error) {
+	if req.From == "" || req.To == "" || req.From == req.To {
+		return nil, status.Errorf(codes.InvalidArgument, "from and to must be two different stations")
+	}
+	if req.Currency != "" && !isValidCurrency(req.Currency) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid currency: %v", req.Currency)
+	}
+	if !isValidPassengerType(req.PassengerType) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid passenger type: %v", req.PassengerType)
+	}
+	maxLegs := s.maxLegs()
+	if req.MaxLegs > 0 && int(req.MaxLegs) < maxLegs {
+		maxLegs = int(req.MaxLegs)
+	}
+	now := time.Now()
+	after := now.Unix()
+	if req.DepartsAfter > after {
+		after = req.DepartsAfter
+	}
+	type found struct {
+		journey   *proto.Journey
+		departsAt int64
+	}
+	var results []found
+	for _, legs := range s.findJourneys(req.From, req.To, after, maxLegs, now) {
+		journey, err := s.journeyResponse(ctx, legs, req.Currency, req.PassengerType, now)
+		if err != nil {
+			// There is no rate to price this journey in the currency asked for.
+			continue
+		}
+		results = append(results, found{journey: journey, departsAt: departure(legs)})
+	}
+	slices.SortStableFunc(results, func(a, b found) int {
+		if a.departsAt != b.departsAt {
+			return cmp.Compare(a.departsAt, b.departsAt)
+		}
+		if a.journey.Price != b.journey.Price {
+			return cmp.Compare(a.journey.Price, b.journey.Price)
+		}
+		return cmp.Compare(len(a.journey.Legs), len(b.journey.Legs))
+	})
+	resp := &proto.SearchJourneysResponse{Journeys: make([]*proto.Journey, 0, min(len(results), maxJourneys))}
+	for _, r := range results[:min(len(results), maxJourneys)] {
+		resp.Journeys = append(resp.Journeys, r.journey)
+	}
+	s.logger.Info("SearchJourneys", resp)
+	return resp, nil
+}
+
+// PurchaseJourney books a seat on every leg of a journey, the cheapest one
+// left on each train. Either every leg gets a seat or none does, and the
+// journey is paid with a single payment whose change is recorded on the
+// booking of the first leg.
+func (s *TrainServer) PurchaseJourney(ctx context.Context, req *proto.PurchaseJourneyRequest) (*proto.PurchaseJourneyResponse, error) {
+	if _, err := isValidUser(req.User); err != nil {
+		return nil, err
+	}
+	if err := checkTravellingAlone(req.User); err != nil {
+		return nil, err
+	}
+	legs, err := s.checkJourney(req.Legs)
+	if err != nil {
+		return nil, err
+	}
+	currency := req.Currency
+	if currency == "" {
+		currency = s.routeCurrency(legs[0].trip.Route)
+	}
+	now := time.Now()
+	seats := make([]seatRequest, len(legs))
+	var least int32
+	for i, l := range legs {
+		if s.isAlreadyPurchased(req.User.Email, l.trip) {
+			return nil, errAlreadyPurchased
+		}
+		seats[i].segment = l.segment
+		if seats[i].exchange, err = s.exchangeFor(ctx, l.trip.Route, currency); err != nil {
+			return nil, err
+		}
+		seats[i].fares, seats[i].charges = s.addCharges(l.trip.Route, s.concessionFares(s.fares(l.trip, l.segment, now), req.User.PassengerType))
+		least += seats[i].exchange.toPaid(cheapest(seats[i].fares))
+	}
+	if least > req.Price {
+		return nil, errNotEnoughMoney
+	}
+	bookings := make([]Booking, len(legs))
+	for i, l := range legs {
+		bookings[i] = s.newBooking(newBookingID(), l.trip, req.User)
+		bookings[i].Segment = l.segment
+	}
+	total, err := s.purchaseJourneySeats(ctx, bookings, seats, req.Price, currency)
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.PurchaseJourneyResponse{
+		Tickets:       make([]*proto.PurchaseResponse, 0, len(bookings)),
+		AmountCharged: total,
+		Change:        bookings[0].Change,
+		Currency:      currency,
+		Message:       "Journey purchased successfully",
+	}
+	for _, booking := range bookings {
+		resp.Tickets = append(resp.Tickets, s.purchaseResponse(booking))
+	}
+	s.logger.Info("PurchaseJourney", resp)
+	return resp, nil
+}
+
+// checkJourney resolves the legs of a journey into trip segments, making sure
+// every leg starts where the previous one ended, in time for the connection,
+// and that no station is changed at twice.
+func (s *TrainServer) checkJourney(legs []*proto.LegRequest) ([]leg, error) {
+	if len(legs) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "journey must have at least one leg")
+	}
+	if len(legs) > s.maxLegs() {
+		return nil, status.Errorf(codes.InvalidArgument, "journey must not have more than %d legs", s.maxLegs())
+	}
+	resolved := make([]leg, 0, len(legs))
+	visited := map[string]bool{legs[0].From: true}
+	var earliest int64
+	for i, l := range legs {
+		if i > 0 && l.From != legs[i-1].To {
+			return nil, status.Errorf(codes.InvalidArgument, "leg %d must start at %v", i+1, legs[i-1].To)
+		}
+		if visited[l.To] {
+			return nil, status.Errorf(codes.InvalidArgument, "journey must not pass %v twice", l.To)
+		}
+		visited[l.To] = true
+		index, seg, err := s.legRoute(l)
+		if err != nil {
+			return nil, fmt.Errorf("leg %d: %w", i+1, err)
+		}
+		next := s.newLeg(Trip{Route: int32(index), DepartsAt: l.DepartsAt}, seg)
+		if next.boardsAt != 0 && next.boardsAt < earliest {
+			return nil, status.Errorf(codes.InvalidArgument, "leg %d departs before the connection can be made", i+1)
+		}
+		earliest = s.nextConnection(next, earliest)
+		resolved = append(resolved, next)
+	}
+	return resolved, nil
+}
+
+// legRoute finds the route of l and the segment it travels, on the route l
+// names when set.
+func (s *TrainServer) legRoute(l *proto.LegRequest) (int, Segment, error) {
+	if l.Route == nil {
+		return s.getRouteIndex(l.From, l.To, l.DepartsAt)
+	}
+	index := int(*l.Route)
+	if index < 0 || index >= len(s.Conf.Routes) {
+		return -1, Segment{}, status.Errorf(codes.InvalidArgument, "invalid route: %d", index)
+	}
+	seg, ok := s.Conf.Routes[index].Segment(l.From, l.To)
+	if !ok {
+		return -1, Segment{}, status.Errorf(codes.InvalidArgument, "route %d does not call at %v and then %v", index, l.From, l.To)
+	}
+	if err := s.checkDeparture(index, l.DepartsAt); err != nil {
+		return -1, Segment{}, err
+	}
+	return index, seg, nil
+}
+
+// purchaseJourneySeats books the cheapest seat free over every leg, each priced
+// as in seats, and charges the total in currency with a single payment. Like
+// purchaseGroupSeats, it looks again whenever another request took one of the
+// chosen seats first, up to maxSeatAttempts times.
+func (s *TrainServer) purchaseJourneySeats(ctx context.Context, bookings []Booking, seats []seatRequest, paid int32, currency string) (int32, error) {
+	for attempt := 1; attempt <= maxSeatAttempts; attempt++ {
+		var total int32
+		for i := range bookings {
+			r := seats[i]
+			sec, seat, err := s.findCheapestSeat(bookings[i].Trip, r.segment, r.fares)
+			if err != nil {
+				return 0, fmt.Errorf("leg %d: %w", i+1, err)
+			}
+			charges := r.charges[sec]
+			bookings[i].Section, bookings[i].Seat = sec, seat
+			bookings[i].Price, bookings[i].Charges = r.fares[sec], &charges
+			if r.exchange.rate != nil {
+				bookings[i].Currency, bookings[i].Paid = r.exchange.currency, r.exchange.toPaid(bookings[i].Price)
+			}
+			total += s.bookingPaid(bookings[i])
+		}
+		if total > paid {
+			return 0, errNotEnoughMoney
+		}
+		bookings[0].Change = paid - total
+		err := s.reserveAll(ctx, fmt.Sprintf("%s/%d", bookings[0].ID, attempt), bookings, total, currency)
+		if errors.Is(err, errSeatOccupied) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return total, nil
+	}
+	return 0, errSeatContention
+}
+
+// findCheapestSeat picks a seat of trip free over seg in the cheapest section
+// which still has one.
+func (s *TrainServer) findCheapestSeat(trip Trip, seg Segment, fares map[string]int32) (string, int32, error) {
+	sections := slices.Clone(s.Conf.SectionNames())
+	slices.SortStableFunc(sections, func(a, b string) int {
+		return cmp.Compare(fares[a], fares[b])
+	})
+	for _, sec := range sections {
+		if sec, seat, err := s.findEmptySeat(trip, seatRequest{segment: seg, section: sec}); err == nil {
+			return sec, seat, nil
+		}
+	}
+	return "", -1, fmt.Errorf("cannot find empty seat")
+}
+
+// findJourneys walks the routes from one station to another, at most maxLegs
+// trains long and never calling at a station twice. A train can be taken
+// between any two of its stops. Every departure of the first train starts a
+// journey, which then takes the first connection of every later train. Sold
+// out trains are skipped.
+func (s *TrainServer) findJourneys(from, to string, after int64, maxLegs int, now time.Time) [][]leg {
+	var journeys [][]leg
+	var path []leg
+	visited := map[string]bool{from: true}
+	var walk func(station string, earliest int64)
+	walk = func(station string, earliest int64) {
+		if station == to {
+			journeys = append(journeys, slices.Clone(path))
+			return
+		}
+		if len(path) == maxLegs {
+			return
+		}
+		for i, route := range s.Conf.Routes {
+			stations := route.Stations()
+			board := slices.Index(stations, station)
+			if board < 0 {
+				continue
+			}
+			// Stations are marked as the train calls at them, and unmarked
+			// once every segment of this train has been tried.
+			alight := board + 1
+			for ; alight < len(stations) && !visited[stations[alight]]; alight++ {
+				visited[stations[alight]] = true
+				seg, _ := route.Segment(station, stations[alight])
+				for _, l := range s.connections(int32(i), seg, earliest, len(path) == 0, now) {
+					path = append(path, l)
+					walk(stations[alight], s.nextConnection(l, earliest))
+					path = path[:len(path)-1]
+				}
+			}
+			for _, name := range stations[board+1 : alight] {
+				visited[name] = false
+			}
+		}
+	}
+	walk(from, after)
+	return journeys
+}
+
+// connections lists the trains of route calling at the start of seg at or
+// after earliest which have seats left over seg: all of them for the first
+// leg of a journey, otherwise only the first one.
+func (s *TrainServer) connections(route int32, seg Segment, earliest int64, first bool, now time.Time) []leg {
+	var legs []leg
+	if len(s.Conf.Routes[route].Departures) == 0 {
+		l := s.newLeg(Trip{Route: route}, seg)
+		if l.available > 0 {
+			legs = append(legs, l)
+		}
+		return legs
+	}
+	for _, at := range s.upcomingDepartures(s.Conf.Routes[route], now) {
+		l := s.newLeg(Trip{Route: route, DepartsAt: at.Unix()}, seg)
+		if l.boardsAt < earliest || l.available == 0 {
+			continue
+		}
+		legs = append(legs, l)
+		if !first {
+			break
+		}
+	}
+	return legs
+}
+
+func (s *TrainServer) newLeg(trip Trip, seg Segment) leg {
+	legs, _ := s.Conf.Routes[trip.Route].Legs(seg)
+	return leg{
+		trip:      trip,
+		segment:   seg,
+		boardsAt:  s.stopTime(trip, seg.Board),
+		arrivesAt: s.stopTime(trip, seg.Board+int32(legs)),
+		available: s.availableSeats(trip, seg),
+	}
+}
+
+// stopTime returns when trip calls at the stop with the given index, with
+// the duration of the route spread evenly over its stops, or 0 for undated
+// trips.
+func (s *TrainServer) stopTime(trip Trip, stop int32) int64 {
+	if trip.DepartsAt == 0 {
+		return 0
+	}
+	route := s.Conf.Routes[trip.Route]
+	return trip.DepartsAt + int64(route.Duration)*60*int64(stop)/int64(len(route.Stops)+1)
+}
+
+// nextConnection returns the earliest time a train connecting to l may leave.
+// Undated trains do not constrain the connection.
+func (s *TrainServer) nextConnection(l leg, earliest int64) int64 {
+	if l.trip.DepartsAt == 0 {
+		return earliest
+	}
+	return l.arrivesAt + int64(s.minConnection())*60
+}
+
+func (s *TrainServer) journeyResponse(ctx context.Context, legs []leg, currency string, t proto.PassengerType, now time.Time) (*proto.Journey, error) {
+	if currency == "" {
+		currency = s.routeCurrency(legs[0].trip.Route)
+	}
+	journey := &proto.Journey{Legs: make([]*proto.JourneyLeg, 0, len(legs)), Currency: currency}
+	for _, l := range legs {
+		e, err := s.exchangeFor(ctx, l.trip.Route, currency)
+		if err != nil {
+			return nil, err
+		}
+		totals, _ := s.addCharges(l.trip.Route, s.concessionFares(s.fares(l.trip, l.segment, now), t))
+		price := cheapest(totals)
+		journey.Price += e.toPaid(price)
+		route := s.Conf.Routes[l.trip.Route]
+		journey.Legs = append(journey.Legs, &proto.JourneyLeg{
+			Route:          l.trip.Route,
+			From:           route.Boarding(l.segment),
+			To:             route.Alighting(l.segment),
+			DepartsAt:      l.trip.DepartsAt,
+			BoardsAt:       l.boardsAt,
+			ArrivesAt:      l.arrivesAt,
+			Price:          price,
+			Currency:       s.routeCurrency(l.trip.Route),
+			AvailableSeats: l.available,
+		})
+	}
+	return journey, nil
+}
+
+// departure returns when the first timetabled train of a journey is boarded,
+// 0 if none is.
+func departure(legs []leg) int64 {
+	for _, l := range legs {
+		if l.boardsAt != 0 {
+			return l.boardsAt
+		}
+	}
+	return 0
+}
+
+func (s *TrainServer) maxLegs() int {
+	if s.Conf.Journeys.MaxLegs <= 0 {
+		return defaultMaxLegs
+	}
+	return s.Conf.Journeys.MaxLegs
+}
+
+func (s *TrainServer) minConnection() int {
+	if s.Conf.Journeys.MinConnection <= 0 {
+		return defaultMinConnection
+	}
+	return s.Conf.Journeys.MinConnection
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// journeyConfig is a network where Leeds reaches Hull directly or via York,
+// and via Selby on timetabled trains.
+func journeyConfig(conf *TrainConfig) {
+	conf.SeatCount = 2
+	conf.ScheduleDays = 3
+	conf.FX = FXConfig{Rates: map[string]string{"GBP/EUR": "1.5"}}
+	conf.Routes = []RouteConfig{
+		{From: "Leeds", To: "York", Price: 1000, Currency: "GBP"},
+		{From: "York", To: "Hull", Price: 2000, Currency: "EUR"},
+		{From: "Leeds", To: "Hull", Price: 5000, Currency: "GBP"},
+		{From: "Leeds", To: "Selby", Price: 700, Currency: "GBP", Departures: []string{"08:00"}, Duration: 60},
+		{From: "Selby", To: "Hull", Price: 800, Currency: "GBP", Departures: []string{"08:30", "10:00", "12:00"}, Duration: 60},
+	}
+}
+
+func TestTrainServer_findJourneys(t *testing.T) {
+	s := newTestServer(t, journeyConfig)
+	now := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	at := func(day, hour, minute int) int64 {
+		return time.Date(2026, 10, 17+day, hour, minute, 0, 0, time.UTC).Unix()
+	}
+	trips := func(legs []leg) []Trip {
+		result := make([]Trip, len(legs))
+		for i, l := range legs {
+			result[i] = l.trip
+		}
+		return result
+	}
+
+	journeys := s.findJourneys("Leeds", "Hull", now.Unix(), 3, now)
+	var found [][]Trip
+	for _, legs := range journeys {
+		found = append(found, trips(legs))
+	}
+	// The 08:00 to Selby arrives at 09:00, too late for the 08:30 to Hull.
+	assert.ElementsMatch(t, [][]Trip{
+		{{Route: 0}, {Route: 1}},
+		{{Route: 2}},
+		{{Route: 3, DepartsAt: at(0, 8, 0)}, {Route: 4, DepartsAt: at(0, 10, 0)}},
+		{{Route: 3, DepartsAt: at(1, 8, 0)}, {Route: 4, DepartsAt: at(1, 10, 0)}},
+		{{Route: 3, DepartsAt: at(2, 8, 0)}, {Route: 4, DepartsAt: at(2, 10, 0)}},
+	}, found)
+
+	journeys = s.findJourneys("Leeds", "Hull", at(2, 0, 0), 1, now)
+	assert.Len(t, journeys, 1)
+	assert.Equal(t, []Trip{{Route: 2}}, trips(journeys[0]))
+
+	s.Conf.Journeys.MinConnection = 150
+	for _, legs := range s.findJourneys("Leeds", "Hull", at(2, 0, 0), 3, now) {
+		if legs[0].trip.Route == 3 {
+			assert.Equal(t, at(2, 12, 0), legs[1].trip.DepartsAt)
+			assert.Equal(t, at(2, 13, 0), legs[1].arrivesAt)
+		}
+	}
+	// A train can be left at any of its stops.
+	s.Conf.Routes = []RouteConfig{
+		{From: "Leeds", To: "Hull", Stops: []string{"York"}, Departures: []string{"08:00"}, Duration: 120},
+		{From: "York", To: "Selby", Departures: []string{"08:30", "09:30"}, Duration: 30},
+	}
+	s.Conf.Journeys.MinConnection = 15
+	journeys = s.findJourneys("Leeds", "Selby", at(2, 0, 0), 3, now)
+	assert.Len(t, journeys, 1)
+	assert.Equal(t, []Trip{{Route: 0, DepartsAt: at(2, 8, 0)}, {Route: 1, DepartsAt: at(2, 9, 30)}}, trips(journeys[0]))
+	assert.Equal(t, Segment{Board: 0, Alight: 1}, journeys[0][0].segment)
+	assert.Equal(t, at(2, 9, 0), journeys[0][0].arrivesAt)
+}
+
+func TestTrainServer_SearchJourneys(t *testing.T) {
+	s := newTestServer(t, journeyConfig)
+	s.Conf.Routes = s.Conf.Routes[:3]
+	ctx := context.Background()
+
+	resp, err := s.SearchJourneys(ctx, &proto.SearchJourneysRequest{From: "Leeds", To: "Hull"})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Journeys, 2)
+	// 2000 EUR is 1333 GBP.
+	direct, connecting := resp.Journeys[1], resp.Journeys[0]
+	assert.Equal(t, int32(2333), connecting.Price)
+	assert.Equal(t, "GBP", connecting.Currency)
+	assert.Equal(t, []string{"Leeds", "York"}, []string{connecting.Legs[0].From, connecting.Legs[0].To})
+	assert.Equal(t, "EUR", connecting.Legs[1].Currency)
+	assert.Equal(t, int32(2000), connecting.Legs[1].Price)
+	assert.Equal(t, int32(4), connecting.Legs[1].AvailableSeats)
+	assert.Equal(t, int32(5000), direct.Price)
+
+	resp, err = s.SearchJourneys(ctx, &proto.SearchJourneysRequest{From: "Leeds", To: "Hull", Currency: "EUR"})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3500), resp.Journeys[0].Price)
+	assert.Equal(t, int32(7500), resp.Journeys[1].Price)
+
+	// Journeys which cannot be priced in the currency are left out.
+	resp, err = s.SearchJourneys(ctx, &proto.SearchJourneysRequest{From: "Leeds", To: "Hull", Currency: "USD"})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Journeys)
+
+	_, err = s.SearchJourneys(ctx, &proto.SearchJourneysRequest{From: "Leeds", To: "Leeds"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestTrainServer_PurchaseJourney(t *testing.T) {
+	ctx := context.Background()
+	user := &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1}
+	legs := []*proto.LegRequest{{From: "Leeds", To: "York"}, {From: "York", To: "Hull"}}
+
+	t.Run("books every leg", func(t *testing.T) {
+		s := newTestServer(t, journeyConfig)
+		resp, err := s.PurchaseJourney(ctx, &proto.PurchaseJourneyRequest{User: user, Legs: legs, Price: 2500})
+		assert.NoError(t, err)
+		assert.Len(t, resp.Tickets, 2)
+		assert.Equal(t, int32(2333), resp.AmountCharged)
+		assert.Equal(t, int32(167), resp.Change)
+		assert.Equal(t, "GBP", resp.Currency)
+		first, _ := s.Store.Lookup(resp.Tickets[0].BookingId)
+		second, _ := s.Store.Lookup(resp.Tickets[1].BookingId)
+		assert.Equal(t, first.PaymentID, second.PaymentID)
+		assert.Equal(t, int32(2000), second.Price)
+		assert.Equal(t, &proto.Money{Amount: 1333, Currency: "GBP"}, resp.Tickets[1].Paid)
+	})
+
+	t.Run("all or nothing", func(t *testing.T) {
+		s := newTestServer(t, journeyConfig)
+		for i, sec := range []string{section1, section1, section2, section2} {
+			other := &proto.User{FirstName: firstName2, LastName: lastName2, Email: fmt.Sprintf("other%d@example.com", i)}
+			assert.NoError(t, s.Store.Reserve(Booking{ID: newBookingID(), Trip: Trip{Route: 1}, Section: sec, Seat: int32(i % 2), User: other}))
+		}
+		_, err := s.PurchaseJourney(ctx, &proto.PurchaseJourneyRequest{User: user, Legs: legs, Price: 2500})
+		assert.Error(t, err)
+		assert.Empty(t, s.Store.ListByEmail(email1))
+	})
+
+	t.Run("not enough money", func(t *testing.T) {
+		s := newTestServer(t, journeyConfig)
+		_, err := s.PurchaseJourney(ctx, &proto.PurchaseJourneyRequest{User: user, Legs: legs, Price: 2332})
+		assert.ErrorIs(t, err, errNotEnoughMoney)
+	})
+
+	t.Run("legs must connect", func(t *testing.T) {
+		s := newTestServer(t, journeyConfig)
+		_, err := s.PurchaseJourney(ctx, &proto.PurchaseJourneyRequest{
+			User:  user,
+			Legs:  []*proto.LegRequest{{From: "Leeds", To: "York"}, {From: "Leeds", To: "Hull"}},
+			Price: 10000,
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("leg on a named route", func(t *testing.T) {
+		s := newTestServer(t, func(conf *TrainConfig) {
+			journeyConfig(conf)
+			conf.Routes = append(conf.Routes, RouteConfig{From: "Leeds", To: "York", Price: 1200, Currency: "GBP"})
+		})
+		found, err := s.SearchJourneys(ctx, &proto.SearchJourneysRequest{From: "Leeds", To: "York"})
+		assert.NoError(t, err)
+		assert.Len(t, found.Journeys, 2)
+		// Every journey found can be bought on the route it was found on.
+		for i, j := range found.Journeys {
+			l := j.Legs[0]
+			other := &proto.User{FirstName: firstName2, LastName: lastName2, Email: fmt.Sprintf("other%d@example.com", i)}
+			resp, err := s.PurchaseJourney(ctx, &proto.PurchaseJourneyRequest{
+				User:  other,
+				Legs:  []*proto.LegRequest{{From: l.From, To: l.To, DepartsAt: l.DepartsAt, Route: &l.Route}},
+				Price: j.Price,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, l.Route, resp.Tickets[0].Route)
+			assert.Equal(t, j.Price, resp.AmountCharged)
+		}
+
+		for _, route := range []int32{1, 9} {
+			_, err = s.PurchaseJourney(ctx, &proto.PurchaseJourneyRequest{
+				User:  user,
+				Legs:  []*proto.LegRequest{{From: "Leeds", To: "York", Route: &route}},
+				Price: 2000,
+			})
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		}
+	})
+
+	t.Run("connection time", func(t *testing.T) {
+		s := newTestServer(t, journeyConfig)
+		departs := s.upcomingDepartures(s.Conf.Routes[3], time.Now())[0]
+		req := &proto.PurchaseJourneyRequest{
+			User: user,
+			Legs: []*proto.LegRequest{
+				{From: "Leeds", To: "Selby", DepartsAt: departs.Unix()},
+				{From: "Selby", To: "Hull", DepartsAt: departs.Add(30 * time.Minute).Unix()},
+			},
+			Price: 1500,
+		}
+		_, err := s.PurchaseJourney(ctx, req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		req.Legs[1].DepartsAt = departs.Add(2 * time.Hour).Unix()
+		resp, err := s.PurchaseJourney(ctx, req)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1500), resp.AmountCharged)
+	})
+}
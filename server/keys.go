@@ -0,0 +1,288 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	proto "github.com/playbody/train-ticket-service/proto"
+)
+
+const minRSABits = 2048
+
+var (
+	// SKeys signs and verifies tokens. It is set up from SConfig.Auth by
+	// InitKeys; without signing keys it falls back to HS256 with the secret
+	// key.
+	SKeys = KeyRing{}
+
+	errUnknownKey = errors.New("unknown signing key")
+	errRetiredKey = errors.New("signing key has been retired")
+)
+
+// signingKey is a private key tokens are signed with from notBefore on.
+type signingKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	private   interface{}
+	public    interface{}
+	notBefore int64
+}
+
+// KeyRing holds the signing keys ordered by when they start signing. The
+// newest key which has started signs; older keys keep verifying for overlap
+// seconds after their successor started, and keys yet to start are already
+// published so verifiers can fetch them ahead of the rotation.
+//
+// Tokens of trusted external issuers are verified with the keys those publish.
+type KeyRing struct {
+	keys      []signingKey
+	overlap   int64
+	issuers   []*trustedIssuer
+	generated []string
+}
+
+// InitKeys loads the private keys of conf and the public keys of its
+// issuers. A private key file which does not exist yet is created with a new
+// key, as `make keys` does, so a fresh checkout starts with the default config.
+func (k *KeyRing) InitKeys(conf AuthConfig) error {
+	issuers := make([]*trustedIssuer, 0, len(conf.Issuers))
+	for _, ic := range conf.Issuers {
+		issuer, err := loadIssuer(ic)
+		if err != nil {
+			return fmt.Errorf("cannot load keys of issuer %s: %v", ic.Issuer, err)
+		}
+		issuers = append(issuers, issuer)
+	}
+	keys := make([]signingKey, 0, len(conf.Signing.Keys))
+	var generated []string
+	for _, kc := range conf.Signing.Keys {
+		created, err := generateSigningKey(kc)
+		if err != nil {
+			return fmt.Errorf("cannot generate signing key %s (or create it with `make keys`): %v", kc.Kid, err)
+		}
+		if created {
+			generated = append(generated, kc.Kid)
+		}
+		key, err := loadSigningKey(kc)
+		if err != nil {
+			return fmt.Errorf("cannot load signing key %s: %v", kc.Kid, err)
+		}
+		keys = append(keys, key)
+	}
+	slices.SortStableFunc(keys, func(a, b signingKey) int {
+		return cmp.Compare(a.notBefore, b.notBefore)
+	})
+	k.keys = keys
+	k.issuers = issuers
+	k.generated = generated
+	k.overlap = conf.Signing.Overlap
+	if k.overlap == 0 {
+		k.overlap = refreshExpire()
+	}
+	return nil
+}
+
+// Generated returns the kids of the signing keys InitKeys had to create.
+func (k *KeyRing) Generated() []string {
+	return k.generated
+}
+
+// generateSigningKey writes a new private key for conf unless its file exists
+// already, and reports whether it did.
+func generateSigningKey(conf SigningKeyConfig) (bool, error) {
+	if _, err := os.Stat(conf.PrivateKey); err == nil {
+		return false, nil
+	}
+	var block *pem.Block
+	switch conf.Algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		private, err := rsa.GenerateKey(rand.Reader, minRSABits)
+		if err != nil {
+			return false, err
+		}
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}
+	case jwt.SigningMethodES256.Alg():
+		private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return false, err
+		}
+		der, err := x509.MarshalECPrivateKey(private)
+		if err != nil {
+			return false, err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	default:
+		return false, fmt.Errorf("unsupported algorithm %q", conf.Algorithm)
+	}
+	if err := os.MkdirAll(filepath.Dir(conf.PrivateKey), 0700); err != nil {
+		return false, err
+	}
+	// Another instance starting at the same time may have won the race, in
+	// which case its key is used.
+	f, err := os.OpenFile(conf.PrivateKey, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := pem.Encode(f, block); err != nil {
+		f.Close()
+		os.Remove(conf.PrivateKey)
+		return false, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(conf.PrivateKey)
+		return false, err
+	}
+	return true, nil
+}
+
+func loadSigningKey(conf SigningKeyConfig) (signingKey, error) {
+	pem, err := os.ReadFile(conf.PrivateKey)
+	if err != nil {
+		return signingKey{}, err
+	}
+	key := signingKey{kid: conf.Kid, method: jwt.GetSigningMethod(conf.Algorithm)}
+	if conf.NotBefore != "" {
+		notBefore, err := time.Parse(time.RFC3339, conf.NotBefore)
+		if err != nil {
+			return signingKey{}, err
+		}
+		key.notBefore = notBefore.Unix()
+	}
+	switch conf.Algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return signingKey{}, err
+		}
+		if private.N.BitLen() < minRSABits {
+			return signingKey{}, fmt.Errorf("RSA key must have at least %d bits", minRSABits)
+		}
+		key.private, key.public = private, &private.PublicKey
+	case jwt.SigningMethodES256.Alg():
+		private, err := jwt.ParseECPrivateKeyFromPEM(pem)
+		if err != nil {
+			return signingKey{}, err
+		}
+		if private.Curve != elliptic.P256() {
+			return signingKey{}, errors.New("ES256 needs a P-256 key")
+		}
+		key.private, key.public = private, &private.PublicKey
+	default:
+		return signingKey{}, fmt.Errorf("unsupported algorithm %q", conf.Algorithm)
+	}
+	return key, nil
+}
+
+// signer returns the key to sign with at now, if any.
+func (k *KeyRing) signer(now int64) (signingKey, bool) {
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		if k.keys[i].notBefore <= now {
+			return k.keys[i], true
+		}
+	}
+	return signingKey{}, false
+}
+
+// retired reports whether the i-th key no longer verifies at now.
+func (k *KeyRing) retired(i int, now int64) bool {
+	for _, next := range k.keys[i+1:] {
+		if next.notBefore+k.overlap < now {
+			return true
+		}
+	}
+	return false
+}
+
+// sign returns the signed token of claims.
+func (k *KeyRing) sign(claims JwtClaims, now int64) (string, error) {
+	if len(k.keys) == 0 {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString([]byte(SConfig.Auth.SecretKey))
+	}
+	key, ok := k.signer(now)
+	if !ok {
+		return "", errors.New("no signing key is valid yet")
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// verificationKey returns the key token must be signed with at now. The
+// algorithm of the token has to be the one of its key.
+func (k *KeyRing) verificationKey(token *jwt.Token, now int64) (interface{}, error) {
+	if len(k.keys) == 0 {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(SConfig.Auth.SecretKey), nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	for i, key := range k.keys {
+		if key.kid != kid {
+			continue
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		if k.retired(i, now) {
+			return nil, errRetiredKey
+		}
+		return key.public, nil
+	}
+	return nil, errUnknownKey
+}
+
+// jwks returns the public keys which verify tokens at now, and those which
+// will sign later.
+func (k *KeyRing) jwks(now int64) []*proto.JWK {
+	keys := make([]*proto.JWK, 0, len(k.keys))
+	for i, key := range k.keys {
+		if k.retired(i, now) {
+			continue
+		}
+		jwk := &proto.JWK{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encodeBase64URL(public.N.Bytes())
+			jwk.E = encodeBase64URL(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = public.Curve.Params().Name
+			jwk.X = encodeBase64URL(public.X.FillBytes(make([]byte, size)))
+			jwk.Y = encodeBase64URL(public.Y.FillBytes(make([]byte, size)))
+		}
+		keys = append(keys, jwk)
+	}
+	return keys
+}
+
+func encodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// GetJWKS publishes the public keys tokens are signed with, so other services
+// can verify them. It is empty while tokens are signed with the secret key.
+func (s *TrainServer) GetJWKS(_ context.Context, _ *proto.JWKSRequest) (*proto.JWKSResponse, error) {
+	return &proto.JWKSResponse{Keys: SKeys.jwks(time.Now().Unix())}, nil
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writeKey writes a new private key for algorithm to a PEM file in dir.
+func writeKey(t *testing.T, dir, kid, algorithm string) string {
+	var block *pem.Block
+	switch algorithm {
+	case "RS256":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.NoError(t, err)
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	case "ES256":
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		der, err := x509.MarshalECPrivateKey(key)
+		assert.NoError(t, err)
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	}
+	path := filepath.Join(dir, kid+".pem")
+	assert.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0600))
+	return path
+}
+
+// useKeys signs tokens with keys until the test ends.
+func useKeys(t *testing.T, signing SigningConfig) {
+	assert.NoError(t, SKeys.InitKeys(AuthConfig{Signing: signing}))
+	t.Cleanup(func() { SKeys = KeyRing{} })
+}
+
+func TestKeyRing_rotation(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	useKeys(t, SigningConfig{
+		Keys: []SigningKeyConfig{
+			{Kid: "next", Algorithm: "RS256", PrivateKey: writeKey(t, dir, "next", "RS256"), NotBefore: now.Add(time.Hour).Format(time.RFC3339)},
+			{Kid: "current", Algorithm: "ES256", PrivateKey: writeKey(t, dir, "current", "ES256"), NotBefore: now.Add(-time.Hour).Format(time.RFC3339)},
+			{Kid: "old", Algorithm: "ES256", PrivateKey: writeKey(t, dir, "old", "ES256")},
+		},
+		Overlap: 1800,
+	})
+	kid := func(token string) string {
+		parsed, _, err := new(jwt.Parser).ParseUnverified(token[len("Bearer "):], &JwtClaims{})
+		assert.NoError(t, err)
+		return parsed.Header["kid"].(string)
+	}
+
+	token, err := GenerateJWT(email1, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "current", kid(token))
+	claims, err := parseJWT(token)
+	assert.NoError(t, err)
+	assert.Equal(t, email1, claims.UserID)
+
+	// The current key took over an hour ago, so the old one has been retired.
+	old, err := SKeys.sign(JwtClaims{StandardClaims: jwt.StandardClaims{Id: "TK-1"}}, now.Add(-2*time.Hour).Unix())
+	assert.NoError(t, err)
+	assert.Equal(t, "old", kid("Bearer "+old))
+	_, err = parseJWT(old)
+	assert.ErrorContains(t, err, errRetiredKey.Error())
+
+	// In two hours the next key signs, and the current one still verifies
+	// for half an hour.
+	later := now.Add(2 * time.Hour).Unix()
+	next, err := SKeys.sign(JwtClaims{}, later)
+	assert.NoError(t, err)
+	assert.Equal(t, "next", kid("Bearer "+next))
+	assert.False(t, SKeys.retired(1, now.Add(80*time.Minute).Unix()))
+	assert.True(t, SKeys.retired(1, later))
+
+	var kids []string
+	for _, jwk := range SKeys.jwks(now.Unix()) {
+		kids = append(kids, jwk.Kid)
+	}
+	assert.Equal(t, []string{"current", "next"}, kids)
+}
+
+func TestKeyRing_verificationKey(t *testing.T) {
+	dir := t.TempDir()
+	useKeys(t, SigningConfig{Keys: []SigningKeyConfig{
+		{Kid: "es", Algorithm: "ES256", PrivateKey: writeKey(t, dir, "es", "ES256")},
+	}})
+	claims := JwtClaims{UserID: email1, TokenType: tokenAccess, StandardClaims: jwt.StandardClaims{
+		Id:        "TK-1",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}}
+
+	// The shared secret no longer signs valid tokens.
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hs.Header["kid"] = "es"
+	token, err := hs.SignedString([]byte(SConfig.Auth.SecretKey))
+	assert.NoError(t, err)
+	_, err = parseJWT(token)
+	assert.ErrorContains(t, err, "unexpected signing method HS256")
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	es := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token, err = es.SignedString(other)
+	assert.NoError(t, err)
+	_, err = parseJWT(token)
+	assert.ErrorContains(t, err, errUnknownKey.Error())
+	es.Header["kid"] = "es"
+	token, err = es.SignedString(other)
+	assert.NoError(t, err)
+	_, err = parseJWT(token)
+	assert.Error(t, err)
+}
+
+func TestKeyRing_InitKeys(t *testing.T) {
+	dir := t.TempDir()
+	var k KeyRing
+	missing := AuthConfig{Signing: SigningConfig{Keys: []SigningKeyConfig{
+		{Kid: "missing", Algorithm: "ES256", PrivateKey: filepath.Join(dir, "keys", "missing.pem")},
+	}}}
+	// A missing key is created on the first start and loaded afterwards.
+	assert.NoError(t, k.InitKeys(missing))
+	assert.Equal(t, []string{"missing"}, k.Generated())
+	public := k.keys[0].public
+	assert.NoError(t, k.InitKeys(missing))
+	assert.Empty(t, k.Generated())
+	assert.Equal(t, public, k.keys[0].public)
+
+	err := k.InitKeys(AuthConfig{Signing: SigningConfig{Keys: []SigningKeyConfig{
+		{Kid: "unwritable", Algorithm: "ES256", PrivateKey: filepath.Join(writeKey(t, dir, "file", "ES256"), "unwritable.pem")},
+	}}})
+	assert.ErrorContains(t, err, "make keys")
+	err = k.InitKeys(AuthConfig{Signing: SigningConfig{Keys: []SigningKeyConfig{
+		{Kid: "rsa", Algorithm: "ES256", PrivateKey: writeKey(t, dir, "rsa", "RS256")},
+	}}})
+	assert.ErrorContains(t, err, "cannot load signing key rsa")
+}
+
+func TestTrainServer_GetJWKS(t *testing.T) {
+	s := newTestServer(t, nil)
+	resp, err := s.GetJWKS(context.Background(), &proto.JWKSRequest{})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Keys)
+
+	dir := t.TempDir()
+	useKeys(t, SigningConfig{Keys: []SigningKeyConfig{
+		{Kid: "es", Algorithm: "ES256", PrivateKey: writeKey(t, dir, "es", "ES256")},
+		{Kid: "rs", Algorithm: "RS256", PrivateKey: writeKey(t, dir, "rs", "RS256"), NotBefore: time.Now().Add(time.Hour).Format(time.RFC3339)},
+	}})
+	resp, err = s.GetJWKS(context.Background(), &proto.JWKSRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Keys, 2)
+	es, rs := resp.Keys[0], resp.Keys[1]
+	assert.Equal(t, []string{"EC", "es", "sig", "ES256", "P-256"}, []string{es.Kty, es.Kid, es.Use, es.Alg, es.Crv})
+	assert.Len(t, es.X, 43)
+	assert.Len(t, es.Y, 43)
+	assert.Equal(t, []string{"RSA", "rs", "RS256", "AQAB"}, []string{rs.Kty, rs.Kid, rs.Alg, rs.E})
+	assert.Len(t, rs.N, 342)
+
+	// Tokens issued on login verify with the published key.
+	login, err := s.Login(context.Background(), &proto.AuthRequest{Email: email1, Password: password1})
+	assert.NoError(t, err)
+	assert.NoError(t, AuthCheck(tokenContext(t, s, login.Token), email1))
+	_, err = s.RefreshToken(context.Background(), &proto.RefreshTokenRequest{RefreshToken: login.RefreshToken})
+	assert.NoError(t, err)
+	SKeys = KeyRing{}
+	assert.Equal(t, codes.Unauthenticated, status.Code(AuthCheck(tokenContext(t, s, login.Token), email1)))
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sync"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultCurrency = "USD"
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// minorUnits lists the ISO 4217 currencies which do not have 2 decimals. All
+// amounts are kept in minor units, e.g. 1250 GBP is £12.50.
+var minorUnits = map[string]int{
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLP": 0, "ISK": 0, "JPY": 0, "KRW": 0, "PYG": 0, "UGX": 0, "VND": 0,
+}
+
+func currencyExponent(currency string) int {
+	if exp, ok := minorUnits[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+func isValidCurrency(currency string) bool {
+	return currencyPattern.MatchString(currency)
+}
+
+// RateProvider supplies exchange rates. TrainServer uses it to charge
+// customers who pay in another currency than the one of their route.
+// Implementations must be safe for concurrent use.
+type RateProvider interface {
+	// Rate returns how many units of to one unit of from buys.
+	Rate(ctx context.Context, from, to string) (*big.Rat, error)
+}
+
+// StaticRates is a RateProvider backed by a fixed table, keyed "GBP/EUR".
+// Rates are decimal strings so they are exact, and the inverse of a listed
+// pair is derived.
+type StaticRates struct {
+	mu    sync.RWMutex
+	rates map[string]string
+}
+
+func NewStaticRates(rates map[string]string) *StaticRates {
+	r := &StaticRates{rates: map[string]string{}}
+	for pair, rate := range rates {
+		r.rates[pair] = rate
+	}
+	return r
+}
+
+// Set changes the rate from one currency to another.
+func (r *StaticRates) Set(from, to, rate string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rates[from+"/"+to] = rate
+}
+
+func (r *StaticRates) Rate(_ context.Context, from, to string) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if rate, ok := r.rates[from+"/"+to]; ok {
+		return parseRate(rate)
+	}
+	if rate, ok := r.rates[to+"/"+from]; ok {
+		inverse, err := parseRate(rate)
+		if err != nil {
+			return nil, err
+		}
+		return inverse.Inv(inverse), nil
+	}
+	return nil, fmt.Errorf("no exchange rate from %v to %v", from, to)
+}
+
+func parseRate(rate string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(rate)
+	if !ok || r.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid exchange rate: %q", rate)
+	}
+	return r, nil
+}
+
+// convert turns amount minor units of from into minor units of to at rate,
+// rounding half up.
+func convert(amount int64, from, to string, rate *big.Rat) int64 {
+	v := new(big.Rat).Mul(big.NewRat(amount, 1), rate)
+	shift := currencyExponent(to) - currencyExponent(from)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(shift))), nil))
+	if shift > 0 {
+		v.Mul(v, scale)
+	} else {
+		v.Quo(v, scale)
+	}
+	// floor(v + 1/2)
+	v.Add(v, big.NewRat(1, 2))
+	q := new(big.Int).Div(v.Num(), v.Denom())
+	return q.Int64()
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// exchange describes how a customer pays for a trip: in currency, at rate
+// units of currency per unit of the route currency.
+type exchange struct {
+	route    string
+	currency string
+	rate     *big.Rat
+}
+
+// toPaid converts an amount of the route currency into the paid currency.
+func (e exchange) toPaid(amount int32) int32 {
+	if e.rate == nil || e.currency == e.route {
+		return amount
+	}
+	return int32(convert(int64(amount), e.route, e.currency, e.rate))
+}
+
+// routeCurrency returns the currency prices of route are given in.
+func (s *TrainServer) routeCurrency(route int32) string {
+	if c := s.Conf.Routes[route].Currency; c != "" {
+		return c
+	}
+	return s.trainCurrency()
+}
+
+// trainCurrency returns the currency of routes which set none.
+func (s *TrainServer) trainCurrency() string {
+	if s.Conf.Currency != "" {
+		return s.Conf.Currency
+	}
+	return defaultCurrency
+}
+
+// exchangeFor looks up the rate for a customer paying for route in currency,
+// which defaults to the route currency.
+func (s *TrainServer) exchangeFor(ctx context.Context, route int32, currency string) (exchange, error) {
+	e := exchange{route: s.routeCurrency(route), currency: currency}
+	if currency == "" || currency == e.route {
+		e.currency = e.route
+		return e, nil
+	}
+	if !isValidCurrency(currency) {
+		return exchange{}, status.Errorf(codes.InvalidArgument, "invalid currency: %v", currency)
+	}
+	rate, err := s.FX.Rate(ctx, e.route, currency)
+	if err != nil {
+		return exchange{}, status.Errorf(codes.FailedPrecondition, "cannot pay in %v: %v", currency, err)
+	}
+	e.rate = rate
+	return e, nil
+}
+
+// affordable keeps the fares, in the route currency, whose price in the paid
+// currency is covered by paid.
+func (e exchange) affordable(fares map[string]int32, paid int32) (map[string]int32, error) {
+	prices := make(map[string]int32, len(fares))
+	for sec, fare := range fares {
+		prices[sec] = e.toPaid(fare)
+	}
+	kept, err := affordable(prices, paid)
+	if err != nil {
+		return nil, err
+	}
+	for sec := range kept {
+		kept[sec] = fares[sec]
+	}
+	return kept, nil
+}
+
+// bookingCurrency returns the currency b was paid in.
+func (s *TrainServer) bookingCurrency(b Booking) string {
+	if b.Currency != "" {
+		return b.Currency
+	}
+	return s.routeCurrency(b.Route)
+}
+
+// bookingPaid is what the customer paid for b, in the currency they paid in.
+func (s *TrainServer) bookingPaid(b Booking) int32 {
+	if b.Currency != "" {
+		return b.Paid
+	}
+	return s.bookingPrice(b)
+}
+
+// paidShare converts amount of the route currency into the currency b was
+// paid in, at the rate of the purchase, so refunds do not depend on today's
+// rate.
+func (s *TrainServer) paidShare(b Booking, amount int32) int32 {
+	price := s.bookingPrice(b)
+	if b.Currency == "" || price == 0 {
+		return amount
+	}
+	return int32(int64(amount) * int64(b.Paid) / int64(price))
+}
+
+func (s *TrainServer) paidMoney(b Booking) *proto.Money {
+	return &proto.Money{Amount: int64(s.bookingPaid(b)), Currency: s.bookingCurrency(b)}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   int64
+		from, to string
+		rate     string
+		want     int64
+	}{
+		{"same exponent", 2000, "GBP", "EUR", "1.17", 2340},
+		{"rounds half up", 1050, "GBP", "EUR", "1.17", 1229},
+		{"to zero decimals", 2000, "USD", "JPY", "151.3", 3026},
+		{"from zero decimals", 3026, "JPY", "USD", "0.0066", 1997},
+		{"to three decimals", 2000, "USD", "KWD", "0.307", 6140},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, err := parseRate(tt.rate)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, convert(tt.amount, tt.from, tt.to, rate))
+		})
+	}
+}
+
+func TestStaticRates(t *testing.T) {
+	rates := NewStaticRates(map[string]string{"GBP/EUR": "1.25", "USD/EUR": "bad"})
+	ctx := context.Background()
+
+	rate, err := rates.Rate(ctx, "GBP", "EUR")
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewRat(5, 4), rate)
+	rate, err = rates.Rate(ctx, "EUR", "GBP")
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewRat(4, 5), rate)
+	rate, err = rates.Rate(ctx, "JPY", "JPY")
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewRat(1, 1), rate)
+
+	_, err = rates.Rate(ctx, "USD", "EUR")
+	assert.Error(t, err)
+	_, err = rates.Rate(ctx, "GBP", "JPY")
+	assert.EqualError(t, err, "no exchange rate from GBP to JPY")
+}
+
+func TestTrainServer_PayInOtherCurrency(t *testing.T) {
+	conf := newTestStoreConfig()
+	conf.Currency = "GBP"
+	rates := NewStaticRates(map[string]string{"GBP/EUR": "1.2"})
+	payments := NewFakeGateway()
+	s := &TrainServer{Conf: conf, FX: rates, Payments: payments}
+	s.InitServer()
+	ctx := context.Background()
+
+	routes, err := s.GetAllRoutes(ctx, &proto.RouteRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "GBP", routes.Routes[0].Currency)
+
+	// The fare of 20 GBP pence is 24 EUR cents.
+	req := holdRequest(email1)
+	req.Currency, req.Price = "EUR", 23
+	_, err = s.PurchaseTicket(ctx, req)
+	assert.ErrorIs(t, err, errNotEnoughMoney)
+	req.Price = 30
+	ticket, err := s.PurchaseTicket(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, price1, ticket.AmountCharged)
+	assert.Equal(t, "GBP", ticket.Currency)
+	assert.Equal(t, &proto.Money{Amount: 24, Currency: "EUR"}, ticket.Paid)
+	assert.Equal(t, int32(6), ticket.Change)
+
+	b, _ := s.Store.Lookup(ticket.BookingId)
+	payment, _ := payments.Payment(b.PaymentID)
+	assert.Equal(t, int32(24), payment.Amount)
+	assert.Equal(t, "EUR", payment.Currency)
+	receipt := s.receipt(b)
+	assert.Equal(t, price1, receipt.Price)
+	assert.Equal(t, "GBP", receipt.Currency)
+	assert.Equal(t, &proto.Money{Amount: 24, Currency: "EUR"}, receipt.Paid)
+
+	// Refunds are paid at the rate of the purchase.
+	rates.Set("GBP", "EUR", "2")
+	removed, err := s.RemoveUser(authContext(t, email1), &proto.RemoveUserRequest{BookingId: ticket.BookingId})
+	assert.NoError(t, err)
+	assert.Equal(t, price1, removed.RefundAmount)
+	payment, _ = payments.Payment(b.PaymentID)
+	assert.Equal(t, int32(24), payment.Refunded)
+
+	req = holdRequest(email2)
+	req.Currency = "JPY"
+	_, err = s.PurchaseTicket(ctx, req)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	req.Currency = "euro"
+	_, err = s.PurchaseTicket(ctx, req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Paying in the route currency needs no rate.
+	req = holdRequest(email2)
+	req.Currency = "GBP"
+	ticket, err = s.PurchaseTicket(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, &proto.Money{Amount: int64(price1), Currency: "GBP"}, ticket.Paid)
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+)
+
+var errChildAlone = errors.New("a child must be booked together with an adult in a group")
+
+// passengerTypeName returns the name of t as used in the concessions config,
+// e.g. "child".
+func passengerTypeName(t proto.PassengerType) string {
+	return strings.ToLower(strings.TrimPrefix(t.String(), "PASSENGER_TYPE_"))
+}
+
+func isValidPassengerType(t proto.PassengerType) bool {
+	_, ok := proto.PassengerType_name[int32(t)]
+	return ok
+}
+
+// concession returns the part of fare a passenger of type t pays. Types
+// without a configured concession pay the full fare.
+func (s *TrainServer) concession(fare int32, t proto.PassengerType) int32 {
+	if p, ok := s.Conf.Concessions[passengerTypeName(t)]; ok {
+		return applyPercents(fare, []int{p})
+	}
+	return fare
+}
+
+// concessionFares returns fares as paid by a passenger of type t.
+func (s *TrainServer) concessionFares(fares map[string]int32, t proto.PassengerType) map[string]int32 {
+	paid := make(map[string]int32, len(fares))
+	for sec, fare := range fares {
+		paid[sec] = s.concession(fare, t)
+	}
+	return paid
+}
+
+// checkTravellingAlone rejects a single booking for a child, who has to be
+// booked in a group with an adult.
+func checkTravellingAlone(user *proto.User) error {
+	if user.PassengerType == proto.PassengerType_PASSENGER_TYPE_CHILD {
+		return errChildAlone
+	}
+	return nil
+}
+
+// checkGroupPassengers makes sure a group with children has at least one
+// passenger who is not a child.
+func checkGroupPassengers(users []*proto.User) error {
+	children := 0
+	for _, user := range users {
+		if user.PassengerType == proto.PassengerType_PASSENGER_TYPE_CHILD {
+			children++
+		}
+	}
+	if children > 0 && children == len(users) {
+		return errChildAlone
+	}
+	return nil
+}
+
+// validateConcessions checks the concessions config refers to known passenger
+// types and percents.
+func validateConcessions(concessions map[string]int) error {
+	known := map[string]bool{}
+	for t := range proto.PassengerType_name {
+		known[passengerTypeName(proto.PassengerType(t))] = true
+	}
+	for name, p := range concessions {
+		if !known[name] {
+			return fmt.Errorf("unknown passenger type: %v", name)
+		}
+		if p < 0 || p > 100 {
+			return fmt.Errorf("invalid concession percent for %v: %d", name, p)
+		}
+	}
+	return nil
+}
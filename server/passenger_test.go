@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func concessionConfig(conf *TrainConfig) {
+	conf.SeatCount = 4
+	conf.Concessions = map[string]int{"child": 50, "senior": 70}
+}
+
+func TestTrainServer_concessions(t *testing.T) {
+	s := newTestServer(t, concessionConfig)
+	ctx := context.Background()
+
+	t.Run("senior fare", func(t *testing.T) {
+		req := holdRequest(email1)
+		req.User.PassengerType = proto.PassengerType_PASSENGER_TYPE_SENIOR
+		req.Price = 14
+		ticket, err := s.PurchaseTicket(ctx, req)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(14), ticket.AmountCharged)
+		b, _ := s.Store.Lookup(ticket.BookingId)
+		assert.Equal(t, proto.PassengerType_PASSENGER_TYPE_SENIOR, s.receipt(b).User.PassengerType)
+	})
+
+	t.Run("student without concession pays full fare", func(t *testing.T) {
+		req := holdRequest(email2)
+		req.User.PassengerType = proto.PassengerType_PASSENGER_TYPE_STUDENT
+		ticket, err := s.PurchaseTicket(ctx, req)
+		assert.NoError(t, err)
+		assert.Equal(t, price1, ticket.AmountCharged)
+	})
+
+	t.Run("child alone", func(t *testing.T) {
+		req := holdRequest(email3)
+		req.User.PassengerType = proto.PassengerType_PASSENGER_TYPE_CHILD
+		_, err := s.PurchaseTicket(ctx, req)
+		assert.ErrorIs(t, err, errChildAlone)
+		_, err = s.HoldSeat(ctx, req)
+		assert.ErrorIs(t, err, errChildAlone)
+	})
+
+	t.Run("invalid type", func(t *testing.T) {
+		req := holdRequest(email3)
+		req.User.PassengerType = proto.PassengerType(42)
+		_, err := s.PurchaseTicket(ctx, req)
+		assert.EqualError(t, err, "invalid passenger type: 42")
+	})
+}
+
+func TestTrainServer_PurchaseGroupConcessions(t *testing.T) {
+	s := newTestServer(t, concessionConfig)
+	ctx := context.Background()
+	users := groupUsers(3)
+	users[1].PassengerType = proto.PassengerType_PASSENGER_TYPE_CHILD
+	users[2].PassengerType = proto.PassengerType_PASSENGER_TYPE_CHILD
+
+	_, err := s.PurchaseGroup(ctx, &proto.PurchaseGroupRequest{Users: users[1:], From: from1, To: to1, Price: 100})
+	assert.ErrorIs(t, err, errChildAlone)
+	_, err = s.PurchaseGroup(ctx, &proto.PurchaseGroupRequest{Users: users, From: from1, To: to1, Price: 39})
+	assert.ErrorIs(t, err, errNotEnoughMoney)
+
+	resp, err := s.PurchaseGroup(ctx, &proto.PurchaseGroupRequest{Users: users, From: from1, To: to1, Price: 45})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(40), resp.AmountCharged)
+	assert.Equal(t, int32(5), resp.Change)
+	assert.Equal(t, price1, resp.Tickets[0].AmountCharged)
+	assert.Equal(t, int32(10), resp.Tickets[1].AmountCharged)
+	assert.Equal(t, int32(10), resp.Tickets[2].AmountCharged)
+}
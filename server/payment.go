@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type PaymentStatus string
+
+const (
+	PaymentAuthorized PaymentStatus = "authorized"
+	PaymentCaptured   PaymentStatus = "captured"
+	PaymentVoided     PaymentStatus = "voided"
+)
+
+// maxSeatAttempts bounds how often a purchase looks for other seats after
+// another request took the ones it chose first.
+const maxSeatAttempts = 10
+
+var (
+	errPaymentFailed   = errors.New("payment failed")
+	errPaymentNotFound = errors.New("payment not found")
+	errSeatContention  = status.Error(codes.Aborted, "seats keep being taken by other requests, try again")
+)
+
+// PaymentRequest asks for Amount minor units of Currency to be reserved on
+// the account of Email.
+type PaymentRequest struct {
+	IdempotencyKey string
+	Email          string
+	Amount         int32
+	Currency       string
+}
+
+// Payment is the state of a payment at the provider.
+type Payment struct {
+	ID       string
+	Amount   int32
+	Currency string
+	Refunded int32
+	Status   PaymentStatus
+}
+
+// PaymentProcessor charges customers. TrainServer authorizes the fare before
+// booking a seat and captures it once the seat is secured, so a failed
+// booking never costs the customer anything. Implementations must be safe for
+// concurrent use.
+type PaymentProcessor interface {
+	// Authorize reserves req.Amount. Calls with an idempotency key seen
+	// before return the first payment instead of reserving again.
+	Authorize(ctx context.Context, req PaymentRequest) (Payment, error)
+	// Capture collects an authorized payment. Capturing twice is a no-op.
+	Capture(ctx context.Context, paymentID string) (Payment, error)
+	// Refund pays amount, in the currency of the payment, of a captured
+	// payment back, or voids an authorization which was never captured. Calls with an idempotency key seen before
+	// are not applied again.
+	Refund(ctx context.Context, paymentID string, amount int32, idempotencyKey string) (Payment, error)
+}
+
+// FakeGateway is an in-process PaymentProcessor which accepts every payment
+// unless Decline says otherwise. Payment ids are sequential, so tests can rely
+// on them.
+type FakeGateway struct {
+	// Decline, when set, rejects the authorizations it returns an error for.
+	Decline func(PaymentRequest) error
+
+	mu       sync.Mutex
+	seq      int
+	payments map[string]*Payment
+	keys     map[string]string // authorization key -> payment id
+	refunds  map[string]bool   // refund keys already applied
+}
+
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{
+		payments: map[string]*Payment{},
+		keys:     map[string]string{},
+		refunds:  map[string]bool{},
+	}
+}
+
+func (g *FakeGateway) Authorize(_ context.Context, req PaymentRequest) (Payment, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if id, ok := g.keys[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		return *g.payments[id], nil
+	}
+	if req.Amount < 0 {
+		return Payment{}, fmt.Errorf("invalid amount: %d", req.Amount)
+	}
+	if g.Decline != nil {
+		if err := g.Decline(req); err != nil {
+			return Payment{}, err
+		}
+	}
+	g.seq++
+	p := &Payment{ID: fmt.Sprintf("PAY-%06d", g.seq), Amount: req.Amount, Currency: req.Currency, Status: PaymentAuthorized}
+	g.payments[p.ID] = p
+	if req.IdempotencyKey != "" {
+		g.keys[req.IdempotencyKey] = p.ID
+	}
+	return *p, nil
+}
+
+func (g *FakeGateway) Capture(_ context.Context, paymentID string) (Payment, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p, ok := g.payments[paymentID]
+	if !ok {
+		return Payment{}, errPaymentNotFound
+	}
+	switch p.Status {
+	case PaymentAuthorized:
+		p.Status = PaymentCaptured
+	case PaymentVoided:
+		return Payment{}, fmt.Errorf("payment %v was voided", paymentID)
+	}
+	return *p, nil
+}
+
+func (g *FakeGateway) Refund(_ context.Context, paymentID string, amount int32, idempotencyKey string) (Payment, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p, ok := g.payments[paymentID]
+	if !ok {
+		return Payment{}, errPaymentNotFound
+	}
+	if g.refunds[idempotencyKey] && idempotencyKey != "" {
+		return *p, nil
+	}
+	switch p.Status {
+	case PaymentAuthorized:
+		p.Status = PaymentVoided
+	case PaymentCaptured:
+		if amount < 0 || p.Refunded+amount > p.Amount {
+			return Payment{}, fmt.Errorf("cannot refund %d of payment %v", amount, paymentID)
+		}
+		p.Refunded += amount
+	}
+	if idempotencyKey != "" {
+		g.refunds[idempotencyKey] = true
+	}
+	return *p, nil
+}
+
+// Payment returns the current state of payment id.
+func (g *FakeGateway) Payment(id string) (Payment, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p, ok := g.payments[id]
+	if !ok {
+		return Payment{}, false
+	}
+	return *p, true
+}
+
+// purchase books a free seat matching r for booking, which carries everything
+// but the segment and seat and, when r has fares, the price. The fare is authorized before
+// the seat is taken and captured once it is secured, unless the booking is a
+// hold. Another request may take the seat in between, in which case the
+// authorization is voided and we look again: the store only lets one of them
+// win. After maxSeatAttempts lost seats it gives up with errSeatContention.
+// key identifies the purchase at the payment provider; callers pass the new
+// booking id, so it guards our own calls, not a client repeating the request.
+func (s *TrainServer) purchase(ctx context.Context, key string, booking Booking, r seatRequest) (Booking, error) {
+	booking.Segment = r.segment
+	for attempt := 1; attempt <= maxSeatAttempts; attempt++ {
+		sec, seat, err := s.findEmptySeat(booking.Trip, r)
+		if err != nil {
+			return Booking{}, err
+		}
+		booking.Section, booking.Seat = sec, seat
+		if r.fares != nil {
+			booking.Price = r.fares[sec]
+			booking.Voucher, booking.Discount = r.voucher, r.discounts[sec]
+			if c, ok := r.charges[sec]; ok {
+				booking.Charges = &c
+			}
+			if r.exchange.rate != nil {
+				booking.Currency, booking.Paid = r.exchange.currency, r.exchange.toPaid(booking.Price)
+			}
+			booking.Change = r.paid - s.bookingPaid(booking)
+		}
+		payment, err := s.Payments.Authorize(ctx, PaymentRequest{
+			IdempotencyKey: fmt.Sprintf("%s/%d", key, attempt),
+			Email:          booking.User.Email,
+			Amount:         s.bookingPaid(booking),
+			Currency:       s.bookingCurrency(booking),
+		})
+		if err != nil {
+			return Booking{}, fmt.Errorf("%w: %v", errPaymentFailed, err)
+		}
+		booking.PaymentID = payment.ID
+		err = s.Store.Reserve(booking)
+		if err != nil {
+			s.voidPayment(payment.ID)
+			if errors.Is(err, errSeatOccupied) {
+				continue
+			}
+			return Booking{}, err
+		}
+		if booking.HeldUntil != 0 {
+			return booking, nil
+		}
+		if _, err := s.Payments.Capture(ctx, payment.ID); err != nil {
+			_, _ = s.Store.Release(booking.ID)
+			s.voidPayment(payment.ID)
+			return Booking{}, fmt.Errorf("%w: %v", errPaymentFailed, err)
+		}
+		return booking, nil
+	}
+	return Booking{}, errSeatContention
+}
+
+// voidPayment gives back an authorization which will not be captured. A
+// payment which was captured after all, e.g. by a capture reported as failed,
+// is refunded in full instead.
+func (s *TrainServer) voidPayment(paymentID string) {
+	if paymentID == "" {
+		return
+	}
+	p, err := s.Payments.Refund(context.Background(), paymentID, 0, "void-"+paymentID)
+	if err == nil && p.Status == PaymentCaptured && p.Refunded < p.Amount {
+		_, err = s.Payments.Refund(context.Background(), paymentID, p.Amount-p.Refunded, "void-refund-"+paymentID)
+	}
+	if err != nil {
+		s.logger.Error(err, "VoidPayment", "payment", paymentID)
+	}
+}
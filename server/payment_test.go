@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFakeGateway(t *testing.T) {
+	g := NewFakeGateway()
+	ctx := context.Background()
+
+	p, err := g.Authorize(ctx, PaymentRequest{IdempotencyKey: "k1", Email: email1, Amount: 30})
+	assert.NoError(t, err)
+	assert.Equal(t, "PAY-000001", p.ID)
+	again, err := g.Authorize(ctx, PaymentRequest{IdempotencyKey: "k1", Email: email1, Amount: 30})
+	assert.NoError(t, err)
+	assert.Equal(t, p.ID, again.ID)
+
+	p, err = g.Capture(ctx, p.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, PaymentCaptured, p.Status)
+
+	p, err = g.Refund(ctx, p.ID, 10, "r1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(10), p.Refunded)
+	p, err = g.Refund(ctx, p.ID, 10, "r1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(10), p.Refunded)
+	_, err = g.Refund(ctx, p.ID, 25, "r2")
+	assert.Error(t, err)
+
+	voided, err := g.Authorize(ctx, PaymentRequest{IdempotencyKey: "k2", Email: email1, Amount: 30})
+	assert.NoError(t, err)
+	voided, err = g.Refund(ctx, voided.ID, 0, "")
+	assert.NoError(t, err)
+	assert.Equal(t, PaymentVoided, voided.Status)
+	_, err = g.Capture(ctx, voided.ID)
+	assert.Error(t, err)
+}
+
+func TestTrainServer_PurchasePayment(t *testing.T) {
+	gateway := NewFakeGateway()
+	s := &TrainServer{Conf: newTestStoreConfig(), Payments: gateway}
+	s.InitServer()
+	ctx := context.Background()
+
+	req := holdRequest(email1)
+	req.Price = price1 + 5
+	ticket, err := s.PurchaseTicket(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, price1, ticket.AmountCharged)
+	assert.Equal(t, int32(5), ticket.Change)
+	b, _ := s.Store.Lookup(ticket.BookingId)
+	p, ok := gateway.Payment(b.PaymentID)
+	assert.True(t, ok)
+	assert.Equal(t, PaymentCaptured, p.Status)
+	assert.Equal(t, price1, p.Amount)
+
+	receipt, err := s.GetReceipt(authContext(t, email1), &proto.ReceiptRequest{Email: email1, BookingId: ticket.BookingId})
+	assert.NoError(t, err)
+	assert.Equal(t, price1, receipt.AmountCharged)
+	assert.Equal(t, int32(5), receipt.Change)
+
+	_, err = s.RemoveUser(authContext(t, email1), &proto.RemoveUserRequest{Email: email1, BookingId: ticket.BookingId})
+	assert.NoError(t, err)
+	p, _ = gateway.Payment(b.PaymentID)
+	assert.Equal(t, price1, p.Refunded)
+	// Retrying the refund of the cancelled booking pays nothing more.
+	p, err = gateway.Refund(ctx, b.PaymentID, price1, refundKey(ticket.BookingId))
+	assert.NoError(t, err)
+	assert.Equal(t, price1, p.Refunded)
+
+	gateway.Decline = func(req PaymentRequest) error {
+		return fmt.Errorf("card declined")
+	}
+	_, err = s.PurchaseTicket(ctx, holdRequest(email2))
+	assert.ErrorIs(t, err, errPaymentFailed)
+	assert.Empty(t, s.Store.ListByEmail(email2))
+}
+
+func TestTrainServer_HoldPayment(t *testing.T) {
+	gateway := NewFakeGateway()
+	s := &TrainServer{Conf: newTestStoreConfig(), Payments: gateway}
+	s.InitServer()
+	ctx := context.Background()
+
+	hold, err := s.HoldSeat(ctx, holdRequest(email1))
+	assert.NoError(t, err)
+	assert.Equal(t, price1, hold.AmountAuthorized)
+	b, _ := s.Store.Lookup(hold.HoldId)
+	p, _ := gateway.Payment(b.PaymentID)
+	assert.Equal(t, PaymentAuthorized, p.Status)
+	_, err = s.ConfirmHold(ctx, &proto.ConfirmHoldRequest{HoldId: hold.HoldId})
+	assert.NoError(t, err)
+	p, _ = gateway.Payment(b.PaymentID)
+	assert.Equal(t, PaymentCaptured, p.Status)
+
+	hold, err = s.HoldSeat(ctx, holdRequest(email2))
+	assert.NoError(t, err)
+	b, _ = s.Store.Lookup(hold.HoldId)
+	_, err = s.ReleaseHold(ctx, &proto.ReleaseHoldRequest{HoldId: hold.HoldId})
+	assert.NoError(t, err)
+	p, _ = gateway.Payment(b.PaymentID)
+	assert.Equal(t, PaymentVoided, p.Status)
+}
+
+// failingCapture is a gateway whose captures report an error, after going
+// through when captured is set.
+type failingCapture struct {
+	*FakeGateway
+	captured bool
+}
+
+func (g failingCapture) Capture(ctx context.Context, paymentID string) (Payment, error) {
+	if g.captured {
+		_, _ = g.FakeGateway.Capture(ctx, paymentID)
+	}
+	return Payment{}, fmt.Errorf("gateway timeout")
+}
+
+func TestTrainServer_ConfirmHoldCaptureFails(t *testing.T) {
+	for _, captured := range []bool{false, true} {
+		gateway := NewFakeGateway()
+		s := &TrainServer{Conf: newTestStoreConfig(), Payments: failingCapture{gateway, captured}}
+		s.InitServer()
+		ctx := context.Background()
+
+		hold, err := s.HoldSeat(ctx, holdRequest(email1))
+		assert.NoError(t, err)
+		_, err = s.ConfirmHold(ctx, &proto.ConfirmHoldRequest{HoldId: hold.HoldId})
+		assert.ErrorIs(t, err, errPaymentFailed)
+		// The seat is held again, until the hold was due to expire.
+		b, ok := s.Store.Lookup(hold.HoldId)
+		assert.True(t, ok)
+		assert.Equal(t, hold.ExpiresAt, b.HeldUntil)
+
+		_, err = s.ReleaseHold(ctx, &proto.ReleaseHoldRequest{HoldId: hold.HoldId})
+		assert.NoError(t, err)
+		p, _ := gateway.Payment(b.PaymentID)
+		if captured {
+			// A capture which went through despite the error is paid back.
+			assert.Equal(t, price1, p.Refunded)
+		} else {
+			assert.Equal(t, PaymentVoided, p.Status)
+		}
+	}
+}
+
+func TestTrainServer_GroupPayment(t *testing.T) {
+	gateway := NewFakeGateway()
+	s := &TrainServer{Conf: newTestStoreConfig(), Payments: gateway}
+	s.InitServer()
+
+	resp, err := s.PurchaseGroup(context.Background(), &proto.PurchaseGroupRequest{
+		Users: groupUsers(2), From: from1, To: to1, Price: 2*price1 + 1,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2*price1, resp.AmountCharged)
+	assert.Equal(t, int32(1), resp.Change)
+	assert.Equal(t, int32(1), resp.Tickets[0].Change)
+	assert.Zero(t, resp.Tickets[1].Change)
+	b, _ := s.Store.Lookup(resp.Tickets[1].BookingId)
+	p, _ := gateway.Payment(b.PaymentID)
+	assert.Equal(t, PaymentCaptured, p.Status)
+	assert.Equal(t, 2*price1, p.Amount)
+}
+
+// contendedStore loses every seat to another request.
+type contendedStore struct {
+	BookingStore
+	attempts *atomic.Int32
+}
+
+func (s contendedStore) Reserve(Booking) error {
+	s.attempts.Add(1)
+	return errSeatOccupied
+}
+
+func (s contendedStore) ReserveAll([]Booking) error {
+	s.attempts.Add(1)
+	return errSeatOccupied
+}
+
+func TestTrainServer_SeatContention(t *testing.T) {
+	gateway := NewFakeGateway()
+	s := &TrainServer{Conf: newTestStoreConfig(), Payments: gateway}
+	s.InitServer()
+	var attempts atomic.Int32
+	s.Store = contendedStore{s.Store, &attempts}
+	ctx := context.Background()
+
+	_, err := s.PurchaseTicket(ctx, holdRequest(email1))
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Equal(t, int32(maxSeatAttempts), attempts.Load())
+
+	attempts.Store(0)
+	_, err = s.PurchaseGroup(ctx, &proto.PurchaseGroupRequest{Users: groupUsers(2), From: from1, To: to1, Price: 2 * price1})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Equal(t, int32(maxSeatAttempts), attempts.Load())
+	// Every authorization was given back.
+	for i := 1; i <= 2*maxSeatAttempts; i++ {
+		p, ok := gateway.Payment(fmt.Sprintf("PAY-%06d", i))
+		assert.True(t, ok)
+		assert.Equal(t, PaymentVoided, p.Status)
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Ownership rules: which user a request belongs to.
+const (
+	// ownerUser is the user of the request: its email, its user, or the first
+	// passenger of a group.
+	ownerUser = "user"
+	// ownerBooking is the holder of the booking (or waitlist entry) of the
+	// request, the user of the request without a booking id.
+	ownerBooking = "booking"
+	ownerHold    = "hold"
+	ownerRefund  = "refund"
+	// ownerToken is the user the token of the request was issued to.
+	ownerToken = "token"
+)
+
+var ownerRules = []string{ownerUser, ownerBooking, ownerHold, ownerRefund, ownerToken}
+
+// defaultPolicy is who may call each TrainService method unless the policy
+// section of the config says otherwise. It is the only full table: the config
+// only lists overrides, and methods missing here cannot be called.
+var defaultPolicy = map[string]MethodPolicy{
+	"AuthUser":          {Public: true},
+	"Register":          {Public: true},
+	"Login":             {Public: true},
+	"RefreshToken":      {Public: true},
+	"GetJWKS":           {Public: true},
+	"GetAllRoutes":      {Public: true},
+	"GetSeatMap":        {Public: true},
+	"QuoteFare":         {Public: true},
+	"SearchJourneys":    {Public: true},
+	"Logout":            {},
+	"RevokeToken":       {Owner: ownerToken, Capabilities: []string{CapAdmin}},
+	"PurchaseTicket":    {Owner: ownerUser, Capabilities: []string{CapAdmin, CapWrite}},
+	"PurchaseGroup":     {Owner: ownerUser, Capabilities: []string{CapAdmin, CapWrite}},
+	"PurchaseJourney":   {Owner: ownerUser, Capabilities: []string{CapAdmin, CapWrite}},
+	"HoldSeat":          {Owner: ownerUser, Capabilities: []string{CapAdmin, CapWrite}},
+	"JoinWaitlist":      {Owner: ownerUser, Capabilities: []string{CapAdmin, CapWrite}},
+	"ConfirmHold":       {Owner: ownerHold, Capabilities: []string{CapAdmin, CapWrite}},
+	"ReleaseHold":       {Owner: ownerHold, Capabilities: []string{CapAdmin, CapWrite}},
+	"GetReceipt":        {Owner: ownerBooking, Capabilities: []string{CapAdmin, CapRead}},
+	"ListBookings":      {Owner: ownerUser, Capabilities: []string{CapAdmin, CapRead}},
+	"RemoveUser":        {Owner: ownerBooking, Capabilities: []string{CapAdmin, CapWrite}},
+	"ModifySeat":        {Owner: ownerBooking, Capabilities: []string{CapAdmin, CapWrite}},
+	"GetRefund":         {Owner: ownerRefund, Capabilities: []string{CapAdmin, CapRead}},
+	"GetUsersBySection": {Capabilities: []string{CapAdmin, CapRead}},
+	"CreateVoucher":     {Capabilities: []string{CapAdmin, CapWrite}},
+	"ListVouchers":      {Capabilities: []string{CapAdmin, CapRead}},
+	"DeleteVoucher":     {Capabilities: []string{CapAdmin, CapWrite}},
+}
+
+// methodPolicy returns the policy of a TrainService method.
+func methodPolicy(method string) (MethodPolicy, bool) {
+	if policy, ok := SConfig.Policy[method]; ok {
+		return policy, true
+	}
+	policy, ok := defaultPolicy[method]
+	return policy, ok
+}
+
+// PolicyOverrides describes, sorted by method, each policy of the config which
+// replaces a default, so they can be logged on startup.
+func PolicyOverrides() []string {
+	overrides := make([]string, 0, len(SConfig.Policy))
+	for method, policy := range SConfig.Policy {
+		overrides = append(overrides, fmt.Sprintf("%s: %v instead of %v", method, policy, defaultPolicy[method]))
+	}
+	slices.Sort(overrides)
+	return overrides
+}
+
+// String describes who the policy lets call a method.
+func (p MethodPolicy) String() string {
+	switch {
+	case p.Public:
+		return "public"
+	case p.Owner != "" && len(p.Capabilities) > 0:
+		return fmt.Sprintf("owner %s or caps %v", p.Owner, p.Capabilities)
+	case p.Owner != "":
+		return "owner " + p.Owner
+	case len(p.Capabilities) > 0:
+		return fmt.Sprintf("caps %v", p.Capabilities)
+	}
+	return "any authenticated caller"
+}
+
+// PolicyMiddleware enforces the policy of the method called, behind
+// ParseJWTMiddleware. Methods without a policy cannot be called.
+func (s *TrainServer) PolicyMiddleware(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method := path.Base(info.FullMethod)
+	policy, ok := methodPolicy(method)
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "No policy for %s.", method)
+	}
+	if policy.Public {
+		return handler(ctx, req)
+	}
+	owner := "*"
+	if policy.Owner != "" || len(policy.Capabilities) > 0 {
+		owner = s.owner(policy.Owner, req)
+	}
+	if err := AuthCheck(ctx, owner, policy.Capabilities...); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// owner returns the user req belongs to by rule, or "" if it belongs to
+// nobody in particular.
+func (s *TrainServer) owner(rule string, req interface{}) string {
+	switch rule {
+	case ownerUser:
+		return requestUser(req)
+	case ownerBooking:
+		email := requestUser(req)
+		r, ok := req.(interface{ GetBookingId() string })
+		if !ok || r.GetBookingId() == "" {
+			return email
+		}
+		holder := ""
+		if b, ok := s.Store.Lookup(r.GetBookingId()); ok {
+			holder = b.User.GetEmail()
+		} else if w, _, ok := s.Store.LookupWaitlist(r.GetBookingId()); ok {
+			holder = w.User.GetEmail()
+		}
+		if email != "" && email != holder {
+			return ""
+		}
+		return holder
+	case ownerHold:
+		if r, ok := req.(interface{ GetHoldId() string }); ok {
+			if b, ok := s.Store.Lookup(r.GetHoldId()); ok {
+				return b.User.GetEmail()
+			}
+		}
+	case ownerRefund:
+		if r, ok := req.(interface{ GetRefundId() string }); ok {
+			if refund, ok := s.Store.LookupRefund(r.GetRefundId()); ok {
+				return refund.User.GetEmail()
+			}
+		}
+	case ownerToken:
+		if r, ok := req.(interface{ GetToken() string }); ok {
+			if claims, err := parseJWT(r.GetToken()); err == nil {
+				return claims.UserID
+			}
+		}
+	}
+	return ""
+}
+
+// requestUser returns the email a request is made for, "" when it has none or
+// names different users.
+func requestUser(req interface{}) string {
+	var emails []string
+	if r, ok := req.(interface{ GetEmail() string }); ok {
+		emails = append(emails, r.GetEmail())
+	}
+	if r, ok := req.(interface{ GetUser() *proto.User }); ok {
+		emails = append(emails, r.GetUser().GetEmail())
+	}
+	if r, ok := req.(interface{ GetUsers() []*proto.User }); ok && len(r.GetUsers()) > 0 {
+		emails = append(emails, r.GetUsers()[0].GetEmail())
+	}
+	user := ""
+	for _, email := range emails {
+		switch {
+		case email == "":
+		case user == "":
+			user = email
+		case user != email:
+			return ""
+		}
+	}
+	return user
+}
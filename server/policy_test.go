@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	roleAnonymous = "anonymous"
+	roleOwner     = "owner"
+	roleStranger  = "stranger"
+	roleReader    = "reader"
+	roleWriter    = "writer"
+	roleAdmin     = "admin"
+)
+
+var policyRoles = []string{roleAnonymous, roleOwner, roleStranger, roleReader, roleWriter, roleAdmin}
+
+// roleContexts returns the context of a call by each role, as parsed by the
+// ParseJWTMiddleware of s. The owner is email2.
+func roleContexts(t *testing.T, s *TrainServer) map[string]context.Context {
+	contexts := map[string]context.Context{}
+	_, err := s.ParseJWTMiddleware(context.Background(), nil, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, _ any) (any, error) {
+			contexts[roleAnonymous] = ctx
+			return nil, nil
+		})
+	assert.NoError(t, err)
+	for role, user := range map[string]struct {
+		email        string
+		capabilities []string
+	}{
+		roleOwner:    {email2, nil},
+		roleStranger: {email3, nil},
+		roleReader:   {email4, []string{CapRead}},
+		roleWriter:   {email5, []string{CapWrite}},
+		roleAdmin:    {email1, []string{CapAdmin}},
+	} {
+		token, err := GenerateJWT(user.email, user.capabilities)
+		assert.NoError(t, err)
+		contexts[role] = tokenContext(t, s, token)
+	}
+	return contexts
+}
+
+func TestTrainServer_PolicyMiddleware(t *testing.T) {
+	s := newTestServer(t, func(conf *TrainConfig) {
+		conf.Routes = append(conf.Routes, RouteConfig{From: from2, To: to2, Price: price2}, RouteConfig{From: "Leeds", To: "York", Price: 10})
+	})
+	ctx := context.Background()
+	owner := &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2}
+	other := &proto.User{FirstName: firstName3, LastName: lastName3, Email: email3}
+
+	booking, err := s.PurchaseTicket(ctx, &proto.PurchaseRequest{User: owner, From: from1, To: to1, Price: price1})
+	assert.NoError(t, err)
+	hold, err := s.HoldSeat(ctx, &proto.PurchaseRequest{User: owner, From: from2, To: to2, Price: price2})
+	assert.NoError(t, err)
+	cancelled, err := s.PurchaseTicket(ctx, &proto.PurchaseRequest{User: owner, From: "Leeds", To: "York", Price: 10})
+	assert.NoError(t, err)
+	removed, err := s.RemoveUser(ctx, &proto.RemoveUserRequest{BookingId: cancelled.BookingId})
+	assert.NoError(t, err)
+	token, err := GenerateJWT(email2, nil)
+	assert.NoError(t, err)
+
+	everyone := policyRoles
+	authenticated := []string{roleOwner, roleStranger, roleReader, roleWriter, roleAdmin}
+	ownerOrWrite := []string{roleOwner, roleWriter, roleAdmin}
+	ownerOrRead := []string{roleOwner, roleReader, roleAdmin}
+	tests := []struct {
+		method  string
+		req     any
+		allowed []string
+	}{
+		{"AuthUser", &proto.AuthRequest{Email: email1}, everyone},
+		{"Register", &proto.RegisterRequest{User: owner}, everyone},
+		{"Login", &proto.AuthRequest{Email: email1}, everyone},
+		{"RefreshToken", &proto.RefreshTokenRequest{}, everyone},
+		{"Logout", &proto.LogoutRequest{}, authenticated},
+		{"RevokeToken", &proto.RevokeTokenRequest{Token: token}, []string{roleOwner, roleAdmin}},
+		{"GetJWKS", &proto.JWKSRequest{}, everyone},
+		{"GetAllRoutes", &proto.RouteRequest{}, everyone},
+		{"PurchaseTicket", &proto.PurchaseRequest{User: owner}, ownerOrWrite},
+		{"GetReceipt", &proto.ReceiptRequest{BookingId: booking.BookingId}, ownerOrRead},
+		{"GetUsersBySection", &proto.SectionRequest{Section: section1}, []string{roleReader, roleAdmin}},
+		{"RemoveUser", &proto.RemoveUserRequest{BookingId: booking.BookingId}, ownerOrWrite},
+		{"ModifySeat", &proto.ModifySeatRequest{Email: email2}, ownerOrWrite},
+		{"ListBookings", &proto.ListBookingsRequest{Email: email2}, ownerOrRead},
+		{"PurchaseGroup", &proto.PurchaseGroupRequest{Users: []*proto.User{owner, other}}, ownerOrWrite},
+		{"GetSeatMap", &proto.SeatMapRequest{Section: section1}, everyone},
+		{"HoldSeat", &proto.PurchaseRequest{User: owner}, ownerOrWrite},
+		{"ConfirmHold", &proto.ConfirmHoldRequest{HoldId: hold.HoldId}, ownerOrWrite},
+		{"ReleaseHold", &proto.ReleaseHoldRequest{HoldId: hold.HoldId}, ownerOrWrite},
+		{"JoinWaitlist", &proto.JoinWaitlistRequest{User: owner}, ownerOrWrite},
+		{"GetRefund", &proto.RefundRequest{RefundId: removed.RefundId}, ownerOrRead},
+		{"QuoteFare", &proto.QuoteFareRequest{}, everyone},
+		{"CreateVoucher", &proto.Voucher{Code: "SPRING"}, []string{roleWriter, roleAdmin}},
+		{"ListVouchers", &proto.ListVouchersRequest{}, []string{roleReader, roleAdmin}},
+		{"DeleteVoucher", &proto.DeleteVoucherRequest{Code: "SPRING"}, []string{roleWriter, roleAdmin}},
+		{"SearchJourneys", &proto.SearchJourneysRequest{}, everyone},
+		{"PurchaseJourney", &proto.PurchaseJourneyRequest{User: owner}, ownerOrWrite},
+	}
+
+	var methods []string
+	for _, m := range proto.TrainService_ServiceDesc.Methods {
+		methods = append(methods, m.MethodName)
+	}
+	var tested []string
+	for _, tt := range tests {
+		tested = append(tested, tt.method)
+	}
+	assert.ElementsMatch(t, methods, tested, "every method must be tested")
+
+	contexts := roleContexts(t, s)
+	for _, tt := range tests {
+		for _, role := range policyRoles {
+			t.Run(tt.method+"/"+role, func(t *testing.T) {
+				err := authorize(contexts[role], s, tt.method, tt.req)
+				switch {
+				case slices.Contains(tt.allowed, role):
+					assert.NoError(t, err)
+				case role == roleAnonymous:
+					assert.Equal(t, codes.Unauthenticated, status.Code(err))
+				default:
+					assert.Equal(t, codes.PermissionDenied, status.Code(err))
+				}
+			})
+		}
+	}
+}
+
+func TestTrainServer_PolicyOwner(t *testing.T) {
+	s := newTestServer(t, nil)
+	owner := &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2}
+	booking, err := s.PurchaseTicket(context.Background(), &proto.PurchaseRequest{User: owner, From: from1, To: to1, Price: price1})
+	assert.NoError(t, err)
+	ctx := roleContexts(t, s)[roleStranger]
+
+	// Naming yourself does not give access to the booking of someone else.
+	err = authorize(ctx, s, "GetReceipt", &proto.ReceiptRequest{Email: email3, BookingId: booking.BookingId})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	// Unknown bookings belong to nobody.
+	err = authorize(ctx, s, "GetReceipt", &proto.ReceiptRequest{BookingId: "BK-404"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	// Buying for someone else needs the write capability.
+	err = authorize(ctx, s, "PurchaseTicket", &proto.PurchaseRequest{User: owner})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	err = authorize(ctx, s, "PurchaseTicket", &proto.PurchaseRequest{User: &proto.User{Email: email3}})
+	assert.NoError(t, err)
+}
+
+func TestTrainServer_PolicyConfig(t *testing.T) {
+	s := newTestServer(t, nil)
+	contexts := roleContexts(t, s)
+	SConfig.Policy = map[string]MethodPolicy{
+		"GetAllRoutes":   {Capabilities: []string{CapRead}},
+		"PurchaseTicket": {Public: true},
+	}
+	defer func() { SConfig.Policy = nil }()
+	assert.Equal(t, []string{
+		"GetAllRoutes: caps [read] instead of public",
+		"PurchaseTicket: public instead of owner user or caps [admin write]",
+	}, PolicyOverrides())
+
+	err := authorize(contexts[roleAnonymous], s, "GetAllRoutes", &proto.RouteRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.NoError(t, authorize(contexts[roleReader], s, "GetAllRoutes", &proto.RouteRequest{}))
+	assert.NoError(t, authorize(contexts[roleAnonymous], s, "PurchaseTicket", &proto.PurchaseRequest{}))
+	// Other methods keep their default policy.
+	err = authorize(contexts[roleAnonymous], s, "ListVouchers", &proto.ListVouchersRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	err = authorize(contexts[roleAdmin], s, "Unknown", nil)
+	assert.EqualError(t, err, "rpc error: code = PermissionDenied desc = No policy for Unknown.")
+}
+
+func TestAuthCheck(t *testing.T) {
+	s := newTestServer(t, nil)
+	contexts := roleContexts(t, s)
+	// Capabilities are compared by name.
+	assert.Equal(t, codes.PermissionDenied, status.Code(AuthCheck(contexts[roleReader], "", CapWrite)))
+	assert.NoError(t, AuthCheck(contexts[roleReader], "", CapAdmin, CapRead))
+	// An empty user matches nobody.
+	assert.Equal(t, codes.PermissionDenied, status.Code(AuthCheck(contexts[roleOwner], "")))
+	assert.NoError(t, AuthCheck(contexts[roleOwner], email2))
+	assert.NoError(t, AuthCheck(contexts[roleOwner], "*"))
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultQuoteTTL = 60
+
+var errNotEnoughMoney = errors.New("you must pay more money")
+
+// fareQuote freezes the fares of a trip segment until it expires.
+type fareQuote struct {
+	id        string
+	trip      Trip
+	segment   Segment
+	fares     map[string]int32
+	expiresAt int64
+}
+
+// quoteBook keeps the quotes handed out by QuoteFare. Quotes only live for a
+// short while, so they are not persisted.
+type quoteBook struct {
+	mu     sync.Mutex
+	quotes map[string]fareQuote
+}
+
+func (q *quoteBook) add(quote fareQuote, now int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.quotes == nil {
+		q.quotes = map[string]fareQuote{}
+	}
+	for id, old := range q.quotes {
+		if old.expiresAt <= now {
+			delete(q.quotes, id)
+		}
+	}
+	q.quotes[quote.id] = quote
+}
+
+func (q *quoteBook) get(id string) (fareQuote, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	quote, ok := q.quotes[id]
+	return quote, ok
+}
+
+// QuoteFare prices a trip now and keeps that price for quote_ttl seconds, so
+// a purchase passing the quote id pays what the customer was shown.
+func (s *TrainServer) QuoteFare(_ context.Context, req *proto.QuoteFareRequest) (*proto.QuoteFareResponse, error) {
+	index, seg, err := s.getRouteIndex(req.From, req.To, req.DepartsAt)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	trip := Trip{Route: int32(index), DepartsAt: req.DepartsAt}
+	quote := fareQuote{
+		id:        newID("QT-"),
+		trip:      trip,
+		segment:   seg,
+		fares:     s.fares(trip, seg, now),
+		expiresAt: now.Add(s.quoteTTL()).Unix(),
+	}
+	s.quotes.add(quote, now.Unix())
+	resp := &proto.QuoteFareResponse{
+		QuoteId:   quote.id,
+		Route:     quote.trip.Route,
+		DepartsAt: quote.trip.DepartsAt,
+		Fares:     make([]*proto.SectionFare, 0, len(quote.fares)),
+		ExpiresAt: quote.expiresAt,
+		Currency:  s.routeCurrency(trip.Route),
+	}
+	resp.Fares = s.sectionFares(quote.fares)
+	resp.Price = cheapest(quote.fares)
+	s.logger.Info("QuoteFare", resp)
+	return resp, nil
+}
+
+// sectionFares describes every section with its fare class and the fare from
+// fares.
+func (s *TrainServer) sectionFares(fares map[string]int32) []*proto.SectionFare {
+	resp := make([]*proto.SectionFare, 0, len(s.Conf.Sections))
+	for _, sec := range s.Conf.Sections {
+		resp = append(resp, &proto.SectionFare{
+			Section:   sec.Name,
+			Price:     fares[sec.Name],
+			FareClass: sec.FareClass(),
+			SeatCount: int32(s.Conf.SectionSeats(sec.Name)),
+			Amenities: sec.Amenities,
+		})
+	}
+	return resp
+}
+
+// classFares returns the list price of every section for seg of a route: the
+// share of the route price for the stops travelled, with the class percent
+// applied, before any pricing rule.
+func (s *TrainServer) classFares(route int, seg Segment) map[string]int32 {
+	price := s.Conf.Routes[route].Price
+	if legs, total := s.Conf.Routes[route].Legs(seg); legs < total {
+		price = int32((2*int64(price)*int64(legs) + int64(total)) / (2 * int64(total)))
+	}
+	fares := make(map[string]int32, len(s.Conf.Sections))
+	for _, sec := range s.Conf.Sections {
+		fares[sec.Name] = price
+		if sec.Percent > 0 {
+			fares[sec.Name] = applyPercents(fares[sec.Name], []int{sec.Percent})
+		}
+	}
+	return fares
+}
+
+// fareClass returns the fare class of a section.
+func (s *TrainServer) fareClass(section string) string {
+	sec, _ := s.Conf.Section(section)
+	return sec.FareClass()
+}
+
+// tripFares returns the fare of every section for seg of trip, taken from
+// quoteID when given and priced now otherwise.
+func (s *TrainServer) tripFares(trip Trip, seg Segment, quoteID string, now time.Time) (map[string]int32, error) {
+	if quoteID == "" {
+		return s.fares(trip, seg, now), nil
+	}
+	quote, ok := s.quotes.get(quoteID)
+	if !ok || quote.trip != trip || quote.segment != seg {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid quote: %v", quoteID)
+	}
+	if quote.expiresAt <= now.Unix() {
+		return nil, status.Errorf(codes.FailedPrecondition, "quote expired: %v", quoteID)
+	}
+	return quote.fares, nil
+}
+
+// fares prices every section for seg of trip at now. Each matching rule
+// multiplies the class price of the section by its percent, rounding to the
+// nearest unit at every step.
+func (s *TrainServer) fares(trip Trip, seg Segment, now time.Time) map[string]int32 {
+	pricing := s.Conf.Pricing
+	percents := make([]int, 0, 3)
+	if p := s.surgePercent(trip, seg); p > 0 {
+		percents = append(percents, p)
+	}
+	if trip.DepartsAt != 0 {
+		ahead := time.Unix(trip.DepartsAt, 0).Sub(now)
+		if r := pricing.EarlyBird; r.Percent > 0 && ahead >= time.Duration(r.Days)*24*time.Hour {
+			percents = append(percents, r.Percent)
+		}
+		if r := pricing.LastMinute; r.Percent > 0 && ahead < time.Duration(r.Hours)*time.Hour {
+			percents = append(percents, r.Percent)
+		}
+	}
+	fares := s.classFares(int(trip.Route), seg)
+	for sec, fare := range fares {
+		fares[sec] = applyPercents(fare, percents)
+	}
+	return fares
+}
+
+// surgePercent returns the percent of the highest surge tier the occupancy of
+// trip over seg reaches, or 0.
+func (s *TrainServer) surgePercent(trip Trip, seg Segment) int {
+	total := s.Conf.TotalSeats()
+	if total == 0 || len(s.Conf.Pricing.Surge) == 0 {
+		return 0
+	}
+	occupancy := (total - int(s.availableSeats(trip, seg))) * 100 / total
+	percent, reached := 0, -1
+	for _, tier := range s.Conf.Pricing.Surge {
+		if occupancy >= tier.Occupancy && tier.Occupancy > reached {
+			percent, reached = tier.Percent, tier.Occupancy
+		}
+	}
+	return percent
+}
+
+func applyPercents(fare int32, percents []int) int32 {
+	for _, p := range percents {
+		fare = int32((int64(fare)*int64(p) + 50) / 100)
+	}
+	return fare
+}
+
+// affordable keeps the sections whose fare is covered by paid.
+func affordable(fares map[string]int32, paid int32) (map[string]int32, error) {
+	kept := make(map[string]int32, len(fares))
+	for sec, fare := range fares {
+		if fare <= paid {
+			kept[sec] = fare
+		}
+	}
+	if len(kept) == 0 {
+		return nil, errNotEnoughMoney
+	}
+	return kept, nil
+}
+
+// cheapest returns the lowest fare of the sections.
+func cheapest(fares map[string]int32) int32 {
+	prices := make([]int32, 0, len(fares))
+	for _, fare := range fares {
+		prices = append(prices, fare)
+	}
+	return slices.Min(prices)
+}
+
+func (s *TrainServer) quoteTTL() time.Duration {
+	if s.Conf.Pricing.QuoteTTL <= 0 {
+		return defaultQuoteTTL * time.Second
+	}
+	return time.Duration(s.Conf.Pricing.QuoteTTL) * time.Second
+}
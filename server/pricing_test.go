@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pricingConfig sets up 5 seats per section with pricing, and second as the
+// second section.
+func pricingConfig(pricing PricingConfig, second SectionConfig) func(*TrainConfig) {
+	return func(conf *TrainConfig) {
+		conf.SeatCount = 5
+		conf.Sections[1] = second
+		conf.Pricing = pricing
+	}
+}
+
+func TestTrainServer_fares(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	pricing := PricingConfig{
+		Surge:      []SurgeRule{{Occupancy: 50, Percent: 150}, {Occupancy: 20, Percent: 120}},
+		EarlyBird:  EarlyBirdRule{Days: 3, Percent: 80},
+		LastMinute: LastMinuteRule{Hours: 2, Percent: 110},
+	}
+	tests := []struct {
+		name      string
+		departsAt time.Time
+		taken     int
+		fares     map[string]int32
+	}{
+		{"undated", time.Time{}, 0, map[string]int32{section1: 20, section2: 25}},
+		{"regular", now.Add(24 * time.Hour), 0, map[string]int32{section1: 20, section2: 25}},
+		{"early bird", now.Add(72 * time.Hour), 0, map[string]int32{section1: 16, section2: 20}},
+		{"last minute", now.Add(time.Hour), 0, map[string]int32{section1: 22, section2: 28}},
+		{"surge", now.Add(24 * time.Hour), 2, map[string]int32{section1: 24, section2: 30}},
+		{"highest surge tier", now.Add(24 * time.Hour), 5, map[string]int32{section1: 30, section2: 38}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, pricingConfig(pricing, SectionConfig{Name: section2, Percent: 125}))
+			trip := Trip{Route: 0}
+			if !tt.departsAt.IsZero() {
+				trip.DepartsAt = tt.departsAt.Unix()
+			}
+			for i := 0; i < tt.taken; i++ {
+				err := s.Store.Reserve(Booking{
+					ID:      newBookingID(),
+					Trip:    trip,
+					Section: section1,
+					Seat:    int32(i),
+					User:    &proto.User{FirstName: firstName1, LastName: lastName1, Email: fmt.Sprintf("taken%d@example.com", i)},
+				})
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.fares, s.fares(trip, Segment{}, now))
+		})
+	}
+}
+
+func TestTrainServer_QuoteFare(t *testing.T) {
+	s := newTestServer(t, pricingConfig(PricingConfig{
+		QuoteTTL: 60,
+		Surge:    []SurgeRule{{Occupancy: 10, Percent: 200}},
+	}, SectionConfig{Name: section2, Class: "first", Percent: 150, SeatCount: 3, Amenities: []string{"wifi"}}))
+	ctx := context.Background()
+
+	quote, err := s.QuoteFare(ctx, &proto.QuoteFareRequest{From: from1, To: to1})
+	assert.NoError(t, err)
+	assert.Equal(t, price1, quote.Price)
+	assert.Equal(t, []*proto.SectionFare{
+		{Section: section1, Price: 20, FareClass: "standard", SeatCount: 5},
+		{Section: section2, Price: 30, FareClass: "first", SeatCount: 3, Amenities: []string{"wifi"}},
+	}, quote.Fares)
+
+	// Only section1 is covered by the price paid.
+	req := holdRequest(email1)
+	req.QuoteId = quote.QuoteId
+	ticket, err := s.PurchaseTicket(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, section1, ticket.Section)
+	assert.Equal(t, price1, ticket.AmountCharged)
+
+	// The surge now doubles the fare, but the quote is still honoured.
+	_, err = s.PurchaseTicket(ctx, holdRequest(email2))
+	assert.ErrorIs(t, err, errNotEnoughMoney)
+	req = holdRequest(email2)
+	req.QuoteId = quote.QuoteId
+	ticket, err = s.PurchaseTicket(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, price1, ticket.AmountCharged)
+
+	req = holdRequest(email3)
+	req.QuoteId = quote.QuoteId
+	req.Section = section2
+	_, err = s.PurchaseTicket(ctx, req)
+	assert.ErrorIs(t, err, errNotEnoughMoney)
+
+	s.quotes.add(fareQuote{id: "QT-OLD", trip: Trip{Route: 0}, fares: map[string]int32{section1: 1, section2: 1}, expiresAt: time.Now().Unix() - 1}, 0)
+	req = holdRequest(email3)
+	req.QuoteId = "QT-OLD"
+	_, err = s.PurchaseTicket(ctx, req)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestTrainServer_fareClasses(t *testing.T) {
+	s := newTestServer(t, pricingConfig(PricingConfig{}, SectionConfig{Name: section2, Class: "first", Percent: 200, SeatCount: 1, Amenities: []string{"wifi"}}))
+	ctx := context.Background()
+
+	routes, err := s.GetAllRoutes(ctx, &proto.RouteRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*proto.SectionFare{
+		{Section: section1, Price: 20, FareClass: "standard", SeatCount: 5},
+		{Section: section2, Price: 40, FareClass: "first", SeatCount: 1, Amenities: []string{"wifi"}},
+	}, routes.Routes[0].Sections)
+	assert.Equal(t, int32(6), routes.Routes[0].Available)
+
+	req := holdRequest(email1)
+	req.Section, req.Price = section2, 50
+	ticket, err := s.PurchaseTicket(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", ticket.FareClass)
+	assert.Equal(t, int32(40), ticket.AmountCharged)
+	assert.Equal(t, int32(10), ticket.Change)
+
+	b, ok := s.Store.Lookup(ticket.BookingId)
+	assert.True(t, ok)
+	receipt := s.receipt(b)
+	assert.Equal(t, "first", receipt.FareClass)
+	assert.Equal(t, int32(40), receipt.Price)
+
+	// The only first class seat is gone.
+	req = holdRequest(email2)
+	req.Section, req.Price = section2, 50
+	_, err = s.PurchaseTicket(ctx, req)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	seat := int32(1)
+	req.Seat = &seat
+	_, err = s.PurchaseTicket(ctx, req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	ticket, err = s.PurchaseTicket(ctx, holdRequest(email2))
+	assert.NoError(t, err)
+	assert.Equal(t, section1, ticket.Section)
+	assert.Equal(t, "standard", ticket.FareClass)
+}
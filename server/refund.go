@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+)
+
+// GetRefund shows a refund issued by RemoveUser.
+func (s *TrainServer) GetRefund(_ context.Context, req *proto.RefundRequest) (*proto.RefundResponse, error) {
+	r, ok := s.Store.LookupRefund(req.RefundId)
+	if !ok || (req.Email != "" && r.User.Email != req.Email) {
+		return nil, fmt.Errorf("no refund found: %v", req.RefundId)
+	}
+	resp := &proto.RefundResponse{
+		RefundId:  r.ID,
+		BookingId: r.BookingID,
+		User:      r.User,
+		Price:     r.Price,
+		Amount:    r.Amount,
+		CreatedAt: r.CreatedAt,
+	}
+	s.logger.Info("GetRefund", resp)
+	return resp, nil
+}
+
+// refundAmount applies the cancellation policy to booking b cancelled at now.
+// Holds were never paid, and undated trips are refunded in full since they
+// have no departure to count from.
+func (s *TrainServer) refundAmount(b Booking, now time.Time) int32 {
+	if b.HeldUntil != 0 {
+		return 0
+	}
+	price := s.bookingPrice(b)
+	if b.DepartsAt == 0 {
+		return price
+	}
+	departure := time.Unix(b.DepartsAt, 0)
+	policy := s.Conf.Cancellation
+	switch {
+	case !now.Before(departure):
+		return 0
+	case departure.Sub(now) >= time.Duration(policy.FullRefundHours)*time.Hour:
+		return price
+	default:
+		return price * int32(policy.PartialRefundPercent) / 100
+	}
+}
+
+// bookingPrice is the fare paid for b. Bookings made before prices were
+// recorded paid the route price.
+func (s *TrainServer) bookingPrice(b Booking) int32 {
+	if b.Price == 0 {
+		return s.Conf.Routes[b.Route].Price
+	}
+	return b.Price
+}
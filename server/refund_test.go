@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrainServer_refundAmount(t *testing.T) {
+	conf := newTestStoreConfig()
+	conf.Cancellation = CancellationPolicy{FullRefundHours: 24, PartialRefundPercent: 50}
+	s := &TrainServer{Conf: conf}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		booking Booking
+		amount  int32
+	}{
+		{"undated", Booking{Price: 40}, 40},
+		{"route price", Booking{Trip: Trip{DepartsAt: now.Add(48 * time.Hour).Unix()}}, price1},
+		{"early", Booking{Trip: Trip{DepartsAt: now.Add(24 * time.Hour).Unix()}, Price: 40}, 40},
+		{"late", Booking{Trip: Trip{DepartsAt: now.Add(time.Hour).Unix()}, Price: 40}, 20},
+		{"departed", Booking{Trip: Trip{DepartsAt: now.Unix()}, Price: 40}, 0},
+		{"hold", Booking{Price: 40, HeldUntil: now.Unix()}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.amount, s.refundAmount(tt.booking, now))
+		})
+	}
+}
+
+func TestTrainServer_RemoveUserRefund(t *testing.T) {
+	s := newTestServer(t, nil)
+	ticket, err := s.PurchaseTicket(context.Background(), holdRequest(email1))
+	assert.NoError(t, err)
+
+	ctx := authContext(t, email1)
+	removed, err := s.RemoveUser(ctx, &proto.RemoveUserRequest{Email: email1, BookingId: ticket.BookingId})
+	assert.NoError(t, err)
+	assert.Equal(t, price1, removed.RefundAmount)
+
+	refund, err := s.GetRefund(ctx, &proto.RefundRequest{Email: email1, RefundId: removed.RefundId})
+	assert.NoError(t, err)
+	assert.Equal(t, ticket.BookingId, refund.BookingId)
+	assert.Equal(t, price1, refund.Price)
+	assert.Equal(t, price1, refund.Amount)
+	assert.Equal(t, email1, refund.User.Email)
+
+	_, err = s.GetRefund(authContext(t, email2), &proto.RefundRequest{Email: email2, RefundId: removed.RefundId})
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	departureLayout     = "15:04"
+	defaultScheduleDays = 7
+)
+
+// upcomingDepartures lists the departures of route after now within the
+// booking horizon, in chronological order.
+func (s *TrainServer) upcomingDepartures(route RouteConfig, now time.Time) []time.Time {
+	days := s.Conf.ScheduleDays
+	if days <= 0 {
+		days = defaultScheduleDays
+	}
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	departures := make([]time.Time, 0, days*len(route.Departures))
+	for day := 0; day < days; day++ {
+		date := today.AddDate(0, 0, day)
+		for _, departure := range route.Departures {
+			clock, err := time.Parse(departureLayout, departure)
+			if err != nil {
+				continue
+			}
+			at := date.Add(time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute)
+			if at.After(now) {
+				departures = append(departures, at)
+			}
+		}
+	}
+	sort.Slice(departures, func(i, j int) bool { return departures[i].Before(departures[j]) })
+	return departures
+}
+
+// checkDeparture makes sure departsAt is a bookable departure of the route.
+func (s *TrainServer) checkDeparture(routeIndex int, departsAt int64) error {
+	route := s.Conf.Routes[routeIndex]
+	if len(route.Departures) == 0 {
+		if departsAt != 0 {
+			return fmt.Errorf("route has no timetable")
+		}
+		return nil
+	}
+	if departsAt == 0 {
+		return fmt.Errorf("departure must be provided")
+	}
+	for _, at := range s.upcomingDepartures(route, time.Now()) {
+		if at.Unix() == departsAt {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid departure")
+}
+
+// availableSeats counts the seats of a trip, over all sections, which are
+// free for the whole of seg.
+func (s *TrainServer) availableSeats(trip Trip, seg Segment) int32 {
+	var available int32
+	for _, sec := range s.Conf.SectionNames() {
+		taken, err := s.Store.ListSection(trip, sec, seg)
+		if err != nil {
+			continue
+		}
+		for _, b := range taken {
+			if b == nil {
+				available++
+			}
+		}
+	}
+	return available
+}
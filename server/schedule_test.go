@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func scheduleConfig(conf *TrainConfig) {
+	conf.ScheduleDays = 2
+	conf.Routes = append(conf.Routes, RouteConfig{
+		From:       from2,
+		To:         to2,
+		Price:      price2,
+		Departures: []string{"09:00", "18:30"},
+	})
+}
+
+func TestTrainServer_upcomingDepartures(t *testing.T) {
+	s := newTestServer(t, scheduleConfig)
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	departures := s.upcomingDepartures(s.Conf.Routes[1], now)
+	assert.Equal(t, []time.Time{
+		time.Date(2026, 10, 17, 18, 30, 0, 0, time.UTC),
+		time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 18, 18, 30, 0, 0, time.UTC),
+	}, departures)
+	assert.Empty(t, s.upcomingDepartures(s.Conf.Routes[0], now))
+}
+
+func TestTrainServer_PurchaseDeparture(t *testing.T) {
+	s := newTestServer(t, scheduleConfig)
+	routes, err := s.GetAllRoutes(context.Background(), &proto.RouteRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2*seatCount), routes.Routes[0].Available)
+	assert.Empty(t, routes.Routes[0].Departures)
+	departures := routes.Routes[1].Departures
+	assert.GreaterOrEqual(t, len(departures), 2)
+	for _, d := range departures {
+		assert.Equal(t, int32(2*seatCount), d.Available)
+	}
+
+	user := &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1}
+
+	t.Run("departure required", func(t *testing.T) {
+		_, err := s.PurchaseTicket(context.Background(), &proto.PurchaseRequest{User: user, From: from2, To: to2, Price: price2})
+		assert.EqualError(t, err, "departure must be provided")
+	})
+
+	t.Run("invalid departure", func(t *testing.T) {
+		_, err := s.PurchaseTicket(context.Background(), &proto.PurchaseRequest{
+			User: user, From: from2, To: to2, Price: price2, DepartsAt: departures[0].DepartsAt + 60,
+		})
+		assert.EqualError(t, err, "invalid departure")
+	})
+
+	t.Run("route has no timetable", func(t *testing.T) {
+		_, err := s.PurchaseTicket(context.Background(), &proto.PurchaseRequest{
+			User: user, From: from1, To: to1, Price: price1, DepartsAt: departures[0].DepartsAt,
+		})
+		assert.EqualError(t, err, "route has no timetable")
+	})
+
+	t.Run("valid departure", func(t *testing.T) {
+		resp, err := s.PurchaseTicket(context.Background(), &proto.PurchaseRequest{
+			User: user, From: from2, To: to2, Price: price2, DepartsAt: departures[1].DepartsAt,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, departures[1].DepartsAt, resp.DepartsAt)
+
+		routes, err := s.GetAllRoutes(context.Background(), &proto.RouteRequest{})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2*seatCount), routes.Routes[1].Departures[0].Available)
+		assert.Equal(t, int32(2*seatCount-1), routes.Routes[1].Departures[1].Available)
+
+		booking, ok := s.Store.Lookup(resp.BookingId)
+		assert.True(t, ok)
+		receipt := s.receipt(booking)
+		assert.Equal(t, departures[1].DepartsAt, receipt.DepartsAt)
+		assert.Equal(t, from2, receipt.From)
+	})
+}
+
+func TestTrainServer_PurchaseSharedStations(t *testing.T) {
+	s := newTestServer(t, func(conf *TrainConfig) {
+		conf.ScheduleDays = 2
+		conf.Routes = append(conf.Routes,
+			RouteConfig{From: "Leeds", To: "Hull", Stops: []string{"York"}, Price: 2000, Departures: []string{"09:00"}},
+			RouteConfig{From: "Leeds", To: "York", Price: 1000, Departures: []string{"12:00"}},
+		)
+	})
+	ctx := context.Background()
+	buy := func(email string, departsAt int64) (*proto.PurchaseResponse, error) {
+		req := holdRequest(email)
+		req.From, req.To, req.Price, req.DepartsAt = "Leeds", "York", 2000, departsAt
+		return s.PurchaseTicket(ctx, req)
+	}
+
+	// Both routes call at Leeds and then York; the departure picks the route.
+	for i, route := range []int32{1, 2} {
+		departsAt := s.upcomingDepartures(s.Conf.Routes[route], time.Now())[0].Unix()
+		resp, err := buy(fmt.Sprintf("u%d@example.com", i), departsAt)
+		assert.NoError(t, err)
+		assert.Equal(t, route, resp.Route)
+		assert.Equal(t, departsAt, resp.DepartsAt)
+	}
+	_, err := buy(email1, 1)
+	assert.EqualError(t, err, "invalid departure")
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"slices"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultSeatsPerRow = 4
+
+// seatRequest is what a buyer asked for. The zero value accepts any seat for
+// the whole route.
+type seatRequest struct {
+	// segment is the part of the route the seat must be free for.
+	segment     Segment
+	section     string
+	seat        *int32
+	preferences []proto.SeatPreference
+	// fares holds the fare of every section the buyer can afford with paid.
+	// Without fares any section will do at the price of the booking.
+	fares map[string]int32
+	paid  int32
+	// voucher discounted fares by discounts, per section.
+	voucher   string
+	discounts map[string]int32
+	// exchange converts fares into the currency paid is in.
+	exchange exchange
+	// charges breaks the fares down, per section.
+	charges map[string]Charges
+}
+
+func newSeatRequest(req *proto.PurchaseRequest) seatRequest {
+	return seatRequest{
+		section:     req.Section,
+		seat:        req.Seat,
+		preferences: req.Preferences,
+	}
+}
+
+// validateSeatRequest rejects requests which can never be honoured, whatever
+// the occupancy of the train.
+func (s *TrainServer) validateSeatRequest(r seatRequest) error {
+	if _, ok := s.Conf.Section(r.section); r.section != "" && !ok {
+		return status.Errorf(codes.InvalidArgument, "invalid section: %s", r.section)
+	}
+	if r.seat != nil {
+		if r.section == "" {
+			return status.Errorf(codes.InvalidArgument, "section must be provided with seat")
+		}
+		if *r.seat < 0 || int(*r.seat) >= s.Conf.SectionSeats(r.section) {
+			return status.Errorf(codes.InvalidArgument, "invalid seat: %d", *r.seat)
+		}
+	}
+	return nil
+}
+
+// GetSeatMap lists every seat of a section with its state, so clients can
+// render a seat picker. Passenger details are only included for admins.
+func (s *TrainServer) GetSeatMap(ctx context.Context, req *proto.SeatMapRequest) (*proto.SeatMapResponse, error) {
+	if req.Route < 0 || int(req.Route) >= len(s.Conf.Routes) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid route: %d", req.Route)
+	}
+	if err := s.checkDeparture(int(req.Route), req.DepartsAt); err != nil {
+		return nil, err
+	}
+	seg, err := s.seatMapSegment(req)
+	if err != nil {
+		return nil, err
+	}
+	bookings, err := s.Store.ListSection(Trip{Route: req.Route, DepartsAt: req.DepartsAt}, req.Section, seg)
+	if err != nil {
+		return nil, err
+	}
+	admin := HasCapability(ctx, CapAdmin)
+	sec, _ := s.Conf.Section(req.Section)
+	resp := &proto.SeatMapResponse{
+		Section:     req.Section,
+		SeatsPerRow: int32(s.seatsPerRow()),
+		Quiet:       slices.Contains(s.Conf.QuietSections, req.Section),
+		FareClass:   sec.FareClass(),
+		Amenities:   sec.Amenities,
+		Seats:       make([]*proto.SeatStatus, 0, len(bookings)),
+	}
+	for i, b := range bookings {
+		seat := &proto.SeatStatus{Seat: int32(i), State: proto.SeatState_SEAT_STATE_FREE}
+		if b != nil {
+			seat.State = proto.SeatState_SEAT_STATE_BOOKED
+			if b.HeldUntil != 0 {
+				seat.State = proto.SeatState_SEAT_STATE_HELD
+			}
+			if admin {
+				seat.User = b.User
+			}
+		}
+		resp.Seats = append(resp.Seats, seat)
+	}
+	s.logger.Info("GetSeatMap", resp)
+	return resp, nil
+}
+
+// seatMapSegment resolves the stations a seat map is asked for, the whole
+// route when not given.
+func (s *TrainServer) seatMapSegment(req *proto.SeatMapRequest) (Segment, error) {
+	if req.From == "" && req.To == "" {
+		return Segment{}, nil
+	}
+	route := s.Conf.Routes[req.Route]
+	from, to := req.From, req.To
+	if from == "" {
+		from = route.From
+	}
+	if to == "" {
+		to = route.To
+	}
+	seg, ok := route.Segment(from, to)
+	if !ok {
+		return Segment{}, status.Errorf(codes.InvalidArgument, "route does not call at %v and then %v", from, to)
+	}
+	return seg, nil
+}
+
+// findEmptySeat picks a seat of trip matching r which is free over the
+// segment of r. Without a request the section and seat are random so bookings
+// spread over the train.
+func (s *TrainServer) findEmptySeat(trip Trip, r seatRequest) (string, int32, error) {
+	sections := s.Conf.SectionNames()
+	if r.section != "" {
+		sections = []string{r.section}
+	}
+	sectionCount := len(sections)
+	x := rand.Intn(sectionCount)
+	for i := 0; i < sectionCount; i++ {
+		sec := sections[(i+x)%sectionCount]
+		if !s.sectionMatches(sec, r.preferences) {
+			continue
+		}
+		if _, ok := r.fares[sec]; r.fares != nil && !ok {
+			continue
+		}
+		taken, err := s.Store.ListSection(trip, sec, r.segment)
+		if err != nil || len(taken) == 0 {
+			continue
+		}
+		if r.seat != nil {
+			if taken[*r.seat] != nil {
+				return "", -1, status.Errorf(codes.FailedPrecondition, "seat %s/%d is not available", sec, *r.seat)
+			}
+			if !s.seatMatches(sec, *r.seat, r.preferences) {
+				return "", -1, status.Errorf(codes.FailedPrecondition, "seat %s/%d does not match the requested preferences", sec, *r.seat)
+			}
+			return sec, *r.seat, nil
+		}
+		y := rand.Intn(len(taken))
+		for j := 0; j < len(taken); j++ {
+			number := (j + y) % len(taken)
+			if taken[number] == nil && s.seatMatches(sec, int32(number), r.preferences) {
+				return sec, int32(number), nil
+			}
+		}
+	}
+	if r.section != "" || len(r.preferences) > 0 || (r.fares != nil && len(r.fares) < len(s.Conf.Sections)) {
+		return "", -1, status.Errorf(codes.FailedPrecondition, "cannot find empty seat matching the request")
+	}
+	return "", -1, fmt.Errorf("cannot find empty seat")
+}
+
+func (s *TrainServer) sectionMatches(section string, preferences []proto.SeatPreference) bool {
+	if slices.Contains(preferences, proto.SeatPreference_SEAT_PREFERENCE_QUIET) {
+		return slices.Contains(s.Conf.QuietSections, section)
+	}
+	return true
+}
+
+// seatMatches checks the position of a seat against the preferences. Seats
+// at either end of a row are window seats, the ones next to the middle aisle
+// are aisle seats, and the first half of the rows of the section is the front.
+func (s *TrainServer) seatMatches(section string, seat int32, preferences []proto.SeatPreference) bool {
+	perRow := int32(s.seatsPerRow())
+	pos := seat % perRow
+	rows := (int32(s.Conf.SectionSeats(section)) + perRow - 1) / perRow
+	front := seat/perRow < (rows+1)/2
+	for _, p := range preferences {
+		switch p {
+		case proto.SeatPreference_SEAT_PREFERENCE_WINDOW:
+			if pos != 0 && pos != perRow-1 {
+				return false
+			}
+		case proto.SeatPreference_SEAT_PREFERENCE_AISLE:
+			if pos != (perRow-1)/2 && pos != perRow/2 {
+				return false
+			}
+		case proto.SeatPreference_SEAT_PREFERENCE_FRONT:
+			if !front {
+				return false
+			}
+		case proto.SeatPreference_SEAT_PREFERENCE_BACK:
+			if front {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *TrainServer) seatsPerRow() int {
+	if s.Conf.SeatsPerRow <= 0 {
+		return defaultSeatsPerRow
+	}
+	return s.Conf.SeatsPerRow
+}
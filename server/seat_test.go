@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func seatConfig(conf *TrainConfig) {
+	conf.SeatCount = 8
+	conf.SeatsPerRow = 4
+	conf.QuietSections = []string{section2}
+}
+
+func TestTrainServer_seatMatches(t *testing.T) {
+	s := newTestServer(t, seatConfig)
+	window := proto.SeatPreference_SEAT_PREFERENCE_WINDOW
+	aisle := proto.SeatPreference_SEAT_PREFERENCE_AISLE
+	front := proto.SeatPreference_SEAT_PREFERENCE_FRONT
+	back := proto.SeatPreference_SEAT_PREFERENCE_BACK
+	tests := []struct {
+		preferences []proto.SeatPreference
+		seats       []int32
+	}{
+		{nil, []int32{0, 1, 2, 3, 4, 5, 6, 7}},
+		{[]proto.SeatPreference{window}, []int32{0, 3, 4, 7}},
+		{[]proto.SeatPreference{aisle}, []int32{1, 2, 5, 6}},
+		{[]proto.SeatPreference{front}, []int32{0, 1, 2, 3}},
+		{[]proto.SeatPreference{back, aisle}, []int32{5, 6}},
+		{[]proto.SeatPreference{window, aisle}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.preferences), func(t *testing.T) {
+			var seats []int32
+			for seat := int32(0); seat < 8; seat++ {
+				if s.seatMatches(section1, seat, tt.preferences) {
+					seats = append(seats, seat)
+				}
+			}
+			assert.Equal(t, tt.seats, seats)
+		})
+	}
+}
+
+func TestTrainServer_PurchaseSeatRequest(t *testing.T) {
+	s := newTestServer(t, seatConfig)
+	purchase := func(email string, section string, seat *int32, preferences ...proto.SeatPreference) (*proto.PurchaseResponse, error) {
+		return s.PurchaseTicket(context.Background(), &proto.PurchaseRequest{
+			User:        &proto.User{FirstName: firstName1, LastName: lastName1, Email: email},
+			From:        from1,
+			To:          to1,
+			Price:       price1,
+			Section:     section,
+			Seat:        seat,
+			Preferences: preferences,
+		})
+	}
+	seat := func(n int32) *int32 { return &n }
+
+	t.Run("explicit seat", func(t *testing.T) {
+		resp, err := purchase(email1, section1, seat(5))
+		assert.NoError(t, err)
+		assert.Equal(t, section1, resp.Section)
+		assert.Equal(t, int32(5), resp.Seat)
+	})
+
+	t.Run("explicit seat taken", func(t *testing.T) {
+		_, err := purchase(email2, section1, seat(5))
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		assert.Empty(t, s.Store.ListByEmail(email2))
+	})
+
+	t.Run("seat without section", func(t *testing.T) {
+		_, err := purchase(email2, "", seat(1))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("invalid section", func(t *testing.T) {
+		_, err := purchase(email2, "Z", nil)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("window at the back", func(t *testing.T) {
+		resp, err := purchase(email2, section1, nil,
+			proto.SeatPreference_SEAT_PREFERENCE_WINDOW, proto.SeatPreference_SEAT_PREFERENCE_BACK)
+		assert.NoError(t, err)
+		assert.Contains(t, []int32{4, 7}, resp.Seat)
+	})
+
+	t.Run("quiet section", func(t *testing.T) {
+		resp, err := purchase(email3, "", nil, proto.SeatPreference_SEAT_PREFERENCE_QUIET)
+		assert.NoError(t, err)
+		assert.Equal(t, section2, resp.Section)
+		_, err = purchase(email4, section1, nil, proto.SeatPreference_SEAT_PREFERENCE_QUIET)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	t.Run("preference cannot be honoured", func(t *testing.T) {
+		// Both back window seats of section 1 are gone after this purchase.
+		_, err := purchase(email4, section1, nil,
+			proto.SeatPreference_SEAT_PREFERENCE_WINDOW, proto.SeatPreference_SEAT_PREFERENCE_BACK)
+		assert.NoError(t, err)
+		_, err = purchase(email5, section1, nil,
+			proto.SeatPreference_SEAT_PREFERENCE_WINDOW, proto.SeatPreference_SEAT_PREFERENCE_BACK)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		assert.Empty(t, s.Store.ListByEmail(email5))
+	})
+}
+
+func TestTrainServer_GetSeatMap(t *testing.T) {
+	s := newTestServer(t, seatConfig)
+	seat := int32(2)
+	_, err := s.PurchaseTicket(context.Background(), &proto.PurchaseRequest{
+		User:    &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2},
+		From:    from1,
+		To:      to1,
+		Price:   price1,
+		Section: section2,
+		Seat:    &seat,
+	})
+	assert.NoError(t, err)
+	req := &proto.SeatMapRequest{Route: 0, Section: section2}
+
+	t.Run("anonymous", func(t *testing.T) {
+		resp, err := s.GetSeatMap(context.Background(), req)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(4), resp.SeatsPerRow)
+		assert.True(t, resp.Quiet)
+		assert.Len(t, resp.Seats, 8)
+		for i, status := range resp.Seats {
+			assert.Equal(t, int32(i), status.Seat)
+			assert.Nil(t, status.User)
+			if i == 2 {
+				assert.Equal(t, proto.SeatState_SEAT_STATE_BOOKED, status.State)
+			} else {
+				assert.Equal(t, proto.SeatState_SEAT_STATE_FREE, status.State)
+			}
+		}
+	})
+
+	t.Run("read capability hides passengers", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), "jwt", JwtClaims{
+			UserID:         email4,
+			Capabilities:   []string{CapRead},
+			StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		})
+		resp, err := s.GetSeatMap(ctx, req)
+		assert.NoError(t, err)
+		assert.Nil(t, resp.Seats[2].User)
+	})
+
+	t.Run("admin sees passengers", func(t *testing.T) {
+		resp, err := s.GetSeatMap(authContext(t, email1), req)
+		assert.NoError(t, err)
+		assert.Equal(t, email2, resp.Seats[2].User.Email)
+	})
+
+	t.Run("invalid section", func(t *testing.T) {
+		_, err := s.GetSeatMap(context.Background(), &proto.SeatMapRequest{Route: 0, Section: "Z"})
+		assert.EqualError(t, err, "invalid section: Z")
+	})
+
+	t.Run("invalid departure", func(t *testing.T) {
+		_, err := s.GetSeatMap(context.Background(), &proto.SeatMapRequest{Route: 0, Section: section1, DepartsAt: 1})
+		assert.EqualError(t, err, "route has no timetable")
+		_, err = s.GetSeatMap(context.Background(), &proto.SeatMapRequest{Route: 7, Section: section1})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestTrainServer_PurchaseSegment(t *testing.T) {
+	s := newTestServer(t, func(conf *TrainConfig) {
+		conf.SeatCount = 1
+		conf.Sections = []SectionConfig{{Name: section1}}
+		conf.Routes[0] = RouteConfig{From: "London", To: "Paris", Stops: []string{"Ashford", "Lille"}, Price: 3000}
+	})
+	ctx := context.Background()
+	buy := func(email, from, to string) (*proto.PurchaseResponse, error) {
+		req := holdRequest(email)
+		req.From, req.To, req.Price = from, to, 3000
+		return s.PurchaseTicket(ctx, req)
+	}
+
+	first, err := buy(email1, "London", "Lille")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2000), first.AmountCharged)
+	// The only seat is sold again for the rest of the route.
+	second, err := buy(email2, "Lille", "Paris")
+	assert.NoError(t, err)
+	assert.Equal(t, first.Seat, second.Seat)
+	assert.Equal(t, int32(1000), second.AmountCharged)
+	_, err = buy(email3, "Ashford", "Paris")
+	assert.Error(t, err)
+
+	b, _ := s.Store.Lookup(second.BookingId)
+	receipt := s.receipt(b)
+	assert.Equal(t, "Lille", receipt.From)
+	assert.Equal(t, "Paris", receipt.To)
+
+	seats, err := s.GetSeatMap(ctx, &proto.SeatMapRequest{Route: 0, Section: section1, From: "London", To: "Ashford"})
+	assert.NoError(t, err)
+	assert.Equal(t, proto.SeatState_SEAT_STATE_BOOKED, seats.Seats[0].State)
+	_, err = s.GetSeatMap(ctx, &proto.SeatMapRequest{Route: 0, Section: section1, From: "Paris", To: "Lille"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -7,122 +7,125 @@ import (
 	proto "github.com/playbody/train-ticket-service/proto"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"math/rand"
-	"strings"
+	"time"
 )
 
 type TrainServer struct {
 	proto.UnimplementedTrainServiceServer
-	Conf        *TrainConfig
-	logger      logr.Logger
-	receipts    []map[string][]*proto.User // path, section, seat, userinfo
-	flagRoute   map[string]int32
-	flagSection map[string]string
-	flagSeat    map[string]int32
+	Conf     *TrainConfig
+	Store    BookingStore
+	Payments PaymentProcessor
+	FX       RateProvider
+	logger   logr.Logger
+	quotes   quoteBook
+	logins   loginGuard
 }
 
 func (s *TrainServer) InitServer() {
 	s.logger = logr.Logger{}
-	s.flagSection = map[string]string{}
-	s.flagSeat = map[string]int32{}
-	s.flagRoute = map[string]int32{}
-	s.receipts = make([]map[string][]*proto.User, len(s.Conf.Routes))
-	for routeIndex := range s.Conf.Routes {
-		s.receipts[routeIndex] = make(map[string][]*proto.User)
-		for _, sec := range s.Conf.Sections {
-			s.receipts[routeIndex][sec] = make([]*proto.User, s.Conf.SeatCount)
-		}
+	if s.Store == nil {
+		s.Store = NewMemoryStore(s.Conf)
 	}
-}
-
-func (s *TrainServer) AuthUser(_ context.Context, req *proto.AuthRequest) (*proto.AuthResponse, error) {
-	var capabilities []string
-	for _, user := range SConfig.RoleUsers {
-		if user.Email == req.Email {
-			capabilities = user.Capabilities
-			break
-		}
+	if s.Payments == nil {
+		s.Payments = NewFakeGateway()
 	}
-	token, err := GenerateJWT(req.Email, capabilities)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Cannot generate jwt token %v", err)
+	if s.FX == nil {
+		s.FX = NewStaticRates(s.Conf.FX.Rates)
 	}
-	return &proto.AuthResponse{Token: token}, nil
+}
+
+// AuthUser is kept for older clients and behaves like Login.
+func (s *TrainServer) AuthUser(ctx context.Context, req *proto.AuthRequest) (*proto.AuthResponse, error) {
+	return s.Login(ctx, req)
 }
 
 func (s *TrainServer) GetAllRoutes(_ context.Context, _ *proto.RouteRequest) (*proto.RouteResponse, error) {
 	resp := &proto.RouteResponse{
 		Routes: make([]*proto.Route, 0),
 	}
-	for _, route := range s.Conf.Routes {
-		resp.Routes = append(resp.Routes, &proto.Route{
-			From:  route.From,
-			To:    route.To,
-			Price: route.Price,
-		})
+	now := time.Now()
+	for index, route := range s.Conf.Routes {
+		r := &proto.Route{
+			From:       route.From,
+			To:         route.To,
+			Price:      route.Price,
+			Departures: make([]*proto.Departure, 0),
+			Sections:   s.sectionFares(s.classFares(index, Segment{})),
+			Currency:   s.routeCurrency(int32(index)),
+			Stops:      route.Stops,
+		}
+		if len(route.Departures) == 0 {
+			r.Available = s.availableSeats(Trip{Route: int32(index)}, Segment{})
+		}
+		for _, at := range s.upcomingDepartures(route, now) {
+			trip := Trip{Route: int32(index), DepartsAt: at.Unix()}
+			r.Departures = append(r.Departures, &proto.Departure{
+				DepartsAt: at.Unix(),
+				Available: s.availableSeats(trip, Segment{}),
+				Fares:     s.sectionFares(s.fares(trip, Segment{}, now)),
+			})
+		}
+		resp.Routes = append(resp.Routes, r)
 	}
 	s.logger.Info("GetAllRoutes", resp)
 	return resp, nil
 }
 
-func (s *TrainServer) PurchaseTicket(_ context.Context, req *proto.PurchaseRequest) (*proto.PurchaseResponse, error) {
-	if _, err := isValidUser(req.User); err != nil {
+func (s *TrainServer) PurchaseTicket(ctx context.Context, req *proto.PurchaseRequest) (*proto.PurchaseResponse, error) {
+	trip, seats, err := s.checkPurchase(ctx, req)
+	if err != nil {
 		return nil, err
 	}
-	if ok := s.isAlreadyPurchased(req.User.Email); ok {
-		return nil, fmt.Errorf("already purchased")
-	}
-	if index, err := s.getRouteIndex(req.From, req.To, req.Price); err == nil {
-		if sec, seat, err := s.findEmptySeat(index); err == nil {
-			s.receipts[index][sec][seat] = req.User
-			s.flagSeat[req.User.Email] = seat
-			s.flagSection[req.User.Email] = sec
-			s.flagRoute[req.User.Email] = int32(index)
-
-			resp := &proto.PurchaseResponse{
-				Section: sec,
-				Seat:    seat,
-				Route:   int32(index),
-				Message: "Ticket purchased successfully",
-			}
-			s.logger.Info("PurchaseTicket", resp)
-			return resp, nil
-		} else {
+	booking := s.newBooking(newBookingID(), trip, req.User)
+	booking, err = s.purchase(ctx, booking.ID, booking, seats)
+	if err != nil {
+		return nil, err
+	}
+	resp := s.purchaseResponse(booking)
+	s.logger.Info("PurchaseTicket", resp)
+	return resp, nil
+}
+
+func (s *TrainServer) GetReceipt(_ context.Context, req *proto.ReceiptRequest) (*proto.ReceiptResponse, error) {
+	if req.BookingId != "" {
+		if err := s.checkWaitlisted(req.Email, req.BookingId); err != nil {
 			return nil, err
 		}
-	} else {
+	}
+	booking, err := s.findBooking(req.Email, req.BookingId)
+	if err != nil {
 		return nil, err
 	}
+	resp := s.receipt(booking)
+	s.logger.Info("GetReceipt", resp)
+	return resp, nil
 }
 
-func (s *TrainServer) GetReceipt(ctx context.Context, req *proto.ReceiptRequest) (*proto.ReceiptResponse, error) {
-	if err := AuthCheck(ctx, req.Email, CapAdmin, CapRead); err != nil {
-		return nil, err
+func (s *TrainServer) ListBookings(_ context.Context, req *proto.ListBookingsRequest) (*proto.ListBookingsResponse, error) {
+	resp := &proto.ListBookingsResponse{
+		Bookings: make([]*proto.ReceiptResponse, 0),
 	}
-	if resp := s.getReceipt(req.Email); resp == nil {
-		return nil, fmt.Errorf("no receipt found for email: %v", req.Email)
-	} else {
-		s.logger.Info("GetReceipt", resp)
-		return resp, nil
+	for _, booking := range s.Store.ListByEmail(req.Email) {
+		resp.Bookings = append(resp.Bookings, s.receipt(booking))
 	}
+	s.logger.Info("ListBookings", resp)
+	return resp, nil
 }
 
-func (s *TrainServer) GetUsersBySection(ctx context.Context, req *proto.SectionRequest) (*proto.SectionResponse, error) {
-	if err := AuthCheck(ctx, "", CapAdmin, CapRead); err != nil {
+func (s *TrainServer) GetUsersBySection(_ context.Context, req *proto.SectionRequest) (*proto.SectionResponse, error) {
+	seats := make([]*proto.Seat, 0)
+	bookings, err := s.Store.ListPassengers(Trip{Route: req.Route, DepartsAt: req.DepartsAt}, req.Section)
+	if err != nil {
 		return nil, err
 	}
-	seats := make([]*proto.Seat, 0)
-	users, ok := s.receipts[req.Route][req.Section]
-	if !ok {
-		return nil, fmt.Errorf("invalid section: %s", req.Section)
-	}
-	for i, user := range users {
-		if user != nil {
-			seats = append(seats, &proto.Seat{
-				User: user,
-				Seat: int32(i),
-			})
-		}
+	for _, b := range bookings {
+		route := s.Conf.Routes[b.Route]
+		seats = append(seats, &proto.Seat{
+			User: b.User,
+			Seat: b.Seat,
+			From: route.Boarding(b.Segment),
+			To:   route.Alighting(b.Segment),
+		})
 	}
 	resp := &proto.SectionResponse{Seats: seats}
 	s.logger.Info("GetUsersBySection", resp)
@@ -130,47 +133,58 @@ func (s *TrainServer) GetUsersBySection(ctx context.Context, req *proto.SectionR
 }
 
 func (s *TrainServer) RemoveUser(ctx context.Context, req *proto.RemoveUserRequest) (*proto.RemoveUserResponse, error) {
-	if err := AuthCheck(ctx, req.Email, CapAdmin, CapWrite); err != nil {
+	booking, err := s.findBooking(req.Email, req.BookingId)
+	if err != nil {
+		if resp, ok := s.leaveWaitlist(req.Email, req.BookingId); ok {
+			return resp, nil
+		}
 		return nil, err
 	}
-	section, ok := s.flagSection[req.Email]
-	if !ok {
-		return nil, fmt.Errorf("no user found for email: %v", req.Email)
+	now := time.Now()
+	refund := Refund{
+		ID:        newRefundID(),
+		BookingID: booking.ID,
+		User:      booking.User,
+		Price:     s.bookingPrice(booking),
+		Amount:    s.refundAmount(booking, now),
+		CreatedAt: now.Unix(),
 	}
-	seat := s.flagSeat[req.Email]
-	route := s.flagRoute[req.Email]
-	s.receipts[route][section][seat] = nil
-	delete(s.flagSeat, req.Email)
-	delete(s.flagSection, req.Email)
-	delete(s.flagRoute, req.Email)
+	if booking, err = s.Store.Cancel(booking.ID, refund); err != nil {
+		return nil, err
+	}
+	if booking.HeldUntil != 0 {
+		s.voidPayment(booking.PaymentID)
+	} else if refund.Amount > 0 && booking.PaymentID != "" {
+		// A booking is cancelled only once, so keying the refund by its id
+		// keeps a retried refund from paying out twice.
+		if _, err := s.Payments.Refund(ctx, booking.PaymentID, s.paidShare(booking, refund.Amount), refundKey(booking.ID)); err != nil {
+			return nil, status.Errorf(codes.Internal, "refund %v recorded but not paid out: %v", refund.ID, err)
+		}
+	}
+	s.promoteWaitlist(booking.Trip)
 	resp := &proto.RemoveUserResponse{
-		Route:   route,
-		Seat:    seat,
-		Section: section,
-		Message: "User removed successfully",
+		BookingId:    booking.ID,
+		Route:        booking.Route,
+		DepartsAt:    booking.DepartsAt,
+		Seat:         booking.Seat,
+		Section:      booking.Section,
+		RefundAmount: refund.Amount,
+		RefundId:     refund.ID,
+		Currency:     s.routeCurrency(booking.Route),
+		Message:      "User removed successfully",
 	}
 	s.logger.Info("RemoveUser", resp)
 	return resp, nil
 }
 
-func (s *TrainServer) ModifySeat(ctx context.Context, req *proto.ModifySeatRequest) (*proto.ModifySeatResponse, error) {
-	if err := AuthCheck(ctx, req.Email, CapAdmin, CapWrite); err != nil {
+func (s *TrainServer) ModifySeat(_ context.Context, req *proto.ModifySeatRequest) (*proto.ModifySeatResponse, error) {
+	booking, err := s.findBooking(req.Email, req.BookingId)
+	if err != nil {
 		return nil, err
 	}
-	section, ok := s.flagSection[req.Email]
-	if !ok {
-		return nil, fmt.Errorf("no user found for email: %v", req.Email)
-	}
-	route := s.flagRoute[req.Email]
-	oldSeat := s.flagSeat[req.Email]
-	if s.receipts[route][section][req.Seat] != nil {
-		return nil, fmt.Errorf("new seat is already occupied")
+	if _, err := s.Store.Move(booking.ID, req.Seat); err != nil {
+		return nil, err
 	}
-	user := s.receipts[route][section][oldSeat]
-
-	s.receipts[route][section][oldSeat] = nil
-	s.receipts[route][section][req.Seat] = user
-	s.flagSeat[req.Email] = req.Seat
 
 	resp := &proto.ModifySeatResponse{
 		Message: "Seat modified successfully",
@@ -179,59 +193,163 @@ func (s *TrainServer) ModifySeat(ctx context.Context, req *proto.ModifySeatReque
 	return resp, nil
 }
 
-func (s *TrainServer) getRouteIndex(from string, to string, price int32) (int, error) {
+// getRouteIndex finds a route calling at from and then at to with a bookable
+// departure at departsAt, and the segment between them. Several routes may
+// serve the same stations, so the first one whose timetable has the departure
+// is taken; if none has, the departure is invalid for the first of them.
+func (s *TrainServer) getRouteIndex(from string, to string, departsAt int64) (int, Segment, error) {
+	var invalid error
 	for index, data := range s.Conf.Routes {
-		if data.From == from && data.To == to {
-			if data.Price <= price {
-				return index, nil
-			} else {
-				return index, fmt.Errorf("you must pay more money")
-			}
+		seg, ok := data.Segment(from, to)
+		if !ok {
+			continue
+		}
+		err := s.checkDeparture(index, departsAt)
+		if err == nil {
+			return index, seg, nil
 		}
+		if invalid == nil {
+			invalid = err
+		}
+	}
+	if invalid != nil {
+		return -1, Segment{}, invalid
 	}
-	return -1, fmt.Errorf("cannot find route")
+	return -1, Segment{}, fmt.Errorf("cannot find route")
 }
 
-func (s *TrainServer) findEmptySeat(routeIndex int) (string, int32, error) {
-	sectionCount := len(s.Conf.Sections)
-	x := rand.Intn(sectionCount)
-	for i := 0; i < sectionCount; i++ {
-		sec := s.Conf.Sections[(i+x)%sectionCount]
-		y := rand.Intn(s.Conf.SeatCount)
-		for j := 0; j < s.Conf.SeatCount; j++ {
-			number := (j + y) % s.Conf.SeatCount
-			if s.receipts[routeIndex][sec][number] == nil || s.receipts[routeIndex][sec][number].Email == "" {
-				return sec, int32(number), nil
-			}
+// checkPurchase validates a purchase request and returns the trip and seat it
+// asks for, limited to the sections the price paid covers after concessions,
+// any voucher discount, fees and tax. The price is in req.currency, the route currency
+// when empty.
+func (s *TrainServer) checkPurchase(ctx context.Context, req *proto.PurchaseRequest) (Trip, seatRequest, error) {
+	if _, err := isValidUser(req.User); err != nil {
+		return Trip{}, seatRequest{}, err
+	}
+	if err := checkTravellingAlone(req.User); err != nil {
+		return Trip{}, seatRequest{}, err
+	}
+	index, seg, err := s.getRouteIndex(req.From, req.To, req.DepartsAt)
+	if err != nil {
+		return Trip{}, seatRequest{}, err
+	}
+	seats := newSeatRequest(req)
+	seats.segment = seg
+	if err := s.validateSeatRequest(seats); err != nil {
+		return Trip{}, seatRequest{}, err
+	}
+	now := time.Now()
+	trip := Trip{Route: int32(index), DepartsAt: req.DepartsAt}
+	fares, err := s.tripFares(trip, seg, req.QuoteId, now)
+	if err != nil {
+		return Trip{}, seatRequest{}, err
+	}
+	fares = s.concessionFares(fares, req.User.PassengerType)
+	if req.VoucherCode != "" {
+		v, err := s.checkVoucher(ctx, req.VoucherCode, trip.Route, now)
+		if err != nil {
+			return Trip{}, seatRequest{}, err
 		}
+		seats.voucher = v.Code
+		fares, seats.discounts = applyVoucher(fares, v)
+	}
+	fares, seats.charges = s.addCharges(trip.Route, fares)
+	if seats.exchange, err = s.exchangeFor(ctx, trip.Route, req.Currency); err != nil {
+		return Trip{}, seatRequest{}, err
+	}
+	if seats.fares, err = seats.exchange.affordable(fares, req.Price); err != nil {
+		return Trip{}, seatRequest{}, err
+	}
+	if _, ok := seats.fares[seats.section]; seats.section != "" && !ok {
+		return Trip{}, seatRequest{}, errNotEnoughMoney
 	}
-	return "", -1, fmt.Errorf("cannot find empty seat")
+	seats.paid = req.Price
+	if ok := s.isAlreadyPurchased(req.User.Email, trip); ok {
+		return Trip{}, seatRequest{}, errAlreadyPurchased
+	}
+	return trip, seats, nil
 }
 
-func (s *TrainServer) isAlreadyPurchased(email string) bool {
-	value, is := s.flagSeat[email]
-	if is == true && value != -1 {
-		return true
+// newBooking starts a booking of user on trip at the route price; the seat and
+// the fare of its section are filled in by the caller.
+func (s *TrainServer) newBooking(id string, trip Trip, user *proto.User) Booking {
+	return Booking{
+		ID:    id,
+		Trip:  trip,
+		User:  user,
+		Price: s.Conf.Routes[trip.Route].Price,
+	}
+}
+
+func (s *TrainServer) isAlreadyPurchased(email string, trip Trip) bool {
+	for _, b := range s.Store.ListByEmail(email) {
+		if b.Trip == trip {
+			return true
+		}
 	}
 	return false
 }
 
-func (s *TrainServer) getReceipt(email string) *proto.ReceiptResponse {
-	seat, is := s.flagSeat[email]
-	if is && seat >= 0 {
-		sec, is := s.flagSection[email]
-		if is && len(strings.TrimSpace(sec)) > 0 {
-			route := s.flagRoute[email]
-			return &proto.ReceiptResponse{
-				User:    s.receipts[route][sec][seat],
-				From:    s.Conf.Routes[route].From,
-				To:      s.Conf.Routes[route].To,
-				Price:   s.Conf.Routes[route].Price,
-				Section: sec,
-				Seat:    seat,
-			}
+// findBooking resolves the booking a request refers to; PolicyMiddleware has
+// checked the caller may access it. Without a booking id the user must hold
+// exactly one booking.
+func (s *TrainServer) findBooking(email string, bookingID string) (Booking, error) {
+	if bookingID == "" {
+		bookings := s.Store.ListByEmail(email)
+		switch len(bookings) {
+		case 0:
+			return Booking{}, fmt.Errorf("no booking found for email: %v", email)
+		case 1:
+			return bookings[0], nil
+		default:
+			return Booking{}, fmt.Errorf("user has %d bookings, booking id must be provided", len(bookings))
 		}
-		return nil
 	}
-	return nil
+	b, ok := s.Store.Lookup(bookingID)
+	if !ok || (email != "" && b.User.Email != email) {
+		return Booking{}, fmt.Errorf("no booking found: %v", bookingID)
+	}
+	return b, nil
+}
+
+func (s *TrainServer) purchaseResponse(b Booking) *proto.PurchaseResponse {
+	return &proto.PurchaseResponse{
+		BookingId:     b.ID,
+		Section:       b.Section,
+		Seat:          b.Seat,
+		Route:         b.Route,
+		DepartsAt:     b.DepartsAt,
+		AmountCharged: b.Price,
+		Change:        b.Change,
+		FareClass:     s.fareClass(b.Section),
+		OriginalPrice: s.bookingCharges(b).Fare + b.Discount,
+		Discount:      b.Discount,
+		Currency:      s.routeCurrency(b.Route),
+		Paid:          s.paidMoney(b),
+		Breakdown:     breakdownResponse(s.bookingCharges(b)),
+		Message:       "Ticket purchased successfully",
+	}
+}
+
+func (s *TrainServer) receipt(b Booking) *proto.ReceiptResponse {
+	return &proto.ReceiptResponse{
+		BookingId:     b.ID,
+		User:          b.User,
+		From:          s.Conf.Routes[b.Route].Boarding(b.Segment),
+		To:            s.Conf.Routes[b.Route].Alighting(b.Segment),
+		Price:         s.bookingPrice(b),
+		Section:       b.Section,
+		Seat:          b.Seat,
+		DepartsAt:     b.DepartsAt,
+		HeldUntil:     b.HeldUntil,
+		AmountCharged: s.bookingPrice(b),
+		Change:        b.Change,
+		FareClass:     s.fareClass(b.Section),
+		OriginalPrice: s.bookingCharges(b).Fare + b.Discount,
+		Discount:      b.Discount,
+		VoucherCode:   b.Voucher,
+		Currency:      s.routeCurrency(b.Route),
+		Paid:          s.paidMoney(b),
+		Breakdown:     breakdownResponse(s.bookingCharges(b)),
+	}
 }
@@ -3,11 +3,14 @@ package server
 import (
 	"context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"testing"
 
 	proto "github.com/playbody/train-ticket-service/proto"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -35,18 +38,33 @@ const (
 	from2      = "Osaka"
 	to2        = "London"
 	price2     = int32(200)
+	password1  = "correct horse"
+	bookingID1 = "BK-0000000000000001"
+	bookingID2 = "BK-0000000000000002"
+	bookingID3 = "BK-0000000000000003"
 )
 
 var server *TrainServer
 
 func TestMain(m *testing.M) {
+	user1 := &proto.User{
+		FirstName: firstName1,
+		LastName:  lastName1,
+		Email:     email1,
+	}
+	user2 := &proto.User{
+		FirstName: firstName2,
+		LastName:  lastName2,
+		Email:     email2,
+	}
+	user3 := &proto.User{
+		FirstName: firstName3,
+		LastName:  lastName3,
+		Email:     email3,
+	}
 	server = &TrainServer{
 		Conf: &TrainConfig{
-			Routes: []struct {
-				From  string `yaml:"from,omitempty"`
-				To    string `yaml:"to,omitempty"`
-				Price int32  `yaml:"price,omitempty"`
-			}{
+			Routes: []RouteConfig{
 				{
 					From:  from1,
 					To:    to1,
@@ -58,58 +76,52 @@ func TestMain(m *testing.M) {
 					Price: price2,
 				},
 			},
-			Sections:  []string{section1, section2},
+			Sections:  []SectionConfig{{Name: section1}, {Name: section2}},
 			SeatCount: seatCount,
 		},
-		receipts: []map[string][]*proto.User{
-			{
-				section1: []*proto.User{
-					{
-						FirstName: firstName1,
-						LastName:  lastName1,
-						Email:     email1,
-					},
-					{
-						FirstName: firstName2,
-						LastName:  lastName2,
-						Email:     email2,
-					},
+		Store: &MemoryStore{
+			receipts: map[Trip]map[string][][]string{
+				{Route: 0}: {
+					section1: {{bookingID1}, {bookingID2}},
+					section2: {{bookingID3}, nil},
 				},
-				section2: []*proto.User{
-					{
-						FirstName: firstName3,
-						LastName:  lastName3,
-						Email:     email3,
-					},
-					{},
+				{Route: 1}: {
+					section1: {nil, nil},
+					section2: {nil, nil},
 				},
 			},
-			{
-				section1: []*proto.User{{}, {}},
-				section2: []*proto.User{{}, {}},
+			bookings: map[string]Booking{
+				bookingID1: {ID: bookingID1, Trip: Trip{Route: 0}, Section: section1, Seat: 0, User: user1},
+				bookingID2: {ID: bookingID2, Trip: Trip{Route: 0}, Section: section1, Seat: 1, User: user2},
+				bookingID3: {ID: bookingID3, Trip: Trip{Route: 0}, Section: section2, Seat: 0, User: user3},
 			},
+			byEmail: map[string][]string{
+				email1: {bookingID1},
+				email2: {bookingID2},
+				email3: {bookingID3},
+			},
+			waiting:  map[Trip][]WaitlistEntry{},
+			waitIDs:  map[string]Trip{},
+			refunds:  map[string]Refund{},
+			vouchers: map[string]Voucher{},
+			uses:     map[string]int{},
+			accounts: map[string]Account{},
+			revoked:  map[string]int64{},
 		},
-		flagSection: map[string]string{
-			email1: section1,
-			email2: section1,
-			email3: section2,
-		},
-		flagRoute: map[string]int32{
-			email1: 0,
-			email2: 0,
-			email3: 0,
-		},
-		flagSeat: map[string]int32{
-			email1: 0,
-			email2: 1,
-			email3: 0,
-		},
+		Payments: NewFakeGateway(),
+		FX:       NewStaticRates(nil),
 	}
 	SConfig.Auth.Expire = 3600
 	SConfig.Auth.SecretKey = "abc"
+	SConfig.Auth.BcryptCost = bcrypt.MinCost
+	hash, err := bcrypt.GenerateFromPassword([]byte(password1), bcrypt.MinCost)
+	if err != nil {
+		panic(err)
+	}
 	SConfig.RoleUsers = []RoleUser{
 		{
-			Email: email1,
+			Email:        email1,
+			PasswordHash: string(hash),
 			Capabilities: []string{
 				"admin",
 				"read",
@@ -185,6 +197,20 @@ func TestTrainServer_PurchaseTicket(t *testing.T) {
 		assert.EqualError(t, err, "first name must not be empty")
 	})
 
+	t.Run("missing user", func(t *testing.T) {
+		ctx := context.Background()
+		_, err := server.PurchaseTicket(ctx, &proto.PurchaseRequest{From: from1, To: to1, Price: price1})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = server.HoldSeat(ctx, &proto.PurchaseRequest{From: from1, To: to1, Price: price1})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = server.PurchaseGroup(ctx, &proto.PurchaseGroupRequest{Users: []*proto.User{nil}, From: from1, To: to1, Price: price1})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = server.JoinWaitlist(ctx, &proto.JoinWaitlistRequest{From: from1, To: to1, Price: price1})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = server.PurchaseJourney(ctx, &proto.PurchaseJourneyRequest{Legs: []*proto.LegRequest{{From: from1, To: to1}}, Price: price1})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
 	t.Run("invalid route", func(t *testing.T) {
 		req := &proto.PurchaseRequest{
 			User: &proto.User{
@@ -221,7 +247,8 @@ func TestTrainServer_PurchaseTicket(t *testing.T) {
 func TestTrainServer_GetReceipt(t *testing.T) {
 	t.Run("get receipt success", func(t *testing.T) {
 		req1 := &proto.AuthRequest{
-			Email: email1,
+			Email:    email1,
+			Password: password1,
 		}
 		resp1, err := server.AuthUser(context.Background(), req1)
 		assert.Nil(t, err)
@@ -236,9 +263,114 @@ func TestTrainServer_GetReceipt(t *testing.T) {
 		req2 := &proto.ReceiptRequest{
 			Email: email1,
 		}
-		resp, err := ParseJWTMiddleware(ctx, req2, &grpc.UnaryServerInfo{}, handler)
+		resp, err := server.ParseJWTMiddleware(ctx, req2, &grpc.UnaryServerInfo{}, handler)
 
 		assert.NotNil(t, resp)
 		assert.Nil(t, err)
 	})
 }
+
+// authContext returns an incoming context carrying the parsed token of email,
+// as seen by handlers behind ParseJWTMiddleware.
+func authContext(t *testing.T, email string) context.Context {
+	user, _ := roleUser(email)
+	token, err := GenerateJWT(email, user.Capabilities)
+	assert.NoError(t, err)
+	return tokenContext(t, server, token)
+}
+
+// newTestServer returns a server initialised with newTestStoreConfig, as
+// changed by configure when given.
+func newTestServer(t *testing.T, configure func(conf *TrainConfig)) *TrainServer {
+	t.Helper()
+	conf := newTestStoreConfig()
+	if configure != nil {
+		configure(conf)
+	}
+	s := &TrainServer{Conf: conf}
+	s.InitServer()
+	return s
+}
+
+// tokenContext returns an incoming context carrying token once parsed by the
+// ParseJWTMiddleware of s.
+func tokenContext(t *testing.T, s *TrainServer, token string) context.Context {
+	md := metadata.Pairs("Authorization", token)
+	var parsed context.Context
+	_, err := s.ParseJWTMiddleware(metadata.NewIncomingContext(context.Background(), md), nil, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, _ any) (any, error) {
+			parsed = ctx
+			return nil, nil
+		})
+	assert.NoError(t, err)
+	return parsed
+}
+
+// authorize returns how the PolicyMiddleware of s answers a call of method
+// with ctx and req, nil when it lets the call through.
+func authorize(ctx context.Context, s *TrainServer, method string, req any) error {
+	_, err := s.PolicyMiddleware(ctx, req, &grpc.UnaryServerInfo{FullMethod: "/train.TrainService/" + method},
+		func(context.Context, any) (any, error) {
+			return nil, nil
+		})
+	return err
+}
+
+func TestTrainServer_MultipleBookings(t *testing.T) {
+	server := newTestServer(t, func(conf *TrainConfig) {
+		conf.Routes = append(conf.Routes, RouteConfig{From: from2, To: to2, Price: price2})
+	})
+	user := &proto.User{FirstName: firstName5, LastName: lastName5, Email: email5}
+	outbound, err := server.PurchaseTicket(context.Background(), &proto.PurchaseRequest{User: user, From: from1, To: to1, Price: price1})
+	assert.NoError(t, err)
+	inbound, err := server.PurchaseTicket(context.Background(), &proto.PurchaseRequest{User: user, From: from2, To: to2, Price: price2})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, outbound.BookingId)
+	assert.NotEqual(t, outbound.BookingId, inbound.BookingId)
+
+	ctx := authContext(t, email5)
+
+	t.Run("list bookings", func(t *testing.T) {
+		resp, err := server.ListBookings(ctx, &proto.ListBookingsRequest{Email: email5})
+		assert.NoError(t, err)
+		assert.Len(t, resp.Bookings, 2)
+		assert.Equal(t, outbound.BookingId, resp.Bookings[0].BookingId)
+		assert.Equal(t, inbound.BookingId, resp.Bookings[1].BookingId)
+		assert.Equal(t, from2, resp.Bookings[1].From)
+	})
+
+	t.Run("receipt needs booking id", func(t *testing.T) {
+		_, err := server.GetReceipt(ctx, &proto.ReceiptRequest{Email: email5})
+		assert.EqualError(t, err, "user has 2 bookings, booking id must be provided")
+		resp, err := server.GetReceipt(ctx, &proto.ReceiptRequest{BookingId: inbound.BookingId})
+		assert.NoError(t, err)
+		assert.Equal(t, inbound.Seat, resp.Seat)
+		assert.Equal(t, to2, resp.To)
+	})
+
+	t.Run("other users booking", func(t *testing.T) {
+		err := authorize(authContext(t, email2), server, "GetReceipt", &proto.ReceiptRequest{BookingId: inbound.BookingId})
+		assert.EqualError(t, err, "rpc error: code = PermissionDenied desc = Cannot access this api because of token do not have permission.")
+		other, err := server.PurchaseTicket(context.Background(), &proto.PurchaseRequest{
+			User: &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2}, From: from1, To: to1, Price: price1,
+		})
+		assert.NoError(t, err)
+		_, err = server.GetReceipt(ctx, &proto.ReceiptRequest{Email: email5, BookingId: other.BookingId})
+		assert.EqualError(t, err, "no booking found: "+other.BookingId)
+	})
+
+	t.Run("modify and remove by booking id", func(t *testing.T) {
+		_, err := server.ModifySeat(ctx, &proto.ModifySeatRequest{BookingId: inbound.BookingId, Seat: 1 - inbound.Seat})
+		assert.NoError(t, err)
+		resp, err := server.RemoveUser(ctx, &proto.RemoveUserRequest{BookingId: inbound.BookingId})
+		assert.NoError(t, err)
+		assert.Equal(t, inbound.BookingId, resp.BookingId)
+		assert.Equal(t, 1-inbound.Seat, resp.Seat)
+
+		receipt, err := server.GetReceipt(ctx, &proto.ReceiptRequest{Email: email5})
+		assert.NoError(t, err)
+		assert.Equal(t, outbound.BookingId, receipt.BookingId)
+		_, err = server.RemoveUser(ctx, &proto.RemoveUserRequest{Email: email5})
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,902 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+)
+
+var (
+	errSeatOccupied     = errors.New("new seat is already occupied")
+	errAlreadyPurchased = errors.New("already purchased")
+	errNotHeld          = errors.New("booking is not on hold")
+	errHoldExpired      = errors.New("hold expired")
+	errAlreadyWaiting   = errors.New("already on the waitlist")
+	errVoucherExists    = errors.New("voucher already exists")
+	errVoucherUsedUp    = errors.New("voucher has been used up")
+	errAccountExists    = errors.New("account already exists")
+)
+
+// Trip identifies one train: a route and the departure it runs. DepartsAt is
+// the departure in unix seconds, or zero for routes without a timetable.
+type Trip struct {
+	Route     int32 `json:"route"`
+	DepartsAt int64 `json:"departs_at,omitempty"`
+}
+
+// Segment is the part of a route a passenger travels, from the stop they board
+// at to the one they alight at, as indexes into RouteConfig.Stations. The zero
+// Alight stands for the last stop, so the zero Segment is the whole route.
+type Segment struct {
+	Board  int32 `json:"board,omitempty"`
+	Alight int32 `json:"alight,omitempty"`
+}
+
+func (g Segment) end() int32 {
+	if g.Alight == 0 {
+		return math.MaxInt32
+	}
+	return g.Alight
+}
+
+// overlaps reports whether two passengers on g and o would share the train
+// between some two stops, and so cannot have the same seat.
+func (g Segment) overlaps(o Segment) bool {
+	return g.Board < o.end() && o.Board < g.end()
+}
+
+// Booking is a single seat held by a user on a trip. A booking with HeldUntil
+// set is a temporary hold which lapses at that unix time unless confirmed. The
+// seat is only taken over the Segment of the booking, so it can be sold again
+// for the rest of the route.
+type Booking struct {
+	ID string `json:"id"`
+	Trip
+	Segment
+	Section   string      `json:"section"`
+	Seat      int32       `json:"seat"`
+	User      *proto.User `json:"user"`
+	HeldUntil int64       `json:"held_until,omitempty"`
+	// Price is the fare charged for the seat, in the route currency.
+	Price int32 `json:"price,omitempty"`
+	// Change is what the customer paid on top of Price and got back.
+	Change    int32  `json:"change,omitempty"`
+	PaymentID string `json:"payment_id,omitempty"`
+	// Voucher is the code the booking was discounted with, and Discount what
+	// it took off the fare before Price was charged.
+	Voucher  string `json:"voucher,omitempty"`
+	Discount int32  `json:"discount,omitempty"`
+	// Currency is set when the customer paid in another currency than the
+	// one of the route, and Paid is then what they were charged in it.
+	// Change is always in the currency paid.
+	Currency string `json:"currency,omitempty"`
+	Paid     int32  `json:"paid,omitempty"`
+	// Charges breaks Price down as it was computed at purchase.
+	Charges *Charges `json:"charges,omitempty"`
+}
+
+// Charges is the breakdown of the price of a booking into the fare after any
+// discount, the fees and the tax on both, in the route currency.
+type Charges struct {
+	Fare       int32  `json:"fare"`
+	Fees       []Fee  `json:"fees,omitempty"`
+	TaxCountry string `json:"tax_country,omitempty"`
+	TaxPercent int    `json:"tax_percent,omitempty"`
+	Tax        int32  `json:"tax,omitempty"`
+	Total      int32  `json:"total"`
+}
+
+type Fee struct {
+	Name   string `json:"name"`
+	Amount int32  `json:"amount"`
+}
+
+// Refund records the money returned for a cancelled booking.
+type Refund struct {
+	ID        string      `json:"id"`
+	BookingID string      `json:"booking_id"`
+	User      *proto.User `json:"user"`
+	Price     int32       `json:"price"`
+	Amount    int32       `json:"amount"`
+	CreatedAt int64       `json:"created_at"`
+}
+
+// Voucher takes Percent or a fixed Amount of Currency off the fare of bookings
+// made with its code. Uses counts its redemptions: a purchase redeems it for
+// good, even if the booking is cancelled later, while a hold only gives its
+// use back when it lapses or is released.
+type Voucher struct {
+	Code     string  `json:"code"`
+	Percent  int     `json:"percent,omitempty"`
+	Amount   int32   `json:"amount,omitempty"`
+	Currency string  `json:"currency,omitempty"`
+	Routes   []int32 `json:"routes,omitempty"`
+	// MaxUses limits the redemptions of the voucher, 0 is unlimited.
+	MaxUses   int   `json:"max_uses,omitempty"`
+	Uses      int   `json:"uses,omitempty"`
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// Account is a registered user who logs in with a password. Only the bcrypt
+// hash of the password is kept.
+type Account struct {
+	Email        string `json:"email"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	PasswordHash string `json:"password_hash"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+// Revocation is a token which must no longer be accepted. It is only kept
+// until the token would have expired anyway.
+type Revocation struct {
+	ID        string `json:"id"` // jti of the token
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// WaitlistEntry is a user waiting for a seat on a sold out trip. Its ID becomes
+// the booking id once the user is given a seat.
+type WaitlistEntry struct {
+	ID string `json:"id"`
+	Trip
+	Segment
+	User *proto.User `json:"user"`
+	// Paid is the most the user agreed to pay for the seat.
+	Paid int32 `json:"paid,omitempty"`
+}
+
+// BookingStore keeps track of which user sits where. TrainServer only talks to
+// the store through this interface so the backend can be swapped freely.
+// Implementations must be safe for concurrent use, since every RPC runs on its
+// own goroutine.
+type BookingStore interface {
+	// Reserve books the seat described by b under b.ID. The check and the
+	// write happen atomically: it fails with errSeatOccupied if the seat is
+	// taken anywhere on the segment of b and with errAlreadyPurchased if the
+	// user already holds a seat on the same trip.
+	Reserve(b Booking) error
+	// ReserveAll books every seat in bookings or none of them, with the same
+	// checks as Reserve.
+	ReserveAll(bookings []Booking) error
+	// Release frees the seat of booking id and returns the released booking.
+	Release(id string) (Booking, error)
+	// Cancel releases booking id like Release and records refund for it in
+	// the same step. Unlike Release, the voucher of a purchase stays redeemed.
+	Cancel(id string, refund Refund) (Booking, error)
+	// Move changes the seat of booking id within the same trip and section,
+	// provided the new seat is free over the segment of the booking.
+	Move(id string, seat int32) (Booking, error)
+	// Confirm turns the hold id into a regular booking. It fails with
+	// errHoldExpired if the hold lapsed at or before now.
+	Confirm(id string, now int64) (Booking, error)
+	// Unconfirm puts booking id, confirmed by Confirm, back on hold until
+	// heldUntil.
+	Unconfirm(id string, heldUntil int64) (Booking, error)
+	// ReleaseHold frees the seat of id, failing with errNotHeld unless it is
+	// still a hold.
+	ReleaseHold(id string) (Booking, error)
+	// ReleaseExpired frees every hold which lapsed at or before now and
+	// returns them.
+	ReleaseExpired(now int64) ([]Booking, error)
+	// Lookup returns booking id.
+	Lookup(id string) (Booking, bool)
+	// ListByEmail returns every booking held by email, oldest first.
+	ListByEmail(email string) []Booking
+	// ListSection returns one entry per seat of a section: a booking taking
+	// the seat somewhere on seg, or nil when it is free over all of seg.
+	ListSection(trip Trip, section string, seg Segment) ([]*Booking, error)
+	// ListPassengers returns every booking of a section, by seat and then by
+	// the stop they board at.
+	ListPassengers(trip Trip, section string) ([]Booking, error)
+	// Enqueue appends w to the waitlist of its trip and returns its 1-based
+	// position. It fails with errAlreadyWaiting if the user is already queued
+	// for the trip.
+	Enqueue(w WaitlistEntry) (int, error)
+	// Dequeue removes waitlist entry id wherever it is in the queue.
+	Dequeue(id string) (WaitlistEntry, error)
+	// Waitlist returns the queue of trip, first come first.
+	Waitlist(trip Trip) []WaitlistEntry
+	// LookupWaitlist returns waitlist entry id and its 1-based position.
+	LookupWaitlist(id string) (WaitlistEntry, int, bool)
+	// LookupRefund returns refund id.
+	LookupRefund(id string) (Refund, bool)
+	// AddVoucher makes v redeemable, failing with errVoucherExists if its
+	// code is taken. Reserve fails with errVoucherUsedUp once a booking would
+	// exceed the MaxUses of its voucher.
+	AddVoucher(v Voucher) error
+	// DeleteVoucher withdraws voucher code. Bookings keep their discount.
+	DeleteVoucher(code string) (Voucher, error)
+	// LookupVoucher returns voucher code with its redemptions so far.
+	LookupVoucher(code string) (Voucher, bool)
+	// ListVouchers returns every voucher ordered by code.
+	ListVouchers() []Voucher
+	// AddAccount registers a, failing with errAccountExists if its email is
+	// taken.
+	AddAccount(a Account) error
+	// LookupAccount returns the account of email.
+	LookupAccount(email string) (Account, bool)
+	// Revoke records r, forgetting revocations of tokens which have expired.
+	// It reports whether r.ID was revoked already, in one step, so only one of
+	// several concurrent callers revokes a token.
+	Revoke(r Revocation) (bool, error)
+	// IsRevoked reports whether the token with jti id has been revoked.
+	IsRevoked(id string) bool
+}
+
+// MemoryStore is a BookingStore which keeps everything in the current session.
+//
+// Seat inventory is created per trip on first use. Each trip/section has its
+// own lock, so purchases on different trains never wait for each other. mu
+// only guards the booking indexes and is held briefly; it is always taken
+// after the section lock. Seats are written with both held, so holding either
+// one is enough to read them. A seat lists every booking sharing it on
+// segments which do not overlap.
+type MemoryStore struct {
+	conf     *TrainConfig
+	mu       sync.RWMutex
+	locks    sync.Map                       // sectionKey -> *sync.Mutex
+	receipts map[Trip]map[string][][]string // trip, section, seat -> booking ids
+	bookings map[string]Booking             // booking id -> booking
+	byEmail  map[string][]string            // email -> booking ids
+	waiting  map[Trip][]WaitlistEntry       // trip -> queue
+	waitIDs  map[string]Trip                // waitlist id -> trip
+	refunds  map[string]Refund              // refund id -> refund
+	vouchers map[string]Voucher             // code -> voucher
+	uses     map[string]int                 // voucher code -> bookings using it
+	accounts map[string]Account             // email -> account
+	revoked  map[string]int64               // jti -> token expiry
+}
+
+type sectionKey struct {
+	trip    Trip
+	section string
+}
+
+func NewMemoryStore(conf *TrainConfig) *MemoryStore {
+	return &MemoryStore{
+		conf:     conf,
+		receipts: map[Trip]map[string][][]string{},
+		bookings: map[string]Booking{},
+		byEmail:  map[string][]string{},
+		waiting:  map[Trip][]WaitlistEntry{},
+		waitIDs:  map[string]Trip{},
+		refunds:  map[string]Refund{},
+		vouchers: map[string]Voucher{},
+		uses:     map[string]int{},
+		accounts: map[string]Account{},
+		revoked:  map[string]int64{},
+	}
+}
+
+func (m *MemoryStore) Reserve(b Booking) error {
+	return m.ReserveAll([]Booking{b})
+}
+
+func (m *MemoryStore) ReserveAll(bookings []Booking) error {
+	keys := make([]sectionKey, 0, len(bookings))
+	seats := make(map[sectionKey][][]string, len(bookings))
+	for _, b := range bookings {
+		key := sectionKey{trip: b.Trip, section: b.Section}
+		section, err := m.section(b.Trip, b.Section, true)
+		if err != nil {
+			return err
+		}
+		if b.Seat < 0 || int(b.Seat) >= len(section) {
+			return fmt.Errorf("invalid seat: %d", b.Seat)
+		}
+		if b.Board < 0 || b.Board >= b.Segment.end() {
+			return fmt.Errorf("invalid segment: %d-%d", b.Board, b.Alight)
+		}
+		if _, ok := seats[key]; !ok {
+			keys = append(keys, key)
+			seats[key] = section
+		}
+	}
+	// Sections are always locked in the same order so that two overlapping
+	// batches cannot deadlock.
+	slices.SortFunc(keys, compareSectionKeys)
+	for _, key := range keys {
+		unlock := m.lockSection(key.trip, key.section)
+		defer unlock()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	taken := map[sectionKey]map[int32][]Segment{}
+	for _, b := range bookings {
+		key := sectionKey{trip: b.Trip, section: b.Section}
+		if _, ok := m.occupant(seats[key][b.Seat], b.Segment); ok {
+			return errSeatOccupied
+		}
+		for _, seg := range taken[key][b.Seat] {
+			if seg.overlaps(b.Segment) {
+				return errSeatOccupied
+			}
+		}
+		if taken[key] == nil {
+			taken[key] = map[int32][]Segment{}
+		}
+		taken[key][b.Seat] = append(taken[key][b.Seat], b.Segment)
+	}
+	travellers := map[Trip]map[string]bool{}
+	for _, b := range bookings {
+		if _, ok := m.bookings[b.ID]; ok {
+			return fmt.Errorf("duplicate booking id: %v", b.ID)
+		}
+		if travellers[b.Trip][b.User.Email] {
+			return errAlreadyPurchased
+		}
+		for _, id := range m.byEmail[b.User.Email] {
+			if m.bookings[id].Trip == b.Trip {
+				return errAlreadyPurchased
+			}
+		}
+		if travellers[b.Trip] == nil {
+			travellers[b.Trip] = map[string]bool{}
+		}
+		travellers[b.Trip][b.User.Email] = true
+	}
+	redeemed := map[string]int{}
+	for _, b := range bookings {
+		if b.Voucher == "" {
+			continue
+		}
+		redeemed[b.Voucher]++
+		// Vouchers deleted since the booking was priced no longer limit it.
+		if v, ok := m.vouchers[b.Voucher]; ok && v.MaxUses > 0 && m.uses[b.Voucher]+redeemed[b.Voucher] > v.MaxUses {
+			return errVoucherUsedUp
+		}
+	}
+	for code, n := range redeemed {
+		m.uses[code] += n
+	}
+	for _, b := range bookings {
+		key := sectionKey{trip: b.Trip, section: b.Section}
+		seats[key][b.Seat] = append(seats[key][b.Seat], b.ID)
+		m.bookings[b.ID] = b
+		m.byEmail[b.User.Email] = append(m.byEmail[b.User.Email], b.ID)
+	}
+	return nil
+}
+
+func (m *MemoryStore) Release(id string) (Booking, error) {
+	return m.releaseIf(id, false, func(Booking) error { return nil })
+}
+
+func (m *MemoryStore) Cancel(id string, refund Refund) (Booking, error) {
+	return m.releaseIf(id, true, func(Booking) error {
+		// check runs with mu held, so the refund shows up together with the
+		// release.
+		if _, ok := m.refunds[refund.ID]; ok {
+			return fmt.Errorf("duplicate refund id: %v", refund.ID)
+		}
+		m.refunds[refund.ID] = refund
+		return nil
+	})
+}
+
+// releaseIf frees booking id when check, evaluated under the locks, accepts
+// its current state. The voucher of the booking is given back unless it was
+// redeemed: by a purchase, not a hold, which is cancelled rather than rolled
+// back.
+func (m *MemoryStore) releaseIf(id string, cancel bool, check func(Booking) error) (Booking, error) {
+	b, ok := m.Lookup(id)
+	if !ok {
+		return Booking{}, fmt.Errorf("no booking found: %v", id)
+	}
+	unlock := m.lockSection(b.Trip, b.Section)
+	defer unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// The booking may have changed while no lock was held.
+	if b, ok = m.bookings[id]; !ok {
+		return Booking{}, fmt.Errorf("no booking found: %v", id)
+	}
+	if err := check(b); err != nil {
+		return Booking{}, err
+	}
+	seats := m.receipts[b.Trip][b.Section]
+	seats[b.Seat] = withoutID(seats[b.Seat], id)
+	delete(m.bookings, id)
+	if redeemed := cancel && b.HeldUntil == 0; b.Voucher != "" && !redeemed && m.uses[b.Voucher] > 0 {
+		if m.uses[b.Voucher]--; m.uses[b.Voucher] == 0 {
+			delete(m.uses, b.Voucher)
+		}
+	}
+	ids := slices.DeleteFunc(m.byEmail[b.User.Email], func(v string) bool { return v == id })
+	if len(ids) == 0 {
+		delete(m.byEmail, b.User.Email)
+	} else {
+		m.byEmail[b.User.Email] = ids
+	}
+	return b, nil
+}
+
+func (m *MemoryStore) Move(id string, seat int32) (Booking, error) {
+	b, ok := m.Lookup(id)
+	if !ok {
+		return Booking{}, fmt.Errorf("no booking found: %v", id)
+	}
+	seats, err := m.section(b.Trip, b.Section, false)
+	if err != nil {
+		return Booking{}, err
+	}
+	if seat < 0 || int(seat) >= len(seats) {
+		return Booking{}, fmt.Errorf("invalid seat: %d", seat)
+	}
+	unlock := m.lockSection(b.Trip, b.Section)
+	defer unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b, ok = m.bookings[id]; !ok {
+		return Booking{}, fmt.Errorf("no booking found: %v", id)
+	}
+	if _, ok := m.occupant(seats[seat], b.Segment); ok {
+		return Booking{}, errSeatOccupied
+	}
+	seats[b.Seat] = withoutID(seats[b.Seat], id)
+	seats[seat] = append(seats[seat], id)
+	b.Seat = seat
+	m.bookings[id] = b
+	return b, nil
+}
+
+func (m *MemoryStore) ReleaseHold(id string) (Booking, error) {
+	return m.releaseIf(id, false, func(b Booking) error {
+		if b.HeldUntil == 0 {
+			return errNotHeld
+		}
+		return nil
+	})
+}
+
+func (m *MemoryStore) Confirm(id string, now int64) (Booking, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.bookings[id]
+	if !ok {
+		return Booking{}, fmt.Errorf("no booking found: %v", id)
+	}
+	if b.HeldUntil == 0 {
+		return Booking{}, errNotHeld
+	}
+	if b.HeldUntil <= now {
+		return Booking{}, errHoldExpired
+	}
+	b.HeldUntil = 0
+	m.bookings[id] = b
+	return b, nil
+}
+
+func (m *MemoryStore) Unconfirm(id string, heldUntil int64) (Booking, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.bookings[id]
+	if !ok {
+		return Booking{}, fmt.Errorf("no booking found: %v", id)
+	}
+	if b.HeldUntil != 0 {
+		return Booking{}, fmt.Errorf("booking is still held: %v", id)
+	}
+	b.HeldUntil = heldUntil
+	m.bookings[id] = b
+	return b, nil
+}
+
+func (m *MemoryStore) ReleaseExpired(now int64) ([]Booking, error) {
+	m.mu.RLock()
+	expired := make([]string, 0)
+	for id, b := range m.bookings {
+		if b.HeldUntil != 0 && b.HeldUntil <= now {
+			expired = append(expired, id)
+		}
+	}
+	m.mu.RUnlock()
+
+	released := make([]Booking, 0, len(expired))
+	for _, id := range expired {
+		// The hold may have been confirmed or released in the meantime.
+		b, err := m.releaseIf(id, false, func(b Booking) error {
+			if b.HeldUntil == 0 || b.HeldUntil > now {
+				return errNotHeld
+			}
+			return nil
+		})
+		if err == nil {
+			released = append(released, b)
+		}
+	}
+	return released, nil
+}
+
+func (m *MemoryStore) Lookup(id string) (Booking, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.bookings[id]
+	return b, ok
+}
+
+func (m *MemoryStore) ListByEmail(email string) []Booking {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	bookings := make([]Booking, 0, len(m.byEmail[email]))
+	for _, id := range m.byEmail[email] {
+		bookings = append(bookings, m.bookings[id])
+	}
+	return bookings
+}
+
+func (m *MemoryStore) ListSection(trip Trip, section string, seg Segment) ([]*Booking, error) {
+	seats, err := m.section(trip, section, false)
+	if err != nil {
+		return nil, err
+	}
+	if !m.stocked(trip, section) {
+		return make([]*Booking, len(seats)), nil
+	}
+	unlock := m.lockSection(trip, section)
+	defer unlock()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	bookings := make([]*Booking, len(seats))
+	for i, ids := range seats {
+		if b, ok := m.occupant(ids, seg); ok {
+			bookings[i] = &b
+		}
+	}
+	return bookings, nil
+}
+
+func (m *MemoryStore) ListPassengers(trip Trip, section string) ([]Booking, error) {
+	seats, err := m.section(trip, section, false)
+	if err != nil {
+		return nil, err
+	}
+	if !m.stocked(trip, section) {
+		return make([]Booking, 0), nil
+	}
+	unlock := m.lockSection(trip, section)
+	defer unlock()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	bookings := make([]Booking, 0, len(seats))
+	for _, ids := range seats {
+		start := len(bookings)
+		for _, id := range ids {
+			bookings = append(bookings, m.bookings[id])
+		}
+		slices.SortFunc(bookings[start:], func(a, b Booking) int { return cmp.Compare(a.Board, b.Board) })
+	}
+	return bookings, nil
+}
+
+func (m *MemoryStore) Enqueue(w WaitlistEntry) (int, error) {
+	if w.Route < 0 || int(w.Route) >= len(m.conf.Routes) {
+		return 0, fmt.Errorf("invalid route: %d", w.Route)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.waitIDs[w.ID]; ok {
+		return 0, fmt.Errorf("duplicate waitlist id: %v", w.ID)
+	}
+	for _, queued := range m.waiting[w.Trip] {
+		if queued.User.Email == w.User.Email {
+			return 0, errAlreadyWaiting
+		}
+	}
+	m.waiting[w.Trip] = append(m.waiting[w.Trip], w)
+	m.waitIDs[w.ID] = w.Trip
+	return len(m.waiting[w.Trip]), nil
+}
+
+func (m *MemoryStore) Dequeue(id string) (WaitlistEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	trip, ok := m.waitIDs[id]
+	if !ok {
+		return WaitlistEntry{}, fmt.Errorf("no waitlist entry found: %v", id)
+	}
+	queue := m.waiting[trip]
+	i := slices.IndexFunc(queue, func(w WaitlistEntry) bool { return w.ID == id })
+	w := queue[i]
+	if queue = slices.Delete(queue, i, i+1); len(queue) == 0 {
+		delete(m.waiting, trip)
+	} else {
+		m.waiting[trip] = queue
+	}
+	delete(m.waitIDs, id)
+	return w, nil
+}
+
+func (m *MemoryStore) Waitlist(trip Trip) []WaitlistEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.waiting[trip])
+}
+
+func (m *MemoryStore) LookupWaitlist(id string) (WaitlistEntry, int, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	trip, ok := m.waitIDs[id]
+	if !ok {
+		return WaitlistEntry{}, 0, false
+	}
+	i := slices.IndexFunc(m.waiting[trip], func(w WaitlistEntry) bool { return w.ID == id })
+	return m.waiting[trip][i], i + 1, true
+}
+
+func (m *MemoryStore) LookupRefund(id string) (Refund, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.refunds[id]
+	return r, ok
+}
+
+func (m *MemoryStore) AddVoucher(v Voucher) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.vouchers[v.Code]; ok {
+		return errVoucherExists
+	}
+	v.Uses = 0
+	m.vouchers[v.Code] = v
+	// Redemptions of a deleted voucher with the same code do not count.
+	delete(m.uses, v.Code)
+	return nil
+}
+
+func (m *MemoryStore) DeleteVoucher(code string) (Voucher, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.vouchers[code]
+	if !ok {
+		return Voucher{}, fmt.Errorf("no voucher found: %v", code)
+	}
+	delete(m.vouchers, code)
+	v.Uses = m.uses[code]
+	return v, nil
+}
+
+func (m *MemoryStore) LookupVoucher(code string) (Voucher, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.vouchers[code]
+	if ok {
+		v.Uses = m.uses[code]
+	}
+	return v, ok
+}
+
+func (m *MemoryStore) ListVouchers() []Voucher {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	vouchers := make([]Voucher, 0, len(m.vouchers))
+	for code, v := range m.vouchers {
+		v.Uses = m.uses[code]
+		vouchers = append(vouchers, v)
+	}
+	slices.SortFunc(vouchers, func(a, b Voucher) int { return cmp.Compare(a.Code, b.Code) })
+	return vouchers
+}
+
+func (m *MemoryStore) AddAccount(a Account) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.accounts[a.Email]; ok {
+		return errAccountExists
+	}
+	m.accounts[a.Email] = a
+	return nil
+}
+
+func (m *MemoryStore) LookupAccount(email string) (Account, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	a, ok := m.accounts[email]
+	return a, ok
+}
+
+func (m *MemoryStore) Revoke(r Revocation) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().Unix()
+	for id, expiresAt := range m.revoked {
+		if expiresAt < now {
+			delete(m.revoked, id)
+		}
+	}
+	if _, ok := m.revoked[r.ID]; ok {
+		return true, nil
+	}
+	m.revoked[r.ID] = r.ExpiresAt
+	return false, nil
+}
+
+func (m *MemoryStore) IsRevoked(id string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.revoked[id]
+	return ok
+}
+
+// dropRevocation forgets the revocation of id.
+func (m *MemoryStore) dropRevocation(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.revoked, id)
+}
+
+// dropAccount forgets the account of email.
+func (m *MemoryStore) dropAccount(email string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.accounts, email)
+}
+
+// dropRefund forgets refund id.
+func (m *MemoryStore) dropRefund(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.refunds, id)
+}
+
+// reinstate books b again after Cancel released it, without redeeming its
+// voucher a second time.
+func (m *MemoryStore) reinstate(b Booking) error {
+	if b.Voucher != "" && b.HeldUntil == 0 {
+		m.mu.Lock()
+		m.uses[b.Voucher]--
+		m.mu.Unlock()
+	}
+	return m.Reserve(b)
+}
+
+// hold puts booking id back on hold until the given time.
+func (m *MemoryStore) hold(id string, until int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b, ok := m.bookings[id]; ok {
+		b.HeldUntil = until
+		m.bookings[id] = b
+	}
+}
+
+func (m *MemoryStore) all() []Booking {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	bookings := make([]Booking, 0, len(m.bookings))
+	for _, ids := range m.byEmail {
+		for _, id := range ids {
+			bookings = append(bookings, m.bookings[id])
+		}
+	}
+	return bookings
+}
+
+// requeue puts w back at the 1-based position pos of its queue.
+func (m *MemoryStore) requeue(w WaitlistEntry, pos int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	queue := m.waiting[w.Trip]
+	m.waiting[w.Trip] = slices.Insert(queue, min(pos-1, len(queue)), w)
+	m.waitIDs[w.ID] = w.Trip
+}
+
+// allWaiting returns every waitlist entry, each queue in order.
+func (m *MemoryStore) allWaiting() []WaitlistEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entries := make([]WaitlistEntry, 0, len(m.waitIDs))
+	for _, queue := range m.waiting {
+		entries = append(entries, queue...)
+	}
+	return entries
+}
+
+func (m *MemoryStore) allAccounts() []Account {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	accounts := make([]Account, 0, len(m.accounts))
+	for _, a := range m.accounts {
+		accounts = append(accounts, a)
+	}
+	return accounts
+}
+
+func (m *MemoryStore) allRevocations() []Revocation {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	revoked := make([]Revocation, 0, len(m.revoked))
+	for id, expiresAt := range m.revoked {
+		revoked = append(revoked, Revocation{ID: id, ExpiresAt: expiresAt})
+	}
+	return revoked
+}
+
+func (m *MemoryStore) allRefunds() []Refund {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	refunds := make([]Refund, 0, len(m.refunds))
+	for _, r := range m.refunds {
+		refunds = append(refunds, r)
+	}
+	return refunds
+}
+
+// section returns the seats of a trip section. Trips nobody booked yet have no
+// inventory: create allocates it, otherwise an empty one is returned.
+func (m *MemoryStore) section(trip Trip, section string, create bool) ([][]string, error) {
+	m.mu.RLock()
+	seats, ok := m.receipts[trip][section]
+	m.mu.RUnlock()
+	if ok {
+		return seats, nil
+	}
+	if trip.Route < 0 || int(trip.Route) >= len(m.conf.Routes) {
+		return nil, fmt.Errorf("invalid route: %d", trip.Route)
+	}
+	if _, ok := m.conf.Section(section); !ok {
+		return nil, fmt.Errorf("invalid section: %s", section)
+	}
+	if !create {
+		return make([][]string, m.conf.SectionSeats(section)), nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.receipts[trip]; !ok {
+		m.receipts[trip] = make(map[string][][]string)
+	}
+	if _, ok := m.receipts[trip][section]; !ok {
+		m.receipts[trip][section] = make([][]string, m.conf.SectionSeats(section))
+	}
+	return m.receipts[trip][section], nil
+}
+
+// stocked reports whether a seat of the section of trip was ever booked. Reads
+// of sections which were not skip lockSection, so asking about any number of
+// trips does not leave a lock behind for each.
+func (m *MemoryStore) stocked(trip Trip, section string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.receipts[trip][section]
+	return ok
+}
+
+func (m *MemoryStore) lockSection(trip Trip, section string) func() {
+	l, _ := m.locks.LoadOrStore(sectionKey{trip: trip, section: section}, &sync.Mutex{})
+	mu := l.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+func compareSectionKeys(a, b sectionKey) int {
+	if a.trip.Route != b.trip.Route {
+		return cmp.Compare(a.trip.Route, b.trip.Route)
+	}
+	if a.trip.DepartsAt != b.trip.DepartsAt {
+		return cmp.Compare(a.trip.DepartsAt, b.trip.DepartsAt)
+	}
+	return strings.Compare(a.section, b.section)
+}
+
+// occupant returns the booking among ids, the bookings of one seat, which
+// takes the seat somewhere on seg. mu must be held.
+func (m *MemoryStore) occupant(ids []string, seg Segment) (Booking, bool) {
+	for _, id := range ids {
+		if b, ok := m.bookings[id]; ok && b.Segment.overlaps(seg) {
+			return b, true
+		}
+	}
+	return Booking{}, false
+}
+
+func withoutID(ids []string, id string) []string {
+	return slices.DeleteFunc(ids, func(v string) bool { return v == id })
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStoreConfig() *TrainConfig {
+	return &TrainConfig{
+		Routes: []RouteConfig{
+			{From: from1, To: to1, Price: price1},
+		},
+		Sections:  []SectionConfig{{Name: section1}, {Name: section2}},
+		SeatCount: seatCount,
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore(newTestStoreConfig())
+	user := &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1}
+
+	t.Run("reserve and lookup", func(t *testing.T) {
+		assert.NoError(t, store.Reserve(Booking{ID: bookingID1, Trip: Trip{Route: 0}, Section: section1, Seat: 1, User: user}))
+		b, ok := store.Lookup(bookingID1)
+		assert.True(t, ok)
+		assert.Equal(t, section1, b.Section)
+		assert.Equal(t, int32(1), b.Seat)
+	})
+
+	t.Run("reserve occupied seat", func(t *testing.T) {
+		other := &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2}
+		err := store.Reserve(Booking{ID: bookingID2, Trip: Trip{Route: 0}, Section: section1, Seat: 1, User: other})
+		assert.ErrorIs(t, err, errSeatOccupied)
+	})
+
+	t.Run("invalid section", func(t *testing.T) {
+		_, err := store.ListSection(Trip{Route: 0}, "Z", Segment{})
+		assert.EqualError(t, err, "invalid section: Z")
+	})
+
+	t.Run("move seat", func(t *testing.T) {
+		b, err := store.Move(bookingID1, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(0), b.Seat)
+		bookings, err := store.ListSection(Trip{Route: 0}, section1, Segment{})
+		assert.NoError(t, err)
+		assert.Equal(t, email1, bookings[0].User.Email)
+		assert.Nil(t, bookings[1])
+	})
+
+	t.Run("several bookings per user", func(t *testing.T) {
+		err := store.Reserve(Booking{ID: bookingID2, Trip: Trip{Route: 0}, Section: section2, Seat: 0, User: user})
+		assert.ErrorIs(t, err, errAlreadyPurchased)
+		err = store.Reserve(Booking{ID: bookingID2, Trip: Trip{Route: 0, DepartsAt: 1}, Section: section2, Seat: 0, User: user})
+		assert.NoError(t, err)
+		bookings := store.ListByEmail(email1)
+		assert.Len(t, bookings, 2)
+		assert.Equal(t, bookingID1, bookings[0].ID)
+		assert.Equal(t, bookingID2, bookings[1].ID)
+	})
+
+	t.Run("release", func(t *testing.T) {
+		b, err := store.Release(bookingID1)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(0), b.Seat)
+		_, ok := store.Lookup(bookingID1)
+		assert.False(t, ok)
+		_, err = store.Release(bookingID1)
+		assert.EqualError(t, err, "no booking found: BK-0000000000000001")
+		assert.Len(t, store.ListByEmail(email1), 1)
+	})
+}
+
+func TestMemoryStore_ListSectionLocks(t *testing.T) {
+	store := NewMemoryStore(newTestStoreConfig())
+	locks := func() int {
+		n := 0
+		store.locks.Range(func(_, _ any) bool {
+			n++
+			return true
+		})
+		return n
+	}
+	// Reading trips nobody booked leaves no lock behind.
+	for departsAt := int64(1); departsAt <= 1000; departsAt++ {
+		bookings, err := store.ListSection(Trip{Route: 0, DepartsAt: departsAt}, section1, Segment{})
+		assert.NoError(t, err)
+		assert.Len(t, bookings, seatCount)
+	}
+	assert.Zero(t, locks())
+
+	user := &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1}
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID1, Trip: Trip{Route: 0}, Section: section1, Seat: 1, User: user}))
+	bookings, err := store.ListSection(Trip{Route: 0}, section1, Segment{})
+	assert.NoError(t, err)
+	assert.Equal(t, email1, bookings[1].User.Email)
+	assert.Equal(t, 1, locks())
+}
+
+func TestMemoryStore_Holds(t *testing.T) {
+	store := NewMemoryStore(newTestStoreConfig())
+	user1 := &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1}
+	user2 := &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2}
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID1, Trip: Trip{Route: 0}, Section: section1, Seat: 0, User: user1, HeldUntil: 100}))
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID2, Trip: Trip{Route: 0}, Section: section1, Seat: 1, User: user2, HeldUntil: 200}))
+
+	_, err := store.Confirm(bookingID1, 100)
+	assert.ErrorIs(t, err, errHoldExpired)
+	b, err := store.Confirm(bookingID2, 150)
+	assert.NoError(t, err)
+	assert.Zero(t, b.HeldUntil)
+	_, err = store.Confirm(bookingID2, 150)
+	assert.ErrorIs(t, err, errNotHeld)
+	_, err = store.ReleaseHold(bookingID2)
+	assert.ErrorIs(t, err, errNotHeld)
+
+	released, err := store.ReleaseExpired(300)
+	assert.NoError(t, err)
+	assert.Len(t, released, 1)
+	assert.Equal(t, bookingID1, released[0].ID)
+	bookings, err := store.ListSection(Trip{Route: 0}, section1, Segment{})
+	assert.NoError(t, err)
+	assert.Nil(t, bookings[0])
+	assert.Equal(t, bookingID2, bookings[1].ID)
+}
+
+func TestMemoryStore_Segments(t *testing.T) {
+	conf := newTestStoreConfig()
+	conf.Routes[0].Stops = []string{"Ashford", "Lille"}
+	store := NewMemoryStore(conf)
+	user := func(email string) *proto.User {
+		return &proto.User{FirstName: firstName1, LastName: lastName1, Email: email}
+	}
+
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID1, Trip: Trip{Route: 0}, Segment: Segment{Board: 0, Alight: 2}, Section: section1, Seat: 0, User: user(email1)}))
+	// The seat is free again from Lille.
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID2, Trip: Trip{Route: 0}, Segment: Segment{Board: 2}, Section: section1, Seat: 0, User: user(email2)}))
+	err := store.Reserve(Booking{ID: bookingID3, Trip: Trip{Route: 0}, Segment: Segment{Board: 1, Alight: 3}, Section: section1, Seat: 0, User: user(email3)})
+	assert.ErrorIs(t, err, errSeatOccupied)
+	err = store.ReserveAll([]Booking{
+		{ID: bookingID3, Trip: Trip{Route: 0}, Segment: Segment{Board: 0, Alight: 2}, Section: section1, Seat: 1, User: user(email3)},
+		{ID: "other", Trip: Trip{Route: 0}, Segment: Segment{Board: 1}, Section: section1, Seat: 1, User: user("other@example.com")},
+	})
+	assert.ErrorIs(t, err, errSeatOccupied)
+
+	bookings, err := store.ListSection(Trip{Route: 0}, section1, Segment{Board: 0, Alight: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, bookingID1, bookings[0].ID)
+	bookings, err = store.ListSection(Trip{Route: 0}, section1, Segment{Board: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, bookingID2, bookings[0].ID)
+	passengers, err := store.ListPassengers(Trip{Route: 0}, section1)
+	assert.NoError(t, err)
+	assert.Len(t, passengers, 2)
+
+	// Moving keeps the segment, so it needs a seat free over it.
+	assert.NoError(t, store.Reserve(Booking{ID: bookingID3, Trip: Trip{Route: 0}, Segment: Segment{Board: 1}, Section: section1, Seat: 1, User: user(email3)}))
+	_, err = store.Move(bookingID1, 1)
+	assert.ErrorIs(t, err, errSeatOccupied)
+	_, err = store.Release(bookingID1)
+	assert.NoError(t, err)
+	bookings, err = store.ListSection(Trip{Route: 0}, section1, Segment{Board: 0, Alight: 2})
+	assert.NoError(t, err)
+	assert.Nil(t, bookings[0])
+}
+
+func TestMemoryStore_Waitlist(t *testing.T) {
+	store := NewMemoryStore(newTestStoreConfig())
+	user1 := &proto.User{FirstName: firstName1, LastName: lastName1, Email: email1}
+	user2 := &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2}
+	user3 := &proto.User{FirstName: firstName3, LastName: lastName3, Email: email3}
+	trip := Trip{Route: 0}
+
+	for i, w := range []WaitlistEntry{
+		{ID: bookingID1, Trip: trip, User: user1},
+		{ID: bookingID2, Trip: trip, User: user2},
+		{ID: bookingID3, Trip: trip, User: user3},
+	} {
+		position, err := store.Enqueue(w)
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, position)
+	}
+	_, err := store.Enqueue(WaitlistEntry{ID: "other", Trip: trip, User: user1})
+	assert.ErrorIs(t, err, errAlreadyWaiting)
+	_, err = store.Enqueue(WaitlistEntry{ID: "other", Trip: Trip{Route: 0, DepartsAt: 1}, User: user1})
+	assert.NoError(t, err)
+
+	w, err := store.Dequeue(bookingID2)
+	assert.NoError(t, err)
+	assert.Equal(t, email2, w.User.Email)
+	_, err = store.Dequeue(bookingID2)
+	assert.Error(t, err)
+
+	queue := store.Waitlist(trip)
+	assert.Len(t, queue, 2)
+	assert.Equal(t, bookingID1, queue[0].ID)
+	assert.Equal(t, bookingID3, queue[1].ID)
+	_, position, ok := store.LookupWaitlist(bookingID3)
+	assert.True(t, ok)
+	assert.Equal(t, 2, position)
+}
+
+func TestMemoryStore_Revoke(t *testing.T) {
+	store := NewMemoryStore(newTestStoreConfig())
+	now := time.Now().Unix()
+	revoked, err := store.Revoke(Revocation{ID: "TK-1", ExpiresAt: now - 1})
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+	assert.True(t, store.IsRevoked("TK-1"))
+	assert.False(t, store.IsRevoked("TK-2"))
+	// Tokens which have expired anyway are forgotten.
+	_, err = store.Revoke(Revocation{ID: "TK-2", ExpiresAt: now + 60})
+	assert.NoError(t, err)
+	assert.False(t, store.IsRevoked("TK-1"))
+	assert.True(t, store.IsRevoked("TK-2"))
+	// Revoking again reports that the token was revoked already.
+	revoked, err = store.Revoke(Revocation{ID: "TK-2", ExpiresAt: now + 60})
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	proto "github.com/playbody/train-ticket-service/proto"
+)
+
+// charges adds the configured fees and the VAT of the origin of route to
+// fare.
+func (s *TrainServer) charges(route int32, fare int32) Charges {
+	c := Charges{Fare: fare, Total: fare}
+	for _, rule := range s.Conf.Taxes.Fees {
+		amount := rule.Amount
+		if rule.Percent > 0 {
+			amount += applyPercents(fare, []int{rule.Percent})
+		}
+		c.Fees = append(c.Fees, Fee{Name: rule.Name, Amount: amount})
+		c.Total += amount
+	}
+	country := s.Conf.Routes[route].Country
+	if p, ok := s.Conf.Taxes.VAT[country]; ok && p > 0 {
+		c.TaxCountry, c.TaxPercent = country, p
+		c.Tax = applyPercents(c.Total, []int{p})
+		c.Total += c.Tax
+	}
+	return c
+}
+
+// addCharges turns the fare of every section into the total charged for it,
+// returning the totals and their breakdowns.
+func (s *TrainServer) addCharges(route int32, fares map[string]int32) (map[string]int32, map[string]Charges) {
+	totals := make(map[string]int32, len(fares))
+	charges := make(map[string]Charges, len(fares))
+	for sec, fare := range fares {
+		charges[sec] = s.charges(route, fare)
+		totals[sec] = charges[sec].Total
+	}
+	return totals, charges
+}
+
+// bookingCharges returns the breakdown stored with b. Bookings made before
+// breakdowns were stored only have their price.
+func (s *TrainServer) bookingCharges(b Booking) Charges {
+	if b.Charges != nil {
+		return *b.Charges
+	}
+	price := s.bookingPrice(b)
+	return Charges{Fare: price, Total: price}
+}
+
+func breakdownResponse(c Charges) *proto.PriceBreakdown {
+	resp := &proto.PriceBreakdown{
+		Fare:       c.Fare,
+		Fees:       make([]*proto.LineItem, 0, len(c.Fees)),
+		TaxCountry: c.TaxCountry,
+		TaxPercent: int32(c.TaxPercent),
+		Tax:        c.Tax,
+		Total:      c.Total,
+	}
+	for _, fee := range c.Fees {
+		resp.Fees = append(resp.Fees, &proto.LineItem{Name: fee.Name, Amount: fee.Amount})
+	}
+	return resp
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func taxConfig(conf *TrainConfig) {
+	conf.Routes[0].Price = 1000
+	conf.Routes[0].Country = "GB"
+	conf.Taxes = TaxConfig{
+		VAT:  map[string]int{"GB": 20},
+		Fees: []FeeRule{{Name: "booking", Amount: 150}, {Name: "card", Percent: 2}},
+	}
+}
+
+func TestTrainServer_charges(t *testing.T) {
+	s := newTestServer(t, taxConfig)
+	assert.Equal(t, Charges{
+		Fare:       1000,
+		Fees:       []Fee{{Name: "booking", Amount: 150}, {Name: "card", Amount: 20}},
+		TaxCountry: "GB",
+		TaxPercent: 20,
+		Tax:        234,
+		Total:      1404,
+	}, s.charges(0, 1000))
+
+	s.Conf.Routes[0].Country = "FR"
+	assert.Equal(t, int32(1170), s.charges(0, 1000).Total)
+}
+
+func TestTrainServer_ReceiptBreakdown(t *testing.T) {
+	s := newTestServer(t, taxConfig)
+	ctx := context.Background()
+
+	req := holdRequest(email1)
+	req.Price = 1403
+	_, err := s.PurchaseTicket(ctx, req)
+	assert.ErrorIs(t, err, errNotEnoughMoney)
+	req.Price = 1500
+	ticket, err := s.PurchaseTicket(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1404), ticket.AmountCharged)
+	assert.Equal(t, int32(96), ticket.Change)
+	assert.Equal(t, int32(1000), ticket.OriginalPrice)
+
+	// The breakdown is the one of the purchase, whatever the config says now.
+	s.Conf.Taxes = TaxConfig{}
+	b, _ := s.Store.Lookup(ticket.BookingId)
+	receipt := s.receipt(b)
+	assert.Equal(t, int32(1404), receipt.Price)
+	assert.Equal(t, &proto.PriceBreakdown{
+		Fare:       1000,
+		Fees:       []*proto.LineItem{{Name: "booking", Amount: 150}, {Name: "card", Amount: 20}},
+		TaxCountry: "GB",
+		TaxPercent: 20,
+		Tax:        234,
+		Total:      1404,
+	}, receipt.Breakdown)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// issueTokens returns a new access token carrying capabilities and a refresh
+// token of email.
+func issueTokens(email string, capabilities []string) (*proto.AuthResponse, error) {
+	token, err := GenerateJWT(email, capabilities)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Cannot generate jwt token %v", err)
+	}
+	refresh, err := generateRefreshJWT(email)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Cannot generate jwt token %v", err)
+	}
+	return &proto.AuthResponse{Token: token, RefreshToken: refresh, ExpiresIn: SConfig.Auth.Expire}, nil
+}
+
+// revoke stops the token of claims from being accepted, and reports whether
+// it was revoked already.
+func (s *TrainServer) revoke(claims JwtClaims) (bool, error) {
+	if claims.Id == "" {
+		return false, status.Errorf(codes.InvalidArgument, "token has no id and cannot be revoked")
+	}
+	revoked, err := s.Store.Revoke(Revocation{ID: claims.Id, ExpiresAt: claims.ExpiresAt})
+	if err != nil {
+		return false, status.Errorf(codes.Internal, "cannot revoke token: %v", err)
+	}
+	return revoked, nil
+}
+
+// RefreshToken swaps a refresh token for a new access and refresh token. The
+// old refresh token is revoked, and the capabilities are those configured for
+// the email now. Revoking tests and sets in one step, so a refresh token
+// replayed concurrently is swapped only once.
+func (s *TrainServer) RefreshToken(_ context.Context, req *proto.RefreshTokenRequest) (*proto.AuthResponse, error) {
+	claims, err := parseJWT(req.RefreshToken)
+	if err != nil || claims.TokenType != tokenRefresh {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid refresh token")
+	}
+	role, isRole := roleUser(claims.UserID)
+	if _, ok := s.Store.LookupAccount(claims.UserID); !ok && !isRole {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid refresh token")
+	}
+	revoked, err := s.revoke(claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, status.Errorf(codes.Unauthenticated, "Token revoked.")
+	}
+	return issueTokens(claims.UserID, role.Capabilities)
+}
+
+// Logout revokes the access token of the request and, when given, the refresh
+// token issued with it.
+func (s *TrainServer) Logout(ctx context.Context, req *proto.LogoutRequest) (*proto.LogoutResponse, error) {
+	if err := AuthCheck(ctx, "*"); err != nil {
+		return nil, err
+	}
+	claims := ctx.Value("jwt").(JwtClaims)
+	if req.RefreshToken != "" {
+		refresh, err := parseJWT(req.RefreshToken)
+		if err != nil || refresh.TokenType != tokenRefresh || refresh.UserID != claims.UserID {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid refresh token")
+		}
+		if _, err := s.revoke(refresh); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.revoke(claims); err != nil {
+		return nil, err
+	}
+	return &proto.LogoutResponse{Message: "Logged out successfully"}, nil
+}
+
+// RevokeToken revokes an access or refresh token, for its owner or an admin.
+func (s *TrainServer) RevokeToken(_ context.Context, req *proto.RevokeTokenRequest) (*proto.RevokeTokenResponse, error) {
+	claims, err := parseJWT(req.Token)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid token: %v", err)
+	}
+	if _, err := s.revoke(claims); err != nil {
+		return nil, err
+	}
+	return &proto.RevokeTokenResponse{Message: "Token revoked successfully"}, nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTrainServer_RefreshToken(t *testing.T) {
+	s := newTestServer(t, nil)
+	ctx := context.Background()
+
+	login, err := s.Login(ctx, &proto.AuthRequest{Email: email1, Password: password1})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, login.RefreshToken)
+	assert.Equal(t, SConfig.Auth.Expire, login.ExpiresIn)
+
+	// A refresh token is no access token, and the other way round.
+	assert.Equal(t, codes.Unauthenticated, status.Code(AuthCheck(tokenContext(t, s, login.RefreshToken), "*")))
+	_, err = s.RefreshToken(ctx, &proto.RefreshTokenRequest{RefreshToken: login.Token})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	refreshed, err := s.RefreshToken(ctx, &proto.RefreshTokenRequest{RefreshToken: login.RefreshToken})
+	assert.NoError(t, err)
+	c, ok := tokenContext(t, s, refreshed.Token).Value("jwt").(JwtClaims)
+	assert.True(t, ok)
+	assert.Equal(t, email1, c.UserID)
+	assert.Equal(t, []string{CapAdmin, CapRead, CapWrite}, c.Capabilities)
+
+	// Refresh tokens are only good once.
+	_, err = s.RefreshToken(ctx, &proto.RefreshTokenRequest{RefreshToken: login.RefreshToken})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = s.RefreshToken(ctx, &proto.RefreshTokenRequest{RefreshToken: refreshed.RefreshToken})
+	assert.NoError(t, err)
+
+	// Emails which can no longer log in cannot refresh either.
+	refresh, err := generateRefreshJWT(email4)
+	assert.NoError(t, err)
+	_, err = s.RefreshToken(ctx, &proto.RefreshTokenRequest{RefreshToken: refresh})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestTrainServer_Logout(t *testing.T) {
+	s := newTestServer(t, nil)
+	ctx := context.Background()
+	login, err := s.Login(ctx, &proto.AuthRequest{Email: email1, Password: password1})
+	assert.NoError(t, err)
+	other, err := s.Login(ctx, &proto.AuthRequest{Email: email1, Password: password1})
+	assert.NoError(t, err)
+
+	authed := tokenContext(t, s, login.Token)
+	assert.NoError(t, AuthCheck(authed, email1))
+	_, err = s.Logout(authed, &proto.LogoutRequest{RefreshToken: other.RefreshToken + "x"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	resp, err := s.Logout(authed, &proto.LogoutRequest{RefreshToken: login.RefreshToken})
+	assert.NoError(t, err)
+	assert.Equal(t, "Logged out successfully", resp.Message)
+
+	// The token stops working at once, and cannot be refreshed.
+	assert.EqualError(t, AuthCheck(tokenContext(t, s, login.Token), email1), "rpc error: code = Unauthenticated desc = Token revoked.")
+	_, err = s.RefreshToken(ctx, &proto.RefreshTokenRequest{RefreshToken: login.RefreshToken})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	// Other sessions are not logged out.
+	assert.NoError(t, AuthCheck(tokenContext(t, s, other.Token), email1))
+
+	_, err = s.Logout(ctx, &proto.LogoutRequest{})
+	assert.Error(t, err)
+}
+
+func TestTrainServer_RevokeToken(t *testing.T) {
+	s := newTestServer(t, nil)
+	user := &proto.User{FirstName: firstName2, LastName: lastName2, Email: email2}
+	_, err := s.Register(context.Background(), &proto.RegisterRequest{User: user, Password: "s3cret-pass"})
+	assert.NoError(t, err)
+	login, err := s.Login(context.Background(), &proto.AuthRequest{Email: email2, Password: "s3cret-pass"})
+	assert.NoError(t, err)
+	admin, err := s.Login(context.Background(), &proto.AuthRequest{Email: email1, Password: password1})
+	assert.NoError(t, err)
+	stranger, err := GenerateJWT(email3, nil)
+	assert.NoError(t, err)
+
+	err = authorize(tokenContext(t, s, stranger), s, "RevokeToken", &proto.RevokeTokenRequest{Token: login.Token})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = s.RevokeToken(tokenContext(t, s, admin.Token), &proto.RevokeTokenRequest{Token: "garbage"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	resp, err := s.RevokeToken(tokenContext(t, s, admin.Token), &proto.RevokeTokenRequest{Token: login.Token})
+	assert.NoError(t, err)
+	assert.Equal(t, "Token revoked successfully", resp.Message)
+	assert.Equal(t, codes.Unauthenticated, status.Code(AuthCheck(tokenContext(t, s, login.Token), email2)))
+
+	// Owners can revoke their own tokens.
+	login, err = s.Login(context.Background(), &proto.AuthRequest{Email: email2, Password: "s3cret-pass"})
+	assert.NoError(t, err)
+	_, err = s.RevokeToken(tokenContext(t, s, login.Token), &proto.RevokeTokenRequest{Token: login.RefreshToken})
+	assert.NoError(t, err)
+	_, err = s.RefreshToken(context.Background(), &proto.RefreshTokenRequest{RefreshToken: login.RefreshToken})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -1,19 +1,53 @@
 package server
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	train "github.com/playbody/train-ticket-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"net/mail"
 	"strings"
 )
 
 func isValidUser(user *train.User) (bool, error) {
+	if user == nil {
+		return false, status.Errorf(codes.InvalidArgument, "user must not be empty")
+	}
 	if strings.TrimSpace(user.FirstName) == "" {
 		return true, fmt.Errorf("first name must not be empty")
 	}
 	if strings.TrimSpace(user.LastName) == "" {
 		return true, fmt.Errorf("last name must not be empty")
 	}
+	if !isValidPassengerType(user.PassengerType) {
+		return true, fmt.Errorf("invalid passenger type: %v", user.PassengerType)
+	}
 	_, err := mail.ParseAddress(user.Email)
 	return err == nil, err
 }
+
+// newBookingID returns a random, hard to guess booking identifier.
+func newBookingID() string {
+	return newID("BK-")
+}
+
+// newRefundID returns a random, hard to guess refund identifier.
+func newRefundID() string {
+	return newID("RF-")
+}
+
+// refundKey returns the idempotency key of the refund for cancelling booking
+// id at the payment provider.
+func refundKey(bookingID string) string {
+	return "refund-" + bookingID
+}
+
+func newID(prefix string) string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return prefix + strings.ToUpper(hex.EncodeToString(b))
+}
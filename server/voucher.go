@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CreateVoucher adds a discount code which purchases can pass as
+// voucher_code. The voucher takes either a percent or a fixed amount, in the
+// train currency unless it names another, off the fare.
+func (s *TrainServer) CreateVoucher(_ context.Context, req *proto.Voucher) (*proto.Voucher, error) {
+	v := Voucher{
+		Code:      req.Code,
+		Percent:   int(req.Percent),
+		Amount:    req.Amount,
+		Currency:  req.Currency,
+		Routes:    req.Routes,
+		MaxUses:   int(req.MaxUses),
+		ExpiresAt: req.ExpiresAt,
+	}
+	if v.Amount > 0 && v.Currency == "" {
+		v.Currency = s.trainCurrency()
+	}
+	if err := s.validateVoucher(v); err != nil {
+		return nil, err
+	}
+	if err := s.Store.AddVoucher(v); err != nil {
+		if errors.Is(err, errVoucherExists) {
+			return nil, status.Errorf(codes.AlreadyExists, "voucher already exists: %v", v.Code)
+		}
+		return nil, err
+	}
+	resp := voucherResponse(v)
+	s.logger.Info("CreateVoucher", resp)
+	return resp, nil
+}
+
+// ListVouchers shows every voucher with the number of times it was redeemed.
+func (s *TrainServer) ListVouchers(_ context.Context, _ *proto.ListVouchersRequest) (*proto.ListVouchersResponse, error) {
+	resp := &proto.ListVouchersResponse{
+		Vouchers: make([]*proto.Voucher, 0),
+	}
+	for _, v := range s.Store.ListVouchers() {
+		resp.Vouchers = append(resp.Vouchers, voucherResponse(v))
+	}
+	s.logger.Info("ListVouchers", resp)
+	return resp, nil
+}
+
+// DeleteVoucher withdraws a voucher. Bookings already made with it keep their
+// discount.
+func (s *TrainServer) DeleteVoucher(_ context.Context, req *proto.DeleteVoucherRequest) (*proto.Voucher, error) {
+	v, err := s.Store.DeleteVoucher(req.Code)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "%v", err)
+	}
+	resp := voucherResponse(v)
+	s.logger.Info("DeleteVoucher", resp)
+	return resp, nil
+}
+
+func (s *TrainServer) validateVoucher(v Voucher) error {
+	if v.Code == "" {
+		return status.Errorf(codes.InvalidArgument, "voucher code must not be empty")
+	}
+	if (v.Percent == 0) == (v.Amount == 0) {
+		return status.Errorf(codes.InvalidArgument, "voucher must have either a percent or an amount")
+	}
+	if v.Percent < 0 || v.Percent > 100 || v.Amount < 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid voucher discount")
+	}
+	if v.Currency != "" && (v.Amount == 0 || !isValidCurrency(v.Currency)) {
+		return status.Errorf(codes.InvalidArgument, "invalid voucher currency: %v", v.Currency)
+	}
+	if v.MaxUses < 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid max uses: %d", v.MaxUses)
+	}
+	for _, route := range v.Routes {
+		if route < 0 || int(route) >= len(s.Conf.Routes) {
+			return status.Errorf(codes.InvalidArgument, "invalid route: %d", route)
+		}
+	}
+	return nil
+}
+
+// checkVoucher returns voucher code if it may be used on route at now, with
+// its amount converted into the currency of the route. The usage limit is
+// checked again when the seat is reserved, since other purchases may use the
+// voucher up in between.
+func (s *TrainServer) checkVoucher(ctx context.Context, code string, route int32, now time.Time) (Voucher, error) {
+	v, ok := s.Store.LookupVoucher(code)
+	if !ok {
+		return Voucher{}, status.Errorf(codes.InvalidArgument, "invalid voucher: %v", code)
+	}
+	if v.ExpiresAt != 0 && v.ExpiresAt <= now.Unix() {
+		return Voucher{}, status.Errorf(codes.FailedPrecondition, "voucher expired: %v", code)
+	}
+	if len(v.Routes) > 0 && !slices.Contains(v.Routes, route) {
+		return Voucher{}, status.Errorf(codes.FailedPrecondition, "voucher %v is not valid on this route", code)
+	}
+	if v.MaxUses > 0 && v.Uses >= v.MaxUses {
+		return Voucher{}, errVoucherUsedUp
+	}
+	if v.Amount > 0 {
+		from, to := v.Currency, s.routeCurrency(route)
+		if from == "" {
+			from = s.trainCurrency()
+		}
+		if from != to {
+			rate, err := s.FX.Rate(ctx, from, to)
+			if err != nil {
+				return Voucher{}, status.Errorf(codes.FailedPrecondition, "voucher %v cannot be used in %v: %v", code, to, err)
+			}
+			v.Amount, v.Currency = int32(convert(int64(v.Amount), from, to, rate)), to
+		}
+	}
+	return v, nil
+}
+
+// discount returns what v takes off fare. It never exceeds the fare.
+func (v Voucher) discount(fare int32) int32 {
+	if v.Percent > 0 {
+		return fare - applyPercents(fare, []int{100 - v.Percent})
+	}
+	return min(v.Amount, fare)
+}
+
+// applyVoucher returns the fares discounted by v and the discount of every
+// section.
+func applyVoucher(fares map[string]int32, v Voucher) (map[string]int32, map[string]int32) {
+	discounted := make(map[string]int32, len(fares))
+	discounts := make(map[string]int32, len(fares))
+	for sec, fare := range fares {
+		discounts[sec] = v.discount(fare)
+		discounted[sec] = fare - discounts[sec]
+	}
+	return discounted, discounts
+}
+
+func voucherResponse(v Voucher) *proto.Voucher {
+	return &proto.Voucher{
+		Code:      v.Code,
+		Percent:   int32(v.Percent),
+		Amount:    v.Amount,
+		Currency:  v.Currency,
+		Routes:    v.Routes,
+		MaxUses:   int32(v.MaxUses),
+		Uses:      int32(v.Uses),
+		ExpiresAt: v.ExpiresAt,
+	}
+}
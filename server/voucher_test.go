@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVoucher_discount(t *testing.T) {
+	assert.Equal(t, int32(5), Voucher{Percent: 25}.discount(20))
+	assert.Equal(t, int32(7), Voucher{Percent: 33}.discount(21))
+	assert.Equal(t, int32(20), Voucher{Percent: 100}.discount(20))
+	assert.Equal(t, int32(3), Voucher{Amount: 3}.discount(20))
+	assert.Equal(t, int32(20), Voucher{Amount: 50}.discount(20))
+}
+
+func TestTrainServer_Vouchers(t *testing.T) {
+	s := newTestServer(t, func(conf *TrainConfig) {
+		conf.Routes = append(conf.Routes, RouteConfig{From: from2, To: to2, Price: price2}, RouteConfig{From: "Leeds", To: "York", Price: 1000, Currency: "EUR"})
+		conf.FX = FXConfig{Rates: map[string]string{"USD/EUR": "0.5"}}
+	})
+	admin := authContext(t, email1)
+	ctx := context.Background()
+
+	err := authorize(ctx, s, "CreateVoucher", &proto.Voucher{Code: "SPRING", Percent: 25})
+	assert.Error(t, err)
+	_, err = s.CreateVoucher(admin, &proto.Voucher{Code: "BOTH", Percent: 25, Amount: 5})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = s.CreateVoucher(admin, &proto.Voucher{Code: "FAR", Amount: 5, Routes: []int32{3}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.CreateVoucher(admin, &proto.Voucher{Code: "SPRING", Percent: 25, Routes: []int32{0}, MaxUses: 1})
+	assert.NoError(t, err)
+	_, err = s.CreateVoucher(admin, &proto.Voucher{Code: "SPRING", Amount: 5})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = s.CreateVoucher(admin, &proto.Voucher{Code: "POUND", Percent: 5, Currency: "GBP"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = s.CreateVoucher(admin, &proto.Voucher{Code: "OLD", Amount: 5, ExpiresAt: time.Now().Unix() - 1})
+	assert.NoError(t, err)
+	_, err = s.CreateVoucher(admin, &proto.Voucher{Code: "HOLD", Percent: 25, MaxUses: 1})
+	assert.NoError(t, err)
+
+	t.Run("discounted purchase", func(t *testing.T) {
+		req := holdRequest(email2)
+		req.Price, req.VoucherCode = 15, "SPRING"
+		ticket, err := s.PurchaseTicket(ctx, req)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(15), ticket.AmountCharged)
+		assert.Equal(t, price1, ticket.OriginalPrice)
+		assert.Equal(t, int32(5), ticket.Discount)
+
+		receipt, err := s.GetReceipt(authContext(t, email2), &proto.ReceiptRequest{Email: email2})
+		assert.NoError(t, err)
+		assert.Equal(t, "SPRING", receipt.VoucherCode)
+		assert.Equal(t, price1, receipt.OriginalPrice)
+		assert.Equal(t, int32(5), receipt.Discount)
+		assert.Equal(t, int32(15), receipt.AmountCharged)
+	})
+
+	t.Run("rejected vouchers", func(t *testing.T) {
+		req := holdRequest(email3)
+		req.VoucherCode = "SPRING"
+		_, err := s.PurchaseTicket(ctx, req)
+		assert.ErrorIs(t, err, errVoucherUsedUp)
+
+		req.VoucherCode = "OLD"
+		_, err = s.PurchaseTicket(ctx, req)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+		req.VoucherCode = "NONE"
+		_, err = s.PurchaseTicket(ctx, req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		req = &proto.PurchaseRequest{User: req.User, From: from2, To: to2, Price: price2, VoucherCode: "SPRING"}
+		_, err = s.PurchaseTicket(ctx, req)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	t.Run("cancelled booking stays redeemed", func(t *testing.T) {
+		_, err := s.RemoveUser(authContext(t, email2), &proto.RemoveUserRequest{Email: email2})
+		assert.NoError(t, err)
+		list, err := s.ListVouchers(admin, &proto.ListVouchersRequest{})
+		assert.NoError(t, err)
+		assert.Len(t, list.Vouchers, 3)
+		assert.Equal(t, "SPRING", list.Vouchers[2].Code)
+		assert.Equal(t, int32(1), list.Vouchers[2].Uses)
+
+		req := holdRequest(email2)
+		req.VoucherCode = "SPRING"
+		_, err = s.PurchaseTicket(ctx, req)
+		assert.ErrorIs(t, err, errVoucherUsedUp)
+	})
+
+	t.Run("released hold gives its use back", func(t *testing.T) {
+		req := holdRequest(email3)
+		req.VoucherCode = "HOLD"
+		hold, err := s.HoldSeat(ctx, req)
+		assert.NoError(t, err)
+		_, err = s.PurchaseTicket(ctx, &proto.PurchaseRequest{User: holdRequest(email4).User, From: from2, To: to2, Price: price2, VoucherCode: "HOLD"})
+		assert.ErrorIs(t, err, errVoucherUsedUp)
+		_, err = s.ReleaseHold(ctx, &proto.ReleaseHoldRequest{HoldId: hold.HoldId})
+		assert.NoError(t, err)
+		_, err = s.PurchaseTicket(ctx, req)
+		assert.NoError(t, err)
+	})
+
+	t.Run("amount in another currency", func(t *testing.T) {
+		created, err := s.CreateVoucher(admin, &proto.Voucher{Code: "TEN", Amount: 1000})
+		assert.NoError(t, err)
+		assert.Equal(t, "USD", created.Currency)
+		ticket, err := s.PurchaseTicket(ctx, &proto.PurchaseRequest{User: holdRequest(email5).User, From: "Leeds", To: "York", Price: 500, VoucherCode: "TEN"})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(500), ticket.Discount)
+		assert.Equal(t, int32(500), ticket.AmountCharged)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		deleted, err := s.DeleteVoucher(admin, &proto.DeleteVoucherRequest{Code: "SPRING"})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), deleted.Uses)
+		_, err = s.DeleteVoucher(admin, &proto.DeleteVoucherRequest{Code: "SPRING"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		// A new voucher with the same code starts afresh.
+		created, err := s.CreateVoucher(admin, &proto.Voucher{Code: "SPRING", Percent: 10})
+		assert.NoError(t, err)
+		assert.Zero(t, created.Uses)
+
+		receipt, err := s.GetReceipt(authContext(t, email3), &proto.ReceiptRequest{Email: email3})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(5), receipt.Discount)
+	})
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// JoinWaitlist queues a user for a sold out trip. Whenever a seat frees up
+// the first user in the queue gets it, under the waitlist id as booking id.
+func (s *TrainServer) JoinWaitlist(_ context.Context, req *proto.JoinWaitlistRequest) (*proto.JoinWaitlistResponse, error) {
+	if _, err := isValidUser(req.User); err != nil {
+		return nil, err
+	}
+	if err := checkTravellingAlone(req.User); err != nil {
+		return nil, err
+	}
+	index, seg, err := s.getRouteIndex(req.From, req.To, req.DepartsAt)
+	if err != nil {
+		return nil, err
+	}
+	trip := Trip{Route: int32(index), DepartsAt: req.DepartsAt}
+	totals, _ := s.addCharges(trip.Route, s.concessionFares(s.fares(trip, seg, time.Now()), req.User.PassengerType))
+	if cheapest(totals) > req.Price {
+		return nil, errNotEnoughMoney
+	}
+	if ok := s.isAlreadyPurchased(req.User.Email, trip); ok {
+		return nil, errAlreadyPurchased
+	}
+	if s.availableSeats(trip, seg) > 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "seats are still available, purchase a ticket instead")
+	}
+	entry := WaitlistEntry{ID: newBookingID(), Trip: trip, Segment: seg, User: req.User, Paid: req.Price}
+	position, err := s.Store.Enqueue(entry)
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.JoinWaitlistResponse{
+		WaitlistId: entry.ID,
+		Position:   int32(position),
+		Route:      trip.Route,
+		DepartsAt:  trip.DepartsAt,
+		Message:    "Joined the waitlist successfully",
+	}
+	// A seat may have been freed since we checked, in which case nobody else
+	// would promote the queue.
+	s.promoteWaitlist(trip)
+	if _, ok := s.Store.Lookup(entry.ID); ok {
+		resp.Position = 0
+		resp.Message = "Ticket purchased from the waitlist"
+	}
+	s.logger.Info("JoinWaitlist", resp)
+	return resp, nil
+}
+
+// promoteWaitlist gives free seats of trip to the users waiting for it, first
+// come first served, until either runs out. Users are charged the fare at the
+// time of promotion, and keep waiting while no free seat is within what they
+// offered to pay.
+func (s *TrainServer) promoteWaitlist(trip Trip) {
+	for _, w := range s.Store.Waitlist(trip) {
+		seats := seatRequest{segment: w.Segment}
+		if w.Paid > 0 {
+			totals, charges := s.addCharges(trip.Route, s.concessionFares(s.fares(trip, w.Segment, time.Now()), w.User.PassengerType))
+			fares, err := affordable(totals, w.Paid)
+			if err != nil {
+				continue
+			}
+			seats = seatRequest{segment: w.Segment, fares: fares, paid: w.Paid, charges: charges}
+		}
+		// Every attempt is a new payment: an earlier one may have been voided
+		// because no seat was left.
+		booking, err := s.purchase(context.Background(), newID("PR-"), s.newBooking(w.ID, w.Trip, w.User), seats)
+		switch {
+		case err == nil:
+			if _, err := s.Store.Dequeue(w.ID); err != nil {
+				// The user left the waitlist while we were booking.
+				_, _ = s.Store.Release(booking.ID)
+				s.voidPayment(booking.PaymentID)
+				continue
+			}
+			s.logger.Info("PromoteWaitlist", s.receipt(booking))
+			continue
+		case errors.Is(err, errAlreadyPurchased):
+			if _, ok := s.Store.Lookup(w.ID); ok {
+				// Promoted by a concurrent release, which takes the entry
+				// off the queue.
+				continue
+			}
+			// The user bought a ticket in the meantime and no longer waits.
+		case errors.Is(err, errPaymentFailed):
+			// Nothing was booked, so the seat goes to the next in line.
+			s.logger.Error(err, "PromoteWaitlist", "waitlist", w.ID)
+		case status.Code(err) == codes.FailedPrecondition:
+			// The seats left are beyond what this user offered to pay.
+			continue
+		default:
+			return
+		}
+		_, _ = s.Store.Dequeue(w.ID)
+	}
+}
+
+// leaveWaitlist takes waitlist entry id of the user with email, of anyone
+// when email is empty, off the queue for RemoveUser. It reports false when
+// there is no such entry, or it was promoted to a booking in the meantime.
+func (s *TrainServer) leaveWaitlist(email string, id string) (*proto.RemoveUserResponse, bool) {
+	if id == "" {
+		return nil, false
+	}
+	w, _, ok := s.Store.LookupWaitlist(id)
+	if !ok || (email != "" && w.User.Email != email) {
+		return nil, false
+	}
+	if _, err := s.Store.Dequeue(id); err != nil {
+		return nil, false
+	}
+	resp := &proto.RemoveUserResponse{
+		BookingId: w.ID,
+		Route:     w.Route,
+		DepartsAt: w.DepartsAt,
+		Currency:  s.routeCurrency(w.Route),
+		Message:   "Left the waitlist successfully",
+	}
+	s.logger.Info("RemoveUser", resp)
+	return resp, true
+}
+
+// checkWaitlisted reports a booking id which is still on the waitlist, so
+// GetReceipt can tell it apart from an unknown one.
+func (s *TrainServer) checkWaitlisted(email string, id string) error {
+	w, position, ok := s.Store.LookupWaitlist(id)
+	if !ok || (email != "" && w.User.Email != email) {
+		return nil
+	}
+	return status.Errorf(codes.FailedPrecondition, "booking %v is on the waitlist at position %d", id, position)
+}
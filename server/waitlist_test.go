@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	proto "github.com/playbody/train-ticket-service/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func waitlistRequest(email string) *proto.JoinWaitlistRequest {
+	return &proto.JoinWaitlistRequest{
+		User:  &proto.User{FirstName: firstName1, LastName: lastName1, Email: email},
+		From:  from1,
+		To:    to1,
+		Price: price1,
+	}
+}
+
+func TestTrainServer_JoinWaitlist(t *testing.T) {
+	s := newTestServer(t, nil)
+	ctx := context.Background()
+
+	_, err := s.JoinWaitlist(ctx, waitlistRequest("early@example.com"))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	var first string
+	for i := 0; i < seatCount*2; i++ {
+		ticket, err := s.PurchaseTicket(ctx, holdRequest(fmt.Sprintf("seated%d@example.com", i)))
+		assert.NoError(t, err)
+		if i == 0 {
+			first = ticket.BookingId
+		}
+	}
+	_, err = s.PurchaseTicket(ctx, holdRequest(email1))
+	assert.EqualError(t, err, "cannot find empty seat")
+
+	_, err = s.JoinWaitlist(ctx, waitlistRequest("seated0@example.com"))
+	assert.ErrorIs(t, err, errAlreadyPurchased)
+	w1, err := s.JoinWaitlist(ctx, waitlistRequest(email1))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), w1.Position)
+	w2, err := s.JoinWaitlist(ctx, waitlistRequest(email2))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), w2.Position)
+	_, err = s.JoinWaitlist(ctx, waitlistRequest(email2))
+	assert.ErrorIs(t, err, errAlreadyWaiting)
+
+	_, err = s.GetReceipt(authContext(t, email1), &proto.ReceiptRequest{Email: email1, BookingId: w1.WaitlistId})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	b, _ := s.Store.Lookup(first)
+	_, err = s.RemoveUser(authContext(t, b.User.Email), &proto.RemoveUserRequest{Email: b.User.Email, BookingId: first})
+	assert.NoError(t, err)
+
+	receipt, err := s.GetReceipt(authContext(t, email1), &proto.ReceiptRequest{Email: email1, BookingId: w1.WaitlistId})
+	assert.NoError(t, err)
+	assert.Equal(t, w1.WaitlistId, receipt.BookingId)
+	assert.Equal(t, b.Section, receipt.Section)
+	assert.Equal(t, b.Seat, receipt.Seat)
+	_, _, ok := s.Store.LookupWaitlist(w1.WaitlistId)
+	assert.False(t, ok)
+
+	_, position, ok := s.Store.LookupWaitlist(w2.WaitlistId)
+	assert.True(t, ok)
+	assert.Equal(t, 1, position)
+}
+
+// leavingStore lets the user waiting under id leave the waitlist while their
+// seat is being booked.
+type leavingStore struct {
+	BookingStore
+	id      string
+	payment *string
+}
+
+func (s leavingStore) Reserve(b Booking) error {
+	if err := s.BookingStore.Reserve(b); err != nil {
+		return err
+	}
+	if b.ID == s.id {
+		*s.payment = b.PaymentID
+		_, _ = s.BookingStore.Dequeue(b.ID)
+	}
+	return nil
+}
+
+func TestTrainServer_LeaveWaitlist(t *testing.T) {
+	gateway := NewFakeGateway()
+	s := &TrainServer{Conf: newTestStoreConfig(), Payments: gateway}
+	s.InitServer()
+	ctx := context.Background()
+	var seated []string
+	for i := 0; i < seatCount*2; i++ {
+		ticket, err := s.PurchaseTicket(ctx, holdRequest(fmt.Sprintf("seated%d@example.com", i)))
+		assert.NoError(t, err)
+		seated = append(seated, ticket.BookingId)
+	}
+	var waiting []string
+	for _, email := range []string{email1, email2, email3} {
+		w, err := s.JoinWaitlist(ctx, waitlistRequest(email))
+		assert.NoError(t, err)
+		waiting = append(waiting, w.WaitlistId)
+	}
+
+	_, err := s.RemoveUser(ctx, &proto.RemoveUserRequest{Email: email2, BookingId: waiting[0]})
+	assert.Error(t, err)
+	left, err := s.RemoveUser(ctx, &proto.RemoveUserRequest{Email: email1, BookingId: waiting[0]})
+	assert.NoError(t, err)
+	assert.Equal(t, waiting[0], left.BookingId)
+	assert.Zero(t, left.RefundAmount)
+	_, _, ok := s.Store.LookupWaitlist(waiting[0])
+	assert.False(t, ok)
+
+	// Email 2 leaves while the freed seat is being booked for them, so it
+	// is given back and goes to the next in line.
+	var payment string
+	s.Store = leavingStore{s.Store, waiting[1], &payment}
+	_, err = s.RemoveUser(ctx, &proto.RemoveUserRequest{BookingId: seated[0]})
+	assert.NoError(t, err)
+	assert.Empty(t, s.Store.ListByEmail(email1))
+	assert.Empty(t, s.Store.ListByEmail(email2))
+	assert.Len(t, s.Store.ListByEmail(email3), 1)
+	// Their payment was given back.
+	p, ok := gateway.Payment(payment)
+	assert.True(t, ok)
+	assert.Equal(t, p.Amount, p.Refunded)
+}